@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// provisioningStates are the states an admin may force a resource into
+var provisioningStates = []string{"Accepted", "Creating", "Provisioning", "Updating", "Deleting", "Succeeded", "Failed", "Canceled"}
+
+// adminResource is a row of the mock resource store. The raw JSON columns
+// are only returned for a single resource.
+type adminResource struct {
+	ID                string          `json:"id"`
+	ResourceType      string          `json:"resourceType"`
+	SubscriptionID    string          `json:"subscriptionId"`
+	ResourceGroup     string          `json:"resourceGroup"`
+	Name              string          `json:"name"`
+	Location          string          `json:"location,omitempty"`
+	ProvisioningState string          `json:"provisioningState"`
+	ActiveOperation   string          `json:"activeOperation,omitempty"`
+	ETag              string          `json:"etag"`
+	CreatedAt         time.Time       `json:"createdAt"`
+	UpdatedAt         time.Time       `json:"updatedAt"`
+	Properties        json.RawMessage `json:"properties,omitempty"`
+	Identity          json.RawMessage `json:"identity,omitempty"`
+	Tags              json.RawMessage `json:"tags,omitempty"`
+}
+
+// adminOperation is an async operation with its progress
+type adminOperation struct {
+	ID              string          `json:"id"`
+	ResourceID      string          `json:"resourceId"`
+	OperationType   string          `json:"operationType"`
+	Status          string          `json:"status"`
+	PercentComplete int             `json:"percentComplete"`
+	StartTime       time.Time       `json:"startTime"`
+	EndTime         *time.Time      `json:"endTime,omitempty"`
+	Error           *OperationError `json:"error,omitempty"`
+
+	// When a running operation times out, or a finished one is reaped
+	TimeoutAt *time.Time `json:"timeoutAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// adminHandler returns the admin API, served on ADMIN_PORT only
+//
+//	GET    /admin/resources?type=&state=&subscription=&resourceGroup=
+//	GET    /admin/resources/{id}          the row with its raw JSON columns
+//	PATCH  /admin/resources/{id}          {"provisioningState": "Failed"}
+//	DELETE /admin/resources/{id}          the row and its child resources
+//	GET    /admin/operations?status=&resourceId=&type=  with the timeout and retention
+//	GET    /admin/credentials, /admin/faults, /admin/clock
+func (p *AROHCPMockProxyEnhanced) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/resources", p.handleAdminResources)
+	mux.HandleFunc("/admin/resources/", p.handleAdminResource)
+	mux.HandleFunc("/admin/operations", p.handleAdminOperations)
+	mux.HandleFunc("/admin/credentials", p.handleAdminCredentials)
+	mux.HandleFunc("/admin/faults", p.faults.handleFaults)
+	mux.HandleFunc("/admin/faults/", p.faults.handleFaults)
+	mux.HandleFunc("/admin/clock", p.clock.handleClock)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+			Code:    "NotFound",
+			Message: fmt.Sprintf("No admin endpoint at '%s'.", r.URL.Path),
+		})
+	})
+	return mux
+}
+
+// serveAdmin serves the admin API on its own listener at addr. It returns
+// once the address is bound, so a taken port fails the start.
+func (p *AROHCPMockProxyEnhanced) serveAdmin(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := http.Serve(listener, p.admin); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Fatalf("Admin server failed: %v", err)
+		}
+	}()
+	return listener, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *AROHCPMockProxyEnhanced) toAdminResource(r *Resource, raw bool) adminResource {
+	resource := adminResource{
+		ID:                r.ID,
+		ResourceType:      r.ResourceType,
+		SubscriptionID:    r.SubscriptionID,
+		ResourceGroup:     r.ResourceGroup,
+		Name:              r.Name,
+		Location:          r.Location,
+		ProvisioningState: r.ProvisioningState,
+		CreatedAt:         r.CreatedAt,
+		UpdatedAt:         r.UpdatedAt,
+		ETag:              formatETag(r.ETag),
+	}
+	if op := p.asyncOps.ActiveOperation(r.ID); op != nil {
+		resource.ActiveOperation = op.ID
+	}
+	if raw {
+		for _, column := range []struct {
+			value string
+			out   *json.RawMessage
+		}{{r.Properties, &resource.Properties}, {r.Identity, &resource.Identity}, {r.Tags, &resource.Tags}} {
+			if column.value != "" && column.value != "null" && json.Valid([]byte(column.value)) {
+				*column.out = json.RawMessage(column.value)
+			}
+		}
+	}
+	return resource
+}
+
+// handleAdminResources lists the resource rows, filtered by resource type,
+// provisioning state, subscription and resource group
+func (p *AROHCPMockProxyEnhanced) handleAdminResources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+		return
+	}
+
+	where := []string{"1 = 1"}
+	var args []interface{}
+	for param, column := range map[string]string{
+		"type":          "resource_type",
+		"state":         "provisioning_state",
+		"subscription":  "subscription_id",
+		"resourceGroup": "resource_group",
+	} {
+		if value := r.URL.Query().Get(param); value != "" {
+			where = append(where, column+" = ? COLLATE NOCASE")
+			args = append(args, value)
+		}
+	}
+
+	resources := []adminResource{}
+	for _, resource := range p.queryResources(strings.Join(where, " AND "), args...) {
+		resources = append(resources, p.toAdminResource(resource, false))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": resources})
+}
+
+// handleAdminResource dumps, forces the provisioning state of, or deletes
+// one resource row
+func (p *AROHCPMockProxyEnhanced) handleAdminResource(w http.ResponseWriter, r *http.Request) {
+	resourceID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/admin/resources"), "/")
+	resources := p.queryResources("id = ? COLLATE NOCASE", resourceID)
+	if len(resources) == 0 {
+		writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+			Code:    "ResourceNotFound",
+			Message: fmt.Sprintf("The resource '%s' is not in the mock store.", resourceID),
+			Target:  resourceID,
+		})
+		return
+	}
+	resource := resources[0]
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, p.toAdminResource(resource, true))
+
+	case "PATCH":
+		var req struct {
+			ProvisioningState string `json:"provisioningState"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeCloudError(w, http.StatusBadRequest, invalidRequestContent(err))
+			return
+		}
+		state := ""
+		for _, s := range provisioningStates {
+			if strings.EqualFold(s, req.ProvisioningState) {
+				state = s
+			}
+		}
+		if state == "" {
+			writeCloudError(w, http.StatusBadRequest, &CloudErrorBody{
+				Code:    "InvalidProvisioningState",
+				Message: fmt.Sprintf("provisioningState must be one of %s.", strings.Join(provisioningStates, ", ")),
+				Target:  "provisioningState",
+			})
+			return
+		}
+		// The running operation would drive the resource on from the
+		// forced state; it ends with it instead
+		if op := p.asyncOps.ActiveOperation(resource.ID); op != nil {
+			status := "Canceled"
+			if state == "Failed" {
+				status = "Failed"
+			}
+			p.asyncOps.finishOperation(op, status, &OperationError{
+				Code:    status,
+				Message: fmt.Sprintf("An admin set the provisioning state of '%s' to %s.", resource.ID, state),
+			})
+		}
+		if _, err := p.db.Exec("UPDATE resources SET provisioning_state = ? WHERE id = ?", state, resource.ID); err != nil {
+			log.Printf("Database error updating %s: %v", resource.ID, err)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
+			return
+		}
+		log.Printf("Admin: forced %s from %s to %s", resource.ID, resource.ProvisioningState, state)
+		if updated, err := p.getResource(resource.ID); err == nil {
+			resource = updated
+		}
+		writeJSON(w, http.StatusOK, p.toAdminResource(resource, true))
+
+	case "DELETE":
+		for _, op := range p.asyncOps.activeOperationsUnder(resource.ID) {
+			p.asyncOps.finishOperation(op, "Canceled", &OperationError{
+				Code:    "Canceled",
+				Message: fmt.Sprintf("An admin deleted '%s'.", resource.ID),
+			})
+		}
+		p.resourceDeleted(resource.ID)
+		if _, err := p.db.Exec("DELETE FROM resources WHERE id = ? OR id LIKE ? ESCAPE '\\'", resource.ID, childrenOf(resource.ID)); err != nil {
+			log.Printf("Database error deleting %s: %v", resource.ID, err)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
+			return
+		}
+		log.Printf("Admin: deleted %s", resource.ID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+	}
+}
+
+// handleAdminOperations lists the async operations with their progress,
+// filtered by status, resource and operation type
+func (p *AROHCPMockProxyEnhanced) handleAdminOperations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+		return
+	}
+
+	where := []string{"1 = 1"}
+	var args []interface{}
+	for param, column := range map[string]string{
+		"status":     "status",
+		"resourceId": "resource_id",
+		"type":       "operation_type",
+	} {
+		if value := r.URL.Query().Get(param); value != "" {
+			where = append(where, column+" = ? COLLATE NOCASE")
+			args = append(args, value)
+		}
+	}
+	rows, err := p.db.Query(`SELECT `+operationColumns+` FROM operations WHERE `+
+		strings.Join(where, " AND ")+` ORDER BY start_time`, args...)
+	if err != nil {
+		log.Printf("Database error listing operations: %v", err)
+		writeCloudError(w, http.StatusInternalServerError, internalServerError())
+		return
+	}
+	defer rows.Close()
+
+	operations := []adminOperation{}
+	for rows.Next() {
+		stored, err := scanOperation(rows)
+		if err != nil {
+			log.Printf("Failed to scan operation: %v", err)
+			continue
+		}
+		// Running operations are ahead of their last saved state
+		p.asyncOps.mu.RLock()
+		op, live := p.asyncOps.operations[stored.ID]
+		p.asyncOps.mu.RUnlock()
+		if !live {
+			op = stored
+		}
+		op.mu.RLock()
+		operation := adminOperation{
+			ID:              op.ID,
+			ResourceID:      op.ResourceID,
+			OperationType:   op.OperationType,
+			Status:          op.Status,
+			PercentComplete: op.PercentComplete,
+			StartTime:       op.StartTime,
+			EndTime:         op.EndTime,
+			Error:           op.Error,
+		}
+		if timeout := p.config.AsyncOperationTimeout; op.Status == "InProgress" && timeout > 0 {
+			timeoutAt := op.StartTime.Add(timeout)
+			operation.TimeoutAt = &timeoutAt
+		}
+		if retention := p.config.OperationRetention; op.EndTime != nil && retention > 0 {
+			expiresAt := op.EndTime.Add(retention)
+			operation.ExpiresAt = &expiresAt
+		}
+		op.mu.RUnlock()
+		operations = append(operations, operation)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"asyncOperationTimeout": p.config.AsyncOperationTimeout.String(),
+		"operationRetention":    p.config.OperationRetention.String(),
+		"value":                 operations,
+	})
+}
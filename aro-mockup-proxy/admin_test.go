@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nonLoopbackIP returns an address of this host other than the loopback
+// one, or nil if it has none
+func nonLoopbackIP() net.IP {
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP
+		}
+	}
+	return nil
+}
+
+func TestAdminListener(t *testing.T) {
+	t.Setenv("ADMIN_PORT", "")
+	os.Unsetenv("ADMIN_PORT")
+	host, _, err := net.SplitHostPort(LoadConfig().AdminPort)
+	if err != nil {
+		t.Fatalf("default ADMIN_PORT: %v", err)
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		t.Fatalf("default ADMIN_PORT listens on %q, want the loopback address", host)
+	}
+
+	p := newTestProxy(t, nil)
+	listener, err := p.serveAdmin(net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Fatalf("serve admin API: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/admin/resources")
+	if err != nil {
+		t.Fatalf("admin API on the loopback address: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("admin API on the loopback address: status %d, want 200", resp.StatusCode)
+	}
+
+	// Other addresses of the host do not reach it
+	if ip := nonLoopbackIP(); ip != nil {
+		if conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), port), time.Second); err == nil {
+			conn.Close()
+			t.Errorf("admin API reachable on %s", ip)
+		}
+	}
+
+	// A taken port fails the start instead of logging in the background
+	if _, err := p.serveAdmin(listener.Addr().String()); err == nil {
+		t.Errorf("admin API started on a port in use")
+	}
+}
+
+func TestAdminNotOnProxyListener(t *testing.T) {
+	tests := []struct {
+		name        string
+		adminPort   string
+		wantMessage string
+	}{
+		{name: "own listener", adminPort: "127.0.0.1:8081", wantMessage: "served on 127.0.0.1:8081"},
+		{name: "disabled", wantMessage: "disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, func(c *Config) { c.AdminPort = tt.adminPort })
+			for _, path := range []string{"/admin/resources", "/admin/clock", "/admin/credentials"} {
+				w := serve(p, "GET", path, "")
+				if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), tt.wantMessage) {
+					t.Errorf("%s on the proxy listener: status %d %s, want 404 saying %q", path, w.Code, w.Body, tt.wantMessage)
+				}
+			}
+		})
+	}
+
+	// The admin listener answers unknown paths with a cloud error too
+	p := newTestProxy(t, nil)
+	w := httptest.NewRecorder()
+	p.admin.ServeHTTP(w, httptest.NewRequest("GET", "/admin/nothing", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "No admin endpoint at '/admin/nothing'") {
+		t.Errorf("unknown admin path: status %d %s, want 404", w.Code, w.Body)
+	}
+}
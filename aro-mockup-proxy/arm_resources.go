@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// armResourceKind describes a synchronous ARM resource type that the mock
+// serves from the resources table in offline mode (e.g. VNets, NSGs).
+// Unlike ARO-HCP resources these complete immediately and never go through
+// an async operation.
+type armResourceKind struct {
+	// ResourceType is the value stored in resources.resource_type
+	ResourceType string
+	// ARMType is the fully qualified type returned in responses
+	ARMType string
+
+	// validate runs before a PUT is stored and may reject it, e.g. when a
+	// parent or referenced resource does not exist
+	validate func(p *AROHCPMockProxyEnhanced, ref armResourceRef, body map[string]interface{}) *CloudErrorBody
+	// decorate adds read-only fields to a response
+	decorate func(p *AROHCPMockProxyEnhanced, res *Resource, response map[string]interface{})
+	// beforeDelete may reject a DELETE, e.g. when the resource is in use
+	beforeDelete func(p *AROHCPMockProxyEnhanced, ref armResourceRef) *CloudErrorBody
+	// afterDelete removes dependent rows once the resource itself is gone
+	afterDelete func(p *AROHCPMockProxyEnhanced, ref armResourceRef)
+}
+
+// armResourceRef identifies one resource addressed by a request path
+type armResourceRef struct {
+	SubscriptionID string
+	ResourceGroup  string
+	ID             string // canonical resource ID
+	Name           string // name stored in the database; child resources use "parent/child"
+}
+
+// displayName returns the last segment of the resource ID, which is what ARM
+// reports as "name" for both top-level and child resources
+func (ref armResourceRef) displayName() string {
+	return ref.ID[strings.LastIndex(ref.ID, "/")+1:]
+}
+
+func (p *AROHCPMockProxyEnhanced) handleARMResource(w http.ResponseWriter, r *http.Request, kind *armResourceKind, ref armResourceRef) {
+	// ARM resource IDs are case-insensitive: a request that differs from a
+	// stored resource only in casing addresses it under its stored ID
+	if stored, ok := p.storedRef(kind, ref); ok {
+		ref = stored
+	}
+
+	switch r.Method {
+	case "PUT":
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeCloudError(w, http.StatusBadRequest, invalidRequestContent(err))
+			return
+		}
+
+		// Subscription-scoped resources have no resource group
+		if ref.ResourceGroup != "" && !p.resourceGroupExists(ref.SubscriptionID, ref.ResourceGroup) {
+			writeCloudError(w, http.StatusNotFound, resourceGroupNotFound(ref.ResourceGroup))
+			return
+		}
+
+		if kind.validate != nil {
+			if verr := kind.validate(p, ref, body); verr != nil {
+				log.Printf("Rejected %s %s: %s", kind.ResourceType, ref.ID, verr.Message)
+				status := http.StatusBadRequest
+				switch verr.Code {
+				case "ParentResourceNotFound":
+					status = http.StatusNotFound
+				case "RoleAssignmentExists":
+					status = http.StatusConflict
+				}
+				writeCloudError(w, status, verr)
+				return
+			}
+		}
+
+		location, _ := body["location"].(string)
+		properties, _ := json.Marshal(body["properties"])
+		identity, _ := json.Marshal(body["identity"])
+		tags, _ := json.Marshal(body["tags"])
+
+		existing, _ := p.getResource(ref.ID)
+
+		_, err := p.db.Exec(`
+			INSERT INTO resources (id, resource_type, subscription_id, resource_group, name,
+				properties, identity, tags, location, provisioning_state, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 'Succeeded', CURRENT_TIMESTAMP)
+			ON CONFLICT(id) DO UPDATE SET
+				properties = excluded.properties,
+				identity = excluded.identity,
+				tags = excluded.tags,
+				location = excluded.location,
+				provisioning_state = 'Succeeded',
+				updated_at = CURRENT_TIMESTAMP
+		`, ref.ID, kind.ResourceType, ref.SubscriptionID, ref.ResourceGroup, ref.Name,
+			string(properties), string(identity), string(tags), location)
+		if err != nil {
+			log.Printf("Database error creating %s: %v", kind.ResourceType, err)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
+			return
+		}
+
+		res, err := p.getResource(ref.ID)
+		if err != nil {
+			log.Printf("Failed to retrieve created %s: %v", kind.ResourceType, err)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if existing == nil {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		json.NewEncoder(w).Encode(p.buildARMResourceResponse(kind, res))
+
+	case "GET":
+		res, err := p.getResource(ref.ID)
+		if err != nil || res.ResourceType != kind.ResourceType {
+			writeCloudError(w, http.StatusNotFound, resourceNotFound(kind.ARMType+"/"+ref.displayName(), ref.ResourceGroup))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.buildARMResourceResponse(kind, res))
+
+	case "DELETE":
+		if res, err := p.getResource(ref.ID); err != nil || res.ResourceType != kind.ResourceType {
+			// ARM treats deleting a missing resource as success
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if kind.beforeDelete != nil {
+			if verr := kind.beforeDelete(p, ref); verr != nil {
+				log.Printf("Rejected delete of %s %s: %s", kind.ResourceType, ref.ID, verr.Message)
+				writeCloudError(w, http.StatusBadRequest, verr)
+				return
+			}
+		}
+
+		if _, err := p.db.Exec("DELETE FROM resources WHERE id = ?", ref.ID); err != nil {
+			log.Printf("Database error deleting %s: %v", kind.ResourceType, err)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
+			return
+		}
+		if kind.afterDelete != nil {
+			kind.afterDelete(p, ref)
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+	}
+}
+
+// storedRef returns the reference of the stored resource of the given kind
+// whose ID matches ref.ID regardless of case
+func (p *AROHCPMockProxyEnhanced) storedRef(kind *armResourceKind, ref armResourceRef) (armResourceRef, bool) {
+	var stored armResourceRef
+	err := p.db.QueryRow(`
+		SELECT id, subscription_id, resource_group, name FROM resources
+		WHERE id = ? COLLATE NOCASE AND resource_type = ?
+	`, ref.ID, kind.ResourceType).Scan(&stored.ID, &stored.SubscriptionID, &stored.ResourceGroup, &stored.Name)
+	return stored, err == nil
+}
+
+func (p *AROHCPMockProxyEnhanced) buildARMResourceResponse(kind *armResourceKind, res *Resource) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":   res.ID,
+		"name": res.ID[strings.LastIndex(res.ID, "/")+1:],
+		"type": kind.ARMType,
+	}
+	if res.Location != "" {
+		response["location"] = res.Location
+	}
+
+	props := map[string]interface{}{}
+	if res.Properties != "" {
+		json.Unmarshal([]byte(res.Properties), &props)
+		if props == nil {
+			props = map[string]interface{}{}
+		}
+	}
+	props["provisioningState"] = res.ProvisioningState
+	response["properties"] = props
+
+	if res.Identity != "" {
+		var identity map[string]interface{}
+		if err := json.Unmarshal([]byte(res.Identity), &identity); err == nil && identity != nil {
+			response["identity"] = identity
+		}
+	}
+
+	if res.Tags != "" {
+		var tags map[string]interface{}
+		if err := json.Unmarshal([]byte(res.Tags), &tags); err == nil && tags != nil {
+			response["tags"] = tags
+		}
+	}
+
+	if kind.decorate != nil {
+		kind.decorate(p, res, response)
+	}
+	return response
+}
+
+func (p *AROHCPMockProxyEnhanced) resourceGroupExists(subscriptionID, resourceGroup string) bool {
+	return p.resourceExists(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionID, resourceGroup), "ResourceGroup")
+}
+
+// resourceExists reports whether a resource of the given type is stored
+// under id. ARM resource IDs are case-insensitive, so is the lookup.
+func (p *AROHCPMockProxyEnhanced) resourceExists(id, resourceType string) bool {
+	var count int
+	err := p.db.QueryRow(`
+		SELECT COUNT(*) FROM resources
+		WHERE id = ? COLLATE NOCASE AND resource_type = ?
+	`, id, resourceType).Scan(&count)
+	return err == nil && count > 0
+}
+
+// findReferencingResource returns the ID of a resource whose properties
+// point at id under one of the given JSON paths (e.g. "$.platform.subnetId")
+func (p *AROHCPMockProxyEnhanced) findReferencingResource(id string, jsonPaths ...string) (string, bool) {
+	for _, path := range jsonPaths {
+		var referrer string
+		err := p.db.QueryRow(`
+			SELECT id FROM resources
+			WHERE CASE WHEN json_valid(properties) THEN json_extract(properties, ?) END = ? COLLATE NOCASE
+			LIMIT 1
+		`, path, id).Scan(&referrer)
+		if err == nil {
+			return referrer, true
+		}
+	}
+	return "", false
+}
+
+// queryResources returns the resources matching the given WHERE clause,
+// ordered by ID
+func (p *AROHCPMockProxyEnhanced) queryResources(where string, args ...interface{}) []*Resource {
+	rows, err := p.db.Query(`
+		SELECT id, resource_type, subscription_id, resource_group, name,
+			properties, identity, tags, location, provisioning_state, created_at, updated_at, etag
+		FROM resources
+		WHERE `+where+`
+		ORDER BY id
+	`, args...)
+	if err != nil {
+		log.Printf("Database error listing resources: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var resources []*Resource
+	for rows.Next() {
+		var r Resource
+		err := rows.Scan(&r.ID, &r.ResourceType, &r.SubscriptionID, &r.ResourceGroup, &r.Name,
+			&r.Properties, &r.Identity, &r.Tags, &r.Location, &r.ProvisioningState, &r.CreatedAt, &r.UpdatedAt, &r.ETag)
+		if err != nil {
+			continue
+		}
+		resources = append(resources, &r)
+	}
+	return resources
+}
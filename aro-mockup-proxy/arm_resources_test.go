@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const (
+	testVNetID     = testResourceGroupID + "/providers/Microsoft.Network/virtualNetworks/v1"
+	testIdentityID = testResourceGroupID + "/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id1"
+	testRoleBody   = `{"properties":{"roleDefinitionId":"/subscriptions/s1/providers/Microsoft.Authorization/roleDefinitions/r1","principalId":"p1"}}`
+)
+
+// mustServe sends a request through the proxy and fails the test unless it
+// returns wantStatus
+func mustServe(t *testing.T, p *AROHCPMockProxyEnhanced, method, path, body string, wantStatus int) map[string]interface{} {
+	t.Helper()
+	w := serve(p, method, path, body)
+	if w.Code != wantStatus {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, w.Code, wantStatus, w.Body)
+	}
+	var response map[string]interface{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s %s: decode %s: %v", method, path, w.Body, err)
+		}
+	}
+	return response
+}
+
+func TestARMResourceCRUD(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup [][2]string // paths and bodies to PUT first
+		path  string
+		body  string
+		type_ string
+	}{
+		{
+			name:  "virtual network",
+			path:  testVNetID,
+			body:  `{"location":"eastus","properties":{"addressSpace":{"addressPrefixes":["10.0.0.0/16"]}}}`,
+			type_: "Microsoft.Network/virtualNetworks",
+		},
+		{
+			name:  "subnet",
+			setup: [][2]string{{testVNetID, `{"location":"eastus"}`}},
+			path:  testVNetID + "/subnets/s1",
+			body:  `{"properties":{"addressPrefix":"10.0.0.0/24"}}`,
+			type_: "Microsoft.Network/virtualNetworks/subnets",
+		},
+		{
+			name:  "network security group",
+			path:  testNSGID,
+			body:  `{"location":"eastus"}`,
+			type_: "Microsoft.Network/networkSecurityGroups",
+		},
+		{
+			name:  "user-assigned identity",
+			path:  testIdentityID,
+			body:  `{"location":"eastus"}`,
+			type_: "Microsoft.ManagedIdentity/userAssignedIdentities",
+		},
+		{
+			name:  "federated identity credential",
+			setup: [][2]string{{testIdentityID, `{"location":"eastus"}`}},
+			path:  testIdentityID + "/federatedIdentityCredentials/f1",
+			body:  `{"properties":{"issuer":"https://issuer.example","subject":"system:serviceaccount:ns:sa","audiences":["api://AzureADTokenExchange"]}}`,
+			type_: "Microsoft.ManagedIdentity/userAssignedIdentities/federatedIdentityCredentials",
+		},
+		{
+			name:  "role assignment on a resource",
+			setup: [][2]string{{testNSGID, `{"location":"eastus"}`}},
+			path:  testNSGID + "/providers/Microsoft.Authorization/roleAssignments/ra1",
+			body:  testRoleBody,
+			type_: "Microsoft.Authorization/roleAssignments",
+		},
+		{
+			name:  "role assignment on a resource group",
+			path:  testResourceGroupID + "/providers/Microsoft.Authorization/roleAssignments/ra1",
+			body:  testRoleBody,
+			type_: "Microsoft.Authorization/roleAssignments",
+		},
+		{
+			name:  "role assignment on a subscription",
+			path:  "/subscriptions/s1/providers/Microsoft.Authorization/roleAssignments/ra1",
+			body:  testRoleBody,
+			type_: "Microsoft.Authorization/roleAssignments",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newOfflineTestProxy(t)
+			mustServe(t, p, "PUT", testResourceGroupID, `{"location":"eastus"}`, http.StatusCreated)
+			for _, setup := range tt.setup {
+				mustServe(t, p, "PUT", setup[0], setup[1], http.StatusCreated)
+			}
+
+			created := mustServe(t, p, "PUT", tt.path, tt.body, http.StatusCreated)
+			if created["id"] != tt.path || created["type"] != tt.type_ {
+				t.Errorf("created %v %v, want %s %s", created["type"], created["id"], tt.type_, tt.path)
+			}
+
+			// Resource IDs are case-insensitive, the stored ID is kept
+			updated := mustServe(t, p, "PUT", strings.ToUpper(tt.path), tt.body, http.StatusOK)
+			if updated["id"] != tt.path {
+				t.Errorf("updated %v, want %s", updated["id"], tt.path)
+			}
+			got := mustServe(t, p, "GET", strings.ToLower(tt.path), "", http.StatusOK)
+			if got["id"] != tt.path {
+				t.Errorf("got %v, want %s", got["id"], tt.path)
+			}
+
+			mustServe(t, p, "DELETE", tt.path, "", http.StatusOK)
+			mustServe(t, p, "GET", tt.path, "", http.StatusNotFound)
+			mustServe(t, p, "DELETE", tt.path, "", http.StatusNoContent)
+		})
+	}
+}
+
+func TestARMResourceChecks(t *testing.T) {
+	subnetID := testVNetID + "/subnets/s1"
+	roleAssignmentID := testResourceGroupID + "/providers/Microsoft.Authorization/roleAssignments/ra1"
+
+	tests := []struct {
+		name       string
+		setup      [][2]string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:   "missing resource group",
+			method: "PUT", path: "/subscriptions/s1/resourceGroups/rg2/providers/Microsoft.Network/networkSecurityGroups/n1", body: `{}`,
+			wantStatus: http.StatusNotFound, wantCode: "ResourceGroupNotFound",
+		},
+		{
+			name:   "subnet without its virtual network",
+			method: "PUT", path: subnetID, body: `{}`,
+			wantStatus: http.StatusNotFound, wantCode: "ParentResourceNotFound",
+		},
+		{
+			name:   "subnet with a missing NSG",
+			setup:  [][2]string{{testVNetID, `{}`}},
+			method: "PUT", path: subnetID, body: `{"properties":{"networkSecurityGroup":{"id":"` + testNSGID + `"}}}`,
+			wantStatus: http.StatusBadRequest, wantCode: "InvalidResourceReference",
+		},
+		{
+			name:   "NSG in use by a subnet",
+			setup:  [][2]string{{testNSGID, `{}`}, {testVNetID, `{}`}, {subnetID, `{"properties":{"networkSecurityGroup":{"id":"` + testNSGID + `"}}}`}},
+			method: "DELETE", path: testNSGID,
+			wantStatus: http.StatusBadRequest, wantCode: "InUseNetworkSecurityGroupCannotBeDeleted",
+		},
+		{
+			name:   "federated credential without its identity",
+			method: "PUT", path: testIdentityID + "/federatedIdentityCredentials/f1", body: `{}`,
+			wantStatus: http.StatusNotFound, wantCode: "ParentResourceNotFound",
+		},
+		{
+			name:   "federated credential without a subject",
+			setup:  [][2]string{{testIdentityID, `{}`}},
+			method: "PUT", path: testIdentityID + "/federatedIdentityCredentials/f1", body: `{"properties":{"issuer":"https://issuer.example","audiences":["a"]}}`,
+			wantStatus: http.StatusBadRequest, wantCode: "BadRequest",
+		},
+		{
+			name:   "role assignment on a missing scope",
+			method: "PUT", path: testNSGID + "/providers/Microsoft.Authorization/roleAssignments/ra1", body: testRoleBody,
+			wantStatus: http.StatusNotFound, wantCode: "ParentResourceNotFound",
+		},
+		{
+			name:   "role assignment without a principal",
+			method: "PUT", path: roleAssignmentID, body: `{"properties":{"roleDefinitionId":"r1"}}`,
+			wantStatus: http.StatusBadRequest, wantCode: "InvalidRoleAssignmentRequest",
+		},
+		{
+			name:   "role assigned twice",
+			setup:  [][2]string{{roleAssignmentID, testRoleBody}},
+			method: "PUT", path: testResourceGroupID + "/providers/Microsoft.Authorization/roleAssignments/ra2", body: testRoleBody,
+			wantStatus: http.StatusConflict, wantCode: "RoleAssignmentExists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newOfflineTestProxy(t)
+			mustServe(t, p, "PUT", testResourceGroupID, `{"location":"eastus"}`, http.StatusCreated)
+			for _, setup := range tt.setup {
+				mustServe(t, p, "PUT", setup[0], setup[1], http.StatusCreated)
+			}
+
+			response := mustServe(t, p, tt.method, tt.path, tt.body, tt.wantStatus)
+			cloudError, _ := response["error"].(map[string]interface{})
+			if code, _ := cloudError["code"].(string); code != tt.wantCode {
+				t.Errorf("error code %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	assignments := []roleAssignment{
+		{scope: "/subscriptions/s1", roleDefinitionID: "/providers/Microsoft.Authorization/roleDefinitions/R1"},
+		{scope: testVNetID, roleDefinitionID: "/subscriptions/s1/providers/Microsoft.Authorization/roleDefinitions/r2"},
+	}
+
+	tests := []struct {
+		name             string
+		roleDefinitionID string
+		scope            string
+		want             bool
+	}{
+		{name: "under the subscription", roleDefinitionID: "r1", scope: testVNetID, want: true},
+		{name: "on the subscription", roleDefinitionID: "r1", scope: "/subscriptions/S1", want: true},
+		{name: "other subscription", roleDefinitionID: "r1", scope: "/subscriptions/s2", want: false},
+		{name: "on the resource", roleDefinitionID: "r2", scope: testVNetID, want: true},
+		{name: "below the resource", roleDefinitionID: "r2", scope: testVNetID + "/subnets/s1", want: true},
+		{name: "above the resource", roleDefinitionID: "r2", scope: testResourceGroupID, want: false},
+		{name: "any scope", roleDefinitionID: "r2", want: true},
+		{name: "other role", roleDefinitionID: "r3", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasRole(assignments, tt.roleDefinitionID, tt.scope); got != tt.want {
+				t.Errorf("hasRole(%s, %s) = %v, want %v", tt.roleDefinitionID, tt.scope, got, tt.want)
+			}
+		})
+	}
+}
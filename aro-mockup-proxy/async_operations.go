@@ -7,8 +7,11 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // AsyncOperation represents a long-running Azure operation
@@ -25,55 +28,69 @@ type AsyncOperation struct {
 	mu               sync.RWMutex
 }
 
-type OperationError struct {
-	Code    string
-	Message string
-}
+// OperationError is the error of a failed operation. It has the shape of an
+// ARM error body so the operation status can return it as is.
+type OperationError = CloudErrorBody
 
-// AsyncOperationManager manages async operations
+// AsyncOperationManager manages async operations. Operations are kept in
+// memory for fast polling and mirrored to the "operations" table so that
+// they survive a proxy restart.
 type AsyncOperationManager struct {
 	operations map[string]*AsyncOperation
 	mu         sync.RWMutex
 	config     *Config
+	db         *sql.DB
+	metrics    *Metrics
+	clock      *virtualClock
+
+	// inflightCheck, if set, runs when a Create has finished provisioning
+	// and may fail it the way the RP's inflight checks do
+	inflightCheck func(resourceID string) *OperationError
+
+	// finalize, if set, runs before a resource operation records its
+	// successful result, e.g. to start or tear down what backs the resource
+	finalize func(op *AsyncOperation)
+
+	// faults holds the rules that fail or hang matching operations
+	faults *faultInjector
+
+	// scenarios script the timelines of matching operations
+	scenarios []*scenario
 }
 
-func NewAsyncOperationManager(config *Config) *AsyncOperationManager {
+func NewAsyncOperationManager(config *Config, db *sql.DB) *AsyncOperationManager {
 	return &AsyncOperationManager{
 		operations: make(map[string]*AsyncOperation),
 		config:     config,
+		db:         db,
+		clock:      newVirtualClock(config.ClockSpeed),
 	}
 }
 
 // StartOperation creates a new async operation and starts processing
-func (m *AsyncOperationManager) StartOperation(resourceID, operationType string, db *sql.DB) *AsyncOperation {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	operationID := fmt.Sprintf("op-%s-%d", generateShortID(), time.Now().Unix())
-
-	op := &AsyncOperation{
-		ID:            operationID,
-		ResourceID:    resourceID,
-		OperationType: operationType,
-		Status:        "InProgress",
-		PercentComplete: 0,
-		StartTime:     time.Now(),
-	}
-
-	m.operations[operationID] = op
+func (m *AsyncOperationManager) StartOperation(resourceID, operationType string) *AsyncOperation {
+	op := m.newOperation(resourceID, operationType, nil)
 
 	// Start background processing
-	go m.processOperation(op, db)
+	go m.processOperation(op)
 
 	return op
 }
 
 // StartOperationWithResult creates a new async operation with a custom result
 func (m *AsyncOperationManager) StartOperationWithResult(resourceID, operationType string, result interface{}) *AsyncOperation {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	op := m.newOperation(resourceID, operationType, result)
 
-	operationID := fmt.Sprintf("op-%s-%d", generateShortID(), time.Now().Unix())
+	// Start background processing without database updates
+	go m.processOperationWithResult(op)
+
+	return op
+}
+
+// newOperation registers a new InProgress operation and persists it.
+func (m *AsyncOperationManager) newOperation(resourceID, operationType string, result interface{}) *AsyncOperation {
+	m.mu.Lock()
+	operationID := uuid.NewString()
 
 	op := &AsyncOperation{
 		ID:              operationID,
@@ -81,149 +98,411 @@ func (m *AsyncOperationManager) StartOperationWithResult(resourceID, operationTy
 		OperationType:   operationType,
 		Status:          "InProgress",
 		PercentComplete: 0,
-		StartTime:       time.Now(),
+		StartTime:       m.clock.Now(),
 		Result:          result,
 	}
 
 	m.operations[operationID] = op
+	m.mu.Unlock()
 
-	// Start background processing without database updates
-	go m.processOperationWithResult(op)
-
+	m.saveOperation(op)
+	m.metrics.OperationStarted(operationType)
 	return op
 }
 
 // processOperationWithResult processes operations that have custom results
 func (m *AsyncOperationManager) processOperationWithResult(op *AsyncOperation) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Panic in async operation %s: %v", op.ID, r)
-			op.mu.Lock()
-			op.Status = "Failed"
-			now := time.Now()
-			op.EndTime = &now
-			op.Error = &OperationError{
-				Code:    "InternalError",
-				Message: fmt.Sprintf("Operation failed: %v", r),
-			}
-			op.mu.Unlock()
-		}
-	}()
+	defer m.recoverOperation(op)
+
+	rule := m.awaitFault(op)
 
 	// Simulate provisioning progress
+	m.runStages(op)
+
+	if m.finished(op) || m.injectFailure(op, rule) {
+		return
+	}
+
+	m.completeOperation(op)
+}
+
+// awaitFault returns the fault rule that triggers for an operation. A hang
+// rule blocks until it is removed and is not returned.
+func (m *AsyncOperationManager) awaitFault(op *AsyncOperation) *faultRule {
+	rule := m.faults.operationFault(op)
+	if rule == nil || rule.Action != faultHang {
+		return rule
+	}
+	log.Printf("Operation %s: hanging on fault rule %s", op.ID, rule.ID)
+	<-rule.released
+	log.Printf("Operation %s: released by fault rule %s", op.ID, rule.ID)
+	return nil
+}
+
+// injectFailure fails an operation and its resource if a fail rule
+// triggered for it, and reports whether it did
+func (m *AsyncOperationManager) injectFailure(op *AsyncOperation, rule *faultRule) bool {
+	if rule == nil || rule.Action != faultFail {
+		return false
+	}
+	log.Printf("Operation %s: failed by fault rule %s with %s", op.ID, rule.ID, rule.Code)
+	if m.db != nil && op.OperationType != "RequestAdminCredential" {
+		// A failed delete leaves the resource behind in the Failed state
+		m.db.Exec("UPDATE resources SET provisioning_state = 'Failed' WHERE id = ? AND (provisioning_state != 'Deleting' OR ? = 'Delete')",
+			op.ResourceID, op.OperationType)
+	}
+	m.failOperation(op, rule.Code, rule.Message)
+	return true
+}
+
+// runStages simulates provisioning progress. Each stage is due at a fixed
+// offset from StartTime, so an operation resumed after a restart picks up
+// where it left off instead of starting over.
+func (m *AsyncOperationManager) runStages(op *AsyncOperation) {
 	stages := []int{10, 25, 50, 75, 90, 100}
 	delay := m.config.ProvisioningDelay / time.Duration(len(stages))
 
-	for _, percent := range stages {
-		time.Sleep(delay)
+	op.mu.RLock()
+	start := op.StartTime
+	current := op.PercentComplete
+	op.mu.RUnlock()
+
+	for i, percent := range stages {
+		if percent <= current {
+			continue
+		}
+		m.clock.SleepUntil(start.Add(delay * time.Duration(i+1)))
+		if m.finished(op) {
+			return
+		}
 		op.mu.Lock()
 		op.PercentComplete = percent
 		op.mu.Unlock()
+		m.saveOperation(op)
 
 		log.Printf("Operation %s: %d%% complete", op.ID, percent)
 	}
+}
 
-	// Mark as succeeded
+// recoverOperation marks the operation as failed if its processing
+// goroutine panicked. It must be deferred.
+func (m *AsyncOperationManager) recoverOperation(op *AsyncOperation) {
+	if r := recover(); r != nil {
+		log.Printf("Panic in async operation %s: %v", op.ID, r)
+		m.failOperation(op, "InternalError", fmt.Sprintf("Operation failed: %v", r))
+	}
+}
+
+// completeOperation marks the operation as succeeded
+func (m *AsyncOperationManager) completeOperation(op *AsyncOperation) {
 	op.mu.Lock()
+	if op.Status != "InProgress" {
+		op.mu.Unlock()
+		return
+	}
 	op.Status = "Succeeded"
 	op.PercentComplete = 100
-	now := time.Now()
+	now := m.clock.Now()
 	op.EndTime = &now
 	op.mu.Unlock()
+	m.saveOperation(op)
+	m.metrics.OperationFinished(op.OperationType, "Succeeded", now.Sub(op.StartTime))
 
 	log.Printf("Operation %s completed successfully", op.ID)
 }
 
-// GetOperation retrieves an operation by ID
+// failOperation marks the operation as failed with the given error
+func (m *AsyncOperationManager) failOperation(op *AsyncOperation, code, message string) {
+	m.finishOperation(op, "Failed", &OperationError{
+		Code:    code,
+		Message: message,
+	})
+}
+
+// finishOperation ends the operation with a Failed or Canceled status
+func (m *AsyncOperationManager) finishOperation(op *AsyncOperation, status string, oerr *OperationError) {
+	op.mu.Lock()
+	if op.Status != "InProgress" {
+		op.mu.Unlock()
+		return
+	}
+	op.Status = status
+	now := m.clock.Now()
+	op.EndTime = &now
+	op.Error = oerr
+	op.mu.Unlock()
+	m.saveOperation(op)
+	m.metrics.OperationFinished(op.OperationType, status, now.Sub(op.StartTime))
+}
+
+// GetOperation retrieves an operation by ID. Operations that are no longer
+// in memory (e.g. finished before a restart) are loaded from the database.
 func (m *AsyncOperationManager) GetOperation(operationID string) (*AsyncOperation, error) {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
-
 	op, exists := m.operations[operationID]
-	if !exists {
+	m.mu.RUnlock()
+	if exists {
+		return op, nil
+	}
+
+	op, err := m.loadOperation(operationID)
+	if err != nil {
 		return nil, fmt.Errorf("operation not found")
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.operations[operationID]; ok {
+		return existing, nil
+	}
+	m.operations[operationID] = op
 	return op, nil
 }
 
 // processOperation simulates async processing
-func (m *AsyncOperationManager) processOperation(op *AsyncOperation, db *sql.DB) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Panic in async operation %s: %v", op.ID, r)
-			op.mu.Lock()
-			op.Status = "Failed"
-			now := time.Now()
-			op.EndTime = &now
-			op.Error = &OperationError{
-				Code:    "InternalError",
-				Message: fmt.Sprintf("Operation failed: %v", r),
-			}
-			op.mu.Unlock()
-		}
-	}()
+func (m *AsyncOperationManager) processOperation(op *AsyncOperation) {
+	defer m.recoverOperation(op)
 
 	// Simulate failure if configured
 	if m.config.SimulateFailures && rand.Float64() < m.config.FailureRate {
-		time.Sleep(2 * time.Second)
-		op.mu.Lock()
-		op.Status = "Failed"
-		now := time.Now()
-		op.EndTime = &now
-		op.Error = &OperationError{
-			Code:    "SimulatedFailure",
-			Message: "Simulated failure for testing",
-		}
-		op.mu.Unlock()
+		m.clock.Sleep(2 * time.Second)
+		m.failOperation(op, "SimulatedFailure", "Simulated failure for testing")
 		return
 	}
 
-	// Simulate provisioning progress
-	stages := []int{10, 25, 50, 75, 90, 100}
-	delay := m.config.ProvisioningDelay / time.Duration(len(stages))
+	rule := m.awaitFault(op)
 
-	for _, percent := range stages {
-		time.Sleep(delay)
-		op.mu.Lock()
-		op.PercentComplete = percent
-		op.mu.Unlock()
+	// Simulate provisioning progress, scripted by a scenario if one
+	// applies
+	sc := m.scenarioFor(op)
+	if sc != nil {
+		if !m.runScenario(op, sc) {
+			return
+		}
+	} else {
+		m.runStages(op)
+	}
 
-		log.Printf("Operation %s: %d%% complete", op.ID, percent)
+	// A timed out operation has already failed its resource
+	if m.finished(op) || m.injectFailure(op, rule) {
+		return
 	}
 
-	// Update resource provisioning state in database
-	if db != nil {
-		_, err := db.Exec(`
-			UPDATE resources
-			SET provisioning_state = ?
-			WHERE id = ?
-		`, "Succeeded", op.ResourceID)
+	if op.OperationType == "Create" && m.inflightCheck != nil {
+		if oerr := m.inflightCheck(op.ResourceID); oerr != nil {
+			log.Printf("Operation %s: %s", op.ID, oerr.Message)
+			if m.db != nil {
+				m.db.Exec("UPDATE resources SET provisioning_state = 'Failed' WHERE id = ? AND provisioning_state != 'Deleting'", op.ResourceID)
+			}
+			m.failOperation(op, oerr.Code, oerr.Message)
+			return
+		}
+	}
+
+	if m.finalize != nil {
+		m.finalize(op)
+	}
+
+	// Update resource in database: a finished delete removes the row and
+	// its child resources, anything else becomes Succeeded unless its
+	// parent's delete has taken it over in the meantime.
+	if m.db != nil {
+		var err error
+		if op.OperationType == "Delete" {
+			_, err = m.db.Exec("DELETE FROM resources WHERE id = ? OR id LIKE ? ESCAPE '\\'", op.ResourceID, childrenOf(op.ResourceID))
+		} else {
+			_, err = m.db.Exec(`
+				UPDATE resources
+				SET provisioning_state = ?
+				WHERE id = ? AND provisioning_state != 'Deleting'
+			`, "Succeeded", op.ResourceID)
+		}
 
 		if err != nil {
 			log.Printf("Failed to update resource state: %v", err)
-			op.mu.Lock()
-			op.Status = "Failed"
-			now := time.Now()
-			op.EndTime = &now
-			op.Error = &OperationError{
-				Code:    "DatabaseError",
-				Message: err.Error(),
-			}
-			op.mu.Unlock()
+			m.failOperation(op, "DatabaseError", err.Error())
 			return
 		}
 	}
 
-	// Mark as succeeded
-	op.mu.Lock()
-	op.Status = "Succeeded"
-	op.PercentComplete = 100
-	now := time.Now()
-	op.EndTime = &now
-	op.mu.Unlock()
+	m.completeOperation(op)
+	if sc != nil {
+		go m.continueScenario(op, sc)
+	}
+}
 
-	log.Printf("Operation %s completed successfully", op.ID)
+// saveOperation writes the current state of the operation to the database
+func (m *AsyncOperationManager) saveOperation(op *AsyncOperation) {
+	if m.db == nil {
+		return
+	}
+
+	op.mu.RLock()
+	var endTime interface{}
+	if op.EndTime != nil {
+		endTime = *op.EndTime
+	}
+	var errorCode, errorMessage interface{}
+	if op.Error != nil {
+		errorCode = op.Error.Code
+		errorMessage = op.Error.Message
+	}
+	// The kubeconfig of an admin credential holds its private key, which
+	// is kept in memory only
+	var result interface{}
+	if op.Result != nil && op.OperationType != "RequestAdminCredential" {
+		if b, err := json.Marshal(op.Result); err == nil {
+			result = string(b)
+		}
+	}
+	args := []interface{}{op.ID, op.ResourceID, op.OperationType, op.Status, op.PercentComplete,
+		op.StartTime, endTime, errorCode, errorMessage, result}
+	op.mu.RUnlock()
+
+	_, err := m.db.Exec(`
+		INSERT INTO operations (id, resource_id, operation_type, status, percent_complete,
+			start_time, end_time, error_code, error_message, result)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			status = excluded.status,
+			percent_complete = excluded.percent_complete,
+			end_time = excluded.end_time,
+			error_code = excluded.error_code,
+			error_message = excluded.error_message,
+			result = excluded.result
+	`, args...)
+	if err != nil {
+		log.Printf("Failed to persist operation %s: %v", op.ID, err)
+	}
+}
+
+const operationColumns = `id, resource_id, operation_type, status, percent_complete,
+	start_time, end_time, error_code, error_message, result`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOperation(row rowScanner) (*AsyncOperation, error) {
+	var op AsyncOperation
+	var endTime sql.NullTime
+	var errorCode, errorMessage, result sql.NullString
+	err := row.Scan(&op.ID, &op.ResourceID, &op.OperationType, &op.Status, &op.PercentComplete,
+		&op.StartTime, &endTime, &errorCode, &errorMessage, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if endTime.Valid {
+		t := endTime.Time
+		op.EndTime = &t
+	}
+	if errorCode.Valid {
+		op.Error = &OperationError{
+			Code:    errorCode.String,
+			Message: errorMessage.String,
+		}
+	}
+	if result.Valid {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(result.String), &v); err == nil {
+			op.Result = v
+		}
+	}
+	return &op, nil
+}
+
+func (m *AsyncOperationManager) loadOperation(operationID string) (*AsyncOperation, error) {
+	if m.db == nil {
+		return nil, sql.ErrNoRows
+	}
+	row := m.db.QueryRow(`SELECT `+operationColumns+` FROM operations WHERE id = ?`, operationID)
+	return scanOperation(row)
+}
+
+// ResumeOperations reloads operations that were still InProgress when the
+// proxy stopped and continues processing them from their original
+// StartTime, so polling URLs handed out before the restart stay valid.
+func (m *AsyncOperationManager) ResumeOperations() {
+	if m.db == nil {
+		return
+	}
+
+	rows, err := m.db.Query(`SELECT ` + operationColumns + ` FROM operations WHERE status = 'InProgress'`)
+	if err != nil {
+		log.Printf("Warning: failed to load in-progress operations: %v", err)
+		return
+	}
+
+	var resumed []*AsyncOperation
+	for rows.Next() {
+		op, err := scanOperation(rows)
+		if err != nil {
+			log.Printf("Warning: failed to load operation: %v", err)
+			continue
+		}
+		resumed = append(resumed, op)
+	}
+	rows.Close()
+
+	m.mu.Lock()
+	for _, op := range resumed {
+		m.operations[op.ID] = op
+	}
+	m.mu.Unlock()
+
+	for _, op := range resumed {
+		m.metrics.OperationStarted(op.OperationType)
+		log.Printf("Resuming %s operation %s for %s (%d%% complete)", op.OperationType, op.ID, op.ResourceID, op.PercentComplete)
+		if op.OperationType == "RequestAdminCredential" {
+			m.failOperation(op, "InternalServerError", "The credential was lost when the service restarted. Request a new one.")
+			continue
+		}
+		if op.Result != nil {
+			go m.processOperationWithResult(op)
+		} else {
+			go m.processOperation(op)
+		}
+	}
+}
+
+// HasActiveOperation reports whether an InProgress operation exists for the resource
+func (m *AsyncOperationManager) HasActiveOperation(resourceID string) bool {
+	return m.ActiveOperation(resourceID) != nil
+}
+
+// ActiveOperation returns the in-progress operation on a resource, or nil
+func (m *AsyncOperationManager) ActiveOperation(resourceID string) *AsyncOperation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, op := range m.operations {
+		op.mu.RLock()
+		active := strings.EqualFold(op.ResourceID, resourceID) && op.Status == "InProgress"
+		op.mu.RUnlock()
+		if active {
+			return op
+		}
+	}
+	return nil
+}
+
+// activeOperationsUnder returns the in-progress operations on a resource
+// and its child resources
+func (m *AsyncOperationManager) activeOperationsUnder(resourceID string) []*AsyncOperation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var active []*AsyncOperation
+	prefix := strings.ToLower(resourceID) + "/"
+	for _, op := range m.operations {
+		op.mu.RLock()
+		if op.Status == "InProgress" && (strings.EqualFold(op.ResourceID, resourceID) || strings.HasPrefix(strings.ToLower(op.ResourceID), prefix)) {
+			active = append(active, op)
+		}
+		op.mu.RUnlock()
+	}
+	return active
 }
 
 // ServeHTTP handles async operation status requests
@@ -232,7 +511,7 @@ func (m *AsyncOperationManager) ServeHTTP(w http.ResponseWriter, r *http.Request
 	// Expected: /operations/{operationID}
 	pathParts := splitPath(r.URL.Path)
 	if len(pathParts) < 2 {
-		http.Error(w, "Invalid operation path", http.StatusBadRequest)
+		writeCloudError(w, http.StatusBadRequest, invalidResourceID(r.URL.Path))
 		return
 	}
 
@@ -240,7 +519,10 @@ func (m *AsyncOperationManager) ServeHTTP(w http.ResponseWriter, r *http.Request
 
 	op, err := m.GetOperation(operationID)
 	if err != nil {
-		http.Error(w, "Operation not found", http.StatusNotFound)
+		writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+			Code:    "ResourceNotFound",
+			Message: fmt.Sprintf("The operation '%s' was not found.", operationID),
+		})
 		return
 	}
 
@@ -263,10 +545,7 @@ func (m *AsyncOperationManager) ServeHTTP(w http.ResponseWriter, r *http.Request
 	}
 
 	if op.Error != nil {
-		response["error"] = map[string]interface{}{
-			"code":    op.Error.Code,
-			"message": op.Error.Message,
-		}
+		response["error"] = op.Error
 	}
 
 	// Add standard Azure async operation fields
@@ -293,15 +572,6 @@ func (m *AsyncOperationManager) ServeHTTP(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(response)
 }
 
-func generateShortID() string {
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, 8)
-	for i := range b {
-		b[i] = charset[rand.Intn(len(charset))]
-	}
-	return string(b)
-}
-
 func splitPath(path string) []string {
 	var parts []string
 	for _, p := range split(path, '/') {
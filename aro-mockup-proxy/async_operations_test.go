@@ -0,0 +1,280 @@
+package main
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDB returns an initialized mock store in a temporary directory
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "mock.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := initDatabase(db); err != nil {
+		t.Fatalf("init database: %v", err)
+	}
+	return db
+}
+
+// newTestProxy returns a proxy on a temporary mock store that answers
+// synchronously; configure may change the defaults before it is created
+func newTestProxy(t *testing.T, configure func(*Config)) *AROHCPMockProxyEnhanced {
+	t.Helper()
+	config := &Config{
+		Port:                     "127.0.0.1:8443",
+		DatabasePath:             filepath.Join(t.TempDir(), "mock.db"),
+		AzureEndpoint:            "http://127.0.0.1:1",
+		DefaultProvisioningState: "Succeeded",
+		ClockSpeed:               1,
+		AdminCredentialTTL:       24 * time.Hour,
+		OperationResultTTL:       time.Hour,
+		ListPageSize:             100,
+		PollingInterval:          time.Second,
+	}
+	if configure != nil {
+		configure(config)
+	}
+	p, err := NewAROHCPMockProxyEnhanced(config)
+	if err != nil {
+		t.Fatalf("create proxy: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// serve sends a request with an optional JSON body through the proxy
+func serve(p *AROHCPMockProxyEnhanced, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	return w
+}
+
+func insertTestResource(t *testing.T, db *sql.DB, id, state string) {
+	t.Helper()
+	parts := splitPath(id)
+	_, err := db.Exec(`INSERT INTO resources (id, resource_type, subscription_id, resource_group, name, location, provisioning_state,
+			properties, identity, tags)
+		VALUES (?, ?, ?, ?, ?, 'eastus', ?, '{}', 'null', 'null')`,
+		id, parts[len(parts)-2], parts[1], parts[3], parts[len(parts)-1], state)
+	if err != nil {
+		t.Fatalf("insert %s: %v", id, err)
+	}
+}
+
+func resourceState(t *testing.T, db *sql.DB, id string) string {
+	t.Helper()
+	var state string
+	if err := db.QueryRow(`SELECT provisioning_state FROM resources WHERE id = ?`, id).Scan(&state); err != nil {
+		if err == sql.ErrNoRows {
+			return ""
+		}
+		t.Fatalf("read %s: %v", id, err)
+	}
+	return state
+}
+
+// waitForOperation polls op until it leaves InProgress
+func waitForOperation(t *testing.T, op *AsyncOperation) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		op.mu.RLock()
+		status := op.Status
+		op.mu.RUnlock()
+		if status != "InProgress" {
+			return status
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("operation %s still InProgress", op.ID)
+	return ""
+}
+
+const testClusterID = "/subscriptions/s1/resourceGroups/rg1/providers/Microsoft.RedHatOpenShift/hcpOpenShiftClusters/c1"
+
+func TestSaveAndLoadOperation(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	end := start.Add(time.Minute)
+
+	tests := []struct {
+		name       string
+		op         *AsyncOperation
+		wantResult interface{}
+	}{
+		{
+			name: "in progress",
+			op: &AsyncOperation{ID: "op-1", ResourceID: testClusterID, OperationType: "Create",
+				Status: "InProgress", PercentComplete: 25, StartTime: start},
+		},
+		{
+			name: "failed",
+			op: &AsyncOperation{ID: "op-2", ResourceID: testClusterID, OperationType: "Update",
+				Status: "Failed", PercentComplete: 50, StartTime: start, EndTime: &end,
+				Error: &OperationError{Code: "InternalServerError", Message: "boom"}},
+		},
+		{
+			name: "succeeded with result",
+			op: &AsyncOperation{ID: "op-3", ResourceID: testClusterID, OperationType: "Update",
+				Status: "Succeeded", PercentComplete: 100, StartTime: start, EndTime: &end,
+				Result: map[string]interface{}{"phase": "Completed"}},
+			wantResult: map[string]interface{}{"phase": "Completed"},
+		},
+		{
+			name: "admin credential kept in memory",
+			op: &AsyncOperation{ID: "op-4", ResourceID: testClusterID, OperationType: "RequestAdminCredential",
+				Status: "Succeeded", PercentComplete: 100, StartTime: start, EndTime: &end,
+				Result: map[string]interface{}{"kubeconfig": "client-key-data: secret"}},
+		},
+	}
+
+	db := newTestDB(t)
+	m := NewAsyncOperationManager(&Config{ClockSpeed: 1}, db)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.saveOperation(tt.op)
+			got, err := m.loadOperation(tt.op.ID)
+			if err != nil {
+				t.Fatalf("loadOperation: %v", err)
+			}
+			if got.Status != tt.op.Status || got.OperationType != tt.op.OperationType ||
+				got.PercentComplete != tt.op.PercentComplete || !got.StartTime.Equal(tt.op.StartTime) {
+				t.Errorf("loaded %+v, want %+v", got, tt.op)
+			}
+			if (got.EndTime == nil) != (tt.op.EndTime == nil) ||
+				(got.EndTime != nil && !got.EndTime.Equal(*tt.op.EndTime)) {
+				t.Errorf("end time %v, want %v", got.EndTime, tt.op.EndTime)
+			}
+			if !reflect.DeepEqual(got.Error, tt.op.Error) {
+				t.Errorf("error %+v, want %+v", got.Error, tt.op.Error)
+			}
+			if !reflect.DeepEqual(got.Result, tt.wantResult) {
+				t.Errorf("result %v, want %v", got.Result, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestResumeOperations(t *testing.T) {
+	tests := []struct {
+		name          string
+		operationType string
+		initialState  string
+		wantState     string
+	}{
+		{name: "create", operationType: "Create", initialState: "Creating", wantState: "Succeeded"},
+		{name: "update", operationType: "Update", initialState: "Updating", wantState: "Succeeded"},
+		{name: "delete", operationType: "Delete", initialState: "Deleting", wantState: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			insertTestResource(t, db, testClusterID, tt.initialState)
+			insertTestResource(t, db, testClusterID+"/nodePools/np1", "Succeeded")
+
+			// Started before a restart, already past its provisioning delay
+			config := &Config{ClockSpeed: 1, ProvisioningDelay: 50 * time.Millisecond}
+			before := NewAsyncOperationManager(config, db)
+			before.saveOperation(&AsyncOperation{ID: "op-resumed", ResourceID: testClusterID,
+				OperationType: tt.operationType, Status: "InProgress", PercentComplete: 25,
+				StartTime: time.Now().Add(-time.Second)})
+
+			m := NewAsyncOperationManager(config, db)
+			m.ResumeOperations()
+			op, err := m.GetOperation("op-resumed")
+			if err != nil {
+				t.Fatalf("GetOperation: %v", err)
+			}
+			if status := waitForOperation(t, op); status != "Succeeded" {
+				t.Fatalf("status %s, want Succeeded", status)
+			}
+			if got := resourceState(t, db, testClusterID); got != tt.wantState {
+				t.Errorf("resource state %q, want %q", got, tt.wantState)
+			}
+			if tt.operationType == "Delete" && resourceState(t, db, testClusterID+"/nodePools/np1") != "" {
+				t.Errorf("child node pool left behind by the resumed delete")
+			}
+
+			// The final state is written right after the status flips
+			var stored *AsyncOperation
+			for i := 0; i < 100; i++ {
+				if stored, err = m.loadOperation("op-resumed"); err == nil && stored.Status == "Succeeded" {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if err != nil || stored.Status != "Succeeded" || stored.EndTime == nil {
+				t.Errorf("stored operation %+v (%v), want Succeeded with an end time", stored, err)
+			}
+		})
+	}
+}
+
+func TestResumeAdminCredentialFails(t *testing.T) {
+	db := newTestDB(t)
+	insertTestResource(t, db, testClusterID, "Succeeded")
+	config := &Config{ClockSpeed: 1}
+	NewAsyncOperationManager(config, db).saveOperation(&AsyncOperation{ID: "op-credential", ResourceID: testClusterID,
+		OperationType: "RequestAdminCredential", Status: "InProgress", StartTime: time.Now(),
+		Result: map[string]interface{}{"kubeconfig": "client-key-data: secret"}})
+
+	m := NewAsyncOperationManager(config, db)
+	m.ResumeOperations()
+	op, err := m.GetOperation("op-credential")
+	if err != nil {
+		t.Fatalf("GetOperation: %v", err)
+	}
+	if status := waitForOperation(t, op); status != "Failed" {
+		t.Errorf("status %s, want Failed", status)
+	}
+	if got := resourceState(t, db, testClusterID); got != "Succeeded" {
+		t.Errorf("resource state %q, want Succeeded", got)
+	}
+}
+
+func TestOperationIDsAreUnique(t *testing.T) {
+	m := NewAsyncOperationManager(&Config{ClockSpeed: 1}, nil)
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		op := m.newOperation(testClusterID, "Update", nil)
+		if seen[op.ID] {
+			t.Fatalf("duplicate operation ID %s", op.ID)
+		}
+		seen[op.ID] = true
+	}
+}
+
+func TestActiveOperation(t *testing.T) {
+	m := NewAsyncOperationManager(&Config{ClockSpeed: 1}, nil)
+	poolID := testClusterID + "/nodePools/np1"
+	cluster := m.newOperation(testClusterID, "Delete", nil)
+	pool := m.newOperation(poolID, "Update", nil)
+
+	// Resource IDs are case-insensitive
+	if op := m.ActiveOperation(strings.ToUpper(testClusterID)); op != cluster {
+		t.Errorf("ActiveOperation of the cluster = %v, want %s", op, cluster.ID)
+	}
+	if active := m.activeOperationsUnder(strings.ToLower(testClusterID)); len(active) != 2 {
+		t.Errorf("%d operations under the cluster, want 2", len(active))
+	}
+	if m.HasActiveOperation(testClusterID + "2") {
+		t.Errorf("operation found on another cluster")
+	}
+
+	m.finishOperation(pool, "Succeeded", nil)
+	if m.HasActiveOperation(poolID) {
+		t.Errorf("finished operation still active")
+	}
+}
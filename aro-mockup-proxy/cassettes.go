@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Cassette modes (AZURE_CASSETTE_MODE)
+const (
+	cassetteRecord = "record"
+	cassetteReplay = "replay"
+)
+
+// cassetteHeaders are the response headers kept in a cassette. Everything
+// else, cookies in particular, is dropped.
+var cassetteHeaders = []string{"Content-Type", "Location", "Azure-AsyncOperation", "Retry-After", "ETag"}
+
+var (
+	// secretKeyRE matches JSON member names whose string values are redacted
+	secretKeyRE = regexp.MustCompile(`(?i)(password|secret|token|connectionstring|sas|key)$`)
+	// jwtRE matches bearer tokens embedded anywhere in a string
+	jwtRE = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+const redacted = "REDACTED"
+
+// cassette holds the responses recorded for one request, in the order
+// Azure returned them, e.g. the successive states of a polled operation
+type cassette struct {
+	Request   cassetteRequest    `json:"request"`
+	Responses []cassetteResponse `json:"responses"`
+
+	next int // replay position
+}
+
+type cassetteRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	Body   string `json:"body,omitempty"`
+}
+
+type cassetteResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// cassetteStore records Azure traffic to, or replays it from, one JSON file
+// per request in dir. A nil store passes requests through unchanged.
+type cassetteStore struct {
+	mode      string
+	dir       string
+	mu        sync.Mutex
+	cassettes map[string]*cassette // by file name
+}
+
+func newCassetteStore(mode, dir string) (*cassetteStore, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case cassetteRecord:
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	case cassetteReplay:
+	default:
+		return nil, fmt.Errorf("unknown AZURE_CASSETTE_MODE %q, want %q or %q", mode, cassetteRecord, cassetteReplay)
+	}
+
+	s := &cassetteStore{mode: mode, dir: dir, cassettes: make(map[string]*cassette)}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var c cassette
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		s.cassettes[filepath.Base(file)] = &c
+	}
+	// Re-recording starts the cassettes over
+	if mode == cassetteRecord {
+		for _, c := range s.cassettes {
+			c.Responses = nil
+		}
+	}
+	log.Printf("Loaded %d Azure cassettes from %s", len(s.cassettes), dir)
+	return s, nil
+}
+
+// cassetteKey returns the normalized request and the name of its cassette
+// file. ARM paths are case-insensitive, query parameters are sorted and
+// JSON bodies are compared after redaction with sorted members. Query
+// values are redacted the same way.
+func cassetteKey(r *http.Request, body []byte) (cassetteRequest, string) {
+	req := cassetteRequest{
+		Method: r.Method,
+		Path:   strings.ToLower(strings.TrimSuffix(r.URL.Path, "/")),
+		Query:  normalizeQuery(r.URL.Query()),
+		Body:   redactBody(body),
+	}
+	sum := sha256.Sum256([]byte(req.Method + " " + req.Path + "?" + req.Query + "\n" + req.Body))
+	return req, fmt.Sprintf("%s-%s.json", strings.ToLower(req.Method), hex.EncodeToString(sum[:8]))
+}
+
+func normalizeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		var values []string
+		for _, value := range query[key] {
+			// Query strings carry secrets too, e.g. SAS tokens
+			values = append(values, redactValue(key, value).(string))
+		}
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, url.QueryEscape(strings.ToLower(key))+"="+url.QueryEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// redactBody replaces secrets in a JSON body and re-encodes it with sorted
+// members. Other bodies only have embedded tokens replaced.
+func redactBody(body []byte) string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return jwtRE.ReplaceAllString(string(body), redacted)
+	}
+	out, _ := json.Marshal(redactValue("", v))
+	return string(out)
+}
+
+func redactValue(key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, member := range v {
+			v[k] = redactValue(k, member)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(key, item)
+		}
+		return v
+	case string:
+		if secretKeyRE.MatchString(key) && v != "" {
+			return redacted
+		}
+		return jwtRE.ReplaceAllString(v, redacted)
+	}
+	return v
+}
+
+// ServeHTTP records the Azure response of a request, or replays the one
+// recorded for it, and falls back to next when the store is nil
+func (s *cassetteStore) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.Handler, baseURL string) {
+	if s == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	req, name := cassetteKey(r, body)
+
+	if s.mode == cassetteReplay {
+		s.replay(w, req, name, baseURL)
+		return
+	}
+
+	// Ask for an uncompressed response so its body can be redacted
+	r.Header.Del("Accept-Encoding")
+	capture := &responseCapture{ResponseWriter: w, status: http.StatusOK, baseURL: baseURL}
+	next.ServeHTTP(capture, r)
+
+	resp := cassetteResponse{Status: capture.status, Body: redactBody(capture.body.Bytes())}
+	for _, header := range cassetteHeaders {
+		if value := capture.Header().Get(header); value != "" {
+			if resp.Headers == nil {
+				resp.Headers = make(map[string]string)
+			}
+			resp.Headers[header] = value
+		}
+	}
+	if err := s.record(req, name, resp); err != nil {
+		log.Printf("Failed to record cassette %s: %v", name, err)
+	}
+}
+
+func (s *cassetteStore) record(req cassetteRequest, name string, resp cassetteResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cassettes[name]
+	if !ok {
+		c = &cassette{Request: req}
+		s.cassettes[name] = c
+	}
+	c.Responses = append(c.Responses, resp)
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	log.Printf("  -> Recorded %s %s as %s", req.Method, req.Path, name)
+	return os.WriteFile(filepath.Join(s.dir, name), data, 0644)
+}
+
+// replay serves the recorded responses of a request in order, repeating
+// the last one once all have been served
+func (s *cassetteStore) replay(w http.ResponseWriter, req cassetteRequest, name, baseURL string) {
+	s.mu.Lock()
+	c, ok := s.cassettes[name]
+	var resp cassetteResponse
+	if ok && len(c.Responses) > 0 {
+		resp = c.Responses[c.next]
+		if c.next < len(c.Responses)-1 {
+			c.next++
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok || resp.Status == 0 {
+		log.Printf("  -> No recorded response for %s %s (%s)", req.Method, req.Path, name)
+		writeCloudError(w, http.StatusNotImplemented, &CloudErrorBody{
+			Code:    "NoRecordedInteraction",
+			Message: fmt.Sprintf("The mockup proxy is replaying Azure cassettes and has no recorded response for '%s %s'.", req.Method, req.Path),
+		})
+		return
+	}
+
+	log.Printf("  -> Replaying %s", name)
+	for header, value := range resp.Headers {
+		if header == "Location" || header == "Azure-AsyncOperation" {
+			value = rewriteHost(value, baseURL)
+		}
+		w.Header().Set(header, value)
+	}
+	w.WriteHeader(resp.Status)
+	io.WriteString(w, resp.Body)
+}
+
+// rewriteHost points an absolute Azure URL at the proxy, so clients poll
+// long-running operations through it
+func rewriteHost(value, baseURL string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return value
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return value
+	}
+	u.Scheme, u.Host = base.Scheme, base.Host
+	return u.String()
+}
+
+// responseCapture passes a response through while keeping a copy of its
+// status and body. Polling URLs are rewritten to point at the proxy.
+type responseCapture struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	baseURL string
+}
+
+func (c *responseCapture) WriteHeader(code int) {
+	c.status = code
+	for _, header := range []string{"Location", "Azure-AsyncOperation"} {
+		if value := c.Header().Get(header); value != "" {
+			c.Header().Set(header, rewriteHost(value, c.baseURL))
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeAzure answers like ARM for a long-running PUT: 201 with a polling
+// URL, then the operation status, InProgress once and Succeeded after
+type fakeAzure struct {
+	polls int
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: "session", Value: "s"})
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == "PUT":
+		w.Header().Set("Azure-AsyncOperation", "https://management.azure.com/subscriptions/s1/providers/Microsoft.Network/locations/eastus/operations/op1?api-version=2024-05-01")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"id":"/subscriptions/s1/resourceGroups/rg1/providers/Microsoft.Network/virtualNetworks/v1","properties":{"sharedKey":"k3y"}}`)
+	case strings.Contains(r.URL.Path, "/operations/"):
+		f.polls++
+		if f.polls == 1 {
+			io.WriteString(w, `{"status":"InProgress"}`)
+		} else {
+			io.WriteString(w, `{"status":"Succeeded"}`)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveCassette sends a request through the store and returns the
+// response
+func serveCassette(s *cassetteStore, next http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)), next, "http://proxy.example")
+	return w
+}
+
+func TestCassetteRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	vnet := "/subscriptions/s1/resourceGroups/rg1/providers/Microsoft.Network/virtualNetworks/v1"
+	operation := "/subscriptions/s1/providers/Microsoft.Network/locations/eastus/operations/op1"
+
+	azure := &fakeAzure{}
+	recorder, err := newCassetteStore(cassetteRecord, dir)
+	if err != nil {
+		t.Fatalf("record store: %v", err)
+	}
+	recorded := []*httptest.ResponseRecorder{
+		serveCassette(recorder, azure, "PUT", vnet+"?api-version=2024-05-01", `{"location":"eastus","properties":{"sharedKey":"k3y"}}`),
+		serveCassette(recorder, azure, "GET", operation+"?api-version=2024-05-01", ""),
+		serveCassette(recorder, azure, "GET", operation+"?api-version=2024-05-01", ""),
+	}
+	// Clients poll through the proxy while recording too
+	if got := recorded[0].Header().Get("Azure-AsyncOperation"); !strings.HasPrefix(got, "http://proxy.example/") {
+		t.Errorf("recorded Azure-AsyncOperation %s, want it on the proxy", got)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("recorded %d cassettes, want one for the PUT and one for the polls", len(files))
+	}
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if strings.Contains(string(data), "k3y") || strings.Contains(string(data), "session") {
+			t.Errorf("%s keeps a secret or a cookie: %s", file, data)
+		}
+	}
+
+	player, err := newCassetteStore(cassetteReplay, dir)
+	if err != nil {
+		t.Fatalf("replay store: %v", err)
+	}
+	offline := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("replay reached Azure with %s %s", r.Method, r.URL)
+	})
+
+	// Paths are case-insensitive, query parameters unordered and secrets
+	// redacted before requests are matched
+	replayed := []*httptest.ResponseRecorder{
+		serveCassette(player, offline, "PUT", strings.ToUpper(vnet)+"?api-version=2024-05-01", `{"properties":{"sharedKey":"other"},"location":"eastus"}`),
+		serveCassette(player, offline, "GET", operation+"?api-version=2024-05-01", ""),
+		serveCassette(player, offline, "GET", operation+"?api-version=2024-05-01", ""),
+		// The last recorded response repeats
+		serveCassette(player, offline, "GET", operation+"?api-version=2024-05-01", ""),
+	}
+	wantStatuses := []string{"", "InProgress", "Succeeded", "Succeeded"}
+	for i, w := range replayed {
+		want := recorded[min(i, len(recorded)-1)]
+		if w.Code != want.Code || w.Header().Get("Set-Cookie") != "" {
+			t.Errorf("replay %d: status %d with cookie %q, want %d without", i, w.Code, w.Header().Get("Set-Cookie"), want.Code)
+		}
+		var body struct {
+			Status string `json:"status"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if body.Status != wantStatuses[i] {
+			t.Errorf("replay %d: status %q, want %q", i, body.Status, wantStatuses[i])
+		}
+	}
+	if got, want := replayed[0].Header().Get("Azure-AsyncOperation"), recorded[0].Header().Get("Azure-AsyncOperation"); got != want {
+		t.Errorf("replayed Azure-AsyncOperation %s, want %s", got, want)
+	}
+	if strings.Contains(replayed[0].Body.String(), "k3y") {
+		t.Errorf("replayed body %s keeps the secret", replayed[0].Body)
+	}
+
+	w := serveCassette(player, offline, "DELETE", vnet+"?api-version=2024-05-01", "")
+	if w.Code != http.StatusNotImplemented || !strings.Contains(w.Body.String(), "NoRecordedInteraction") {
+		t.Errorf("unrecorded request: status %d %s, want 501 NoRecordedInteraction", w.Code, w.Body)
+	}
+
+	// Re-recording starts the cassettes over
+	rerecorder, err := newCassetteStore(cassetteRecord, dir)
+	if err != nil {
+		t.Fatalf("record store: %v", err)
+	}
+	serveCassette(rerecorder, azure, "GET", operation+"?api-version=2024-05-01", "")
+	for _, c := range rerecorder.cassettes {
+		if c.Request.Method == "GET" && len(c.Responses) != 1 {
+			t.Errorf("re-recorded cassette has %d responses, want 1", len(c.Responses))
+		}
+	}
+}
+
+func TestNewCassetteStore(t *testing.T) {
+	if s, err := newCassetteStore("", t.TempDir()); s != nil || err != nil {
+		t.Errorf("no mode = %v, %v, want a nil store", s, err)
+	}
+	if _, err := newCassetteStore("playback", t.TempDir()); err == nil {
+		t.Errorf("unknown mode accepted")
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "get-broken.json"), []byte("{"), 0644)
+	if _, err := newCassetteStore(cassetteReplay, dir); err == nil || !strings.Contains(err.Error(), "get-broken.json") {
+		t.Errorf("error %v for a broken cassette, want one naming it", err)
+	}
+
+	// A nil store passes requests through
+	var s *cassetteStore
+	w := serveCassette(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), "GET", "/x", "")
+	if w.Code != http.StatusTeapot {
+		t.Errorf("nil store: status %d, want the next handler's", w.Code)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "empty", body: " ", want: ""},
+		{name: "sorted members", body: `{"b":1,"a":2}`, want: `{"a":2,"b":1}`},
+		{name: "secret members", body: `{"clientSecret":"s","properties":{"password":"p","keys":[{"key":"k"}]}}`, want: `{"clientSecret":"REDACTED","properties":{"keys":[{"key":"REDACTED"}],"password":"REDACTED"}}`},
+		{name: "empty secrets are kept", body: `{"password":""}`, want: `{"password":""}`},
+		{name: "embedded token", body: `{"header":"Bearer eyJhbGciOi.eyJzdWIiOi.c2ln"}`, want: `{"header":"Bearer REDACTED"}`},
+		{name: "not JSON", body: `token=eyJhbGciOi.eyJzdWIiOi.c2ln`, want: `token=REDACTED`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactBody([]byte(tt.body)); got != tt.want {
+				t.Errorf("redactBody(%s) = %s, want %s", tt.body, got, tt.want)
+			}
+		})
+	}
+}
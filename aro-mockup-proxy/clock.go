@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// virtualClock is the time source of the proxy's timed behavior: operation
+// progress, scenario timelines, Retry-After and admin credential expiry. It
+// runs at speed times wall-clock speed and can be advanced through
+// /admin/clock, so a 20 minute cluster create can play out in seconds with
+// the same sequence of states. A nil clock is the wall clock.
+//
+// The clock starts at wall-clock time, so advancing it is not persisted
+// across restarts.
+type virtualClock struct {
+	mu      sync.Mutex
+	base    time.Time // wall-clock time of the last change
+	now     time.Time // virtual time at base
+	speed   float64   // 0 stops the clock between advances
+	changed chan struct{}
+}
+
+func newVirtualClock(speed float64) *virtualClock {
+	now := time.Now()
+	return &virtualClock{base: now, now: now, speed: speed, changed: make(chan struct{})}
+}
+
+// nowLocked returns the virtual time. c.mu must be held.
+func (c *virtualClock) nowLocked() time.Time {
+	elapsed := time.Since(c.base)
+	return c.now.Add(time.Duration(float64(elapsed) * c.speed))
+}
+
+// Now returns the current virtual time
+func (c *virtualClock) Now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nowLocked()
+}
+
+// Since returns the virtual time elapsed since t
+func (c *virtualClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Sleep pauses the calling goroutine for d of virtual time
+func (c *virtualClock) Sleep(d time.Duration) {
+	c.SleepUntil(c.Now().Add(d))
+}
+
+// SleepUntil pauses the calling goroutine until the virtual time reaches t,
+// waking up early when the clock is advanced or its speed changes
+func (c *virtualClock) SleepUntil(t time.Time) {
+	if c == nil {
+		time.Sleep(time.Until(t))
+		return
+	}
+	for {
+		c.mu.Lock()
+		remaining := t.Sub(c.nowLocked())
+		speed, changed := c.speed, c.changed
+		c.mu.Unlock()
+		if remaining <= 0 {
+			return
+		}
+
+		if speed == 0 {
+			<-changed
+			continue
+		}
+		timer := time.NewTimer(time.Duration(float64(remaining) / speed))
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		}
+	}
+}
+
+// RealDuration returns the wall-clock time d of virtual time takes at the
+// current speed, or d itself while the clock is stopped
+func (c *virtualClock) RealDuration(d time.Duration) time.Duration {
+	if c == nil {
+		return d
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.speed == 0 {
+		return d
+	}
+	return time.Duration(float64(d) / c.speed)
+}
+
+// update rebases the clock at the current virtual time plus advance, sets
+// its speed and wakes up the sleepers to recompute their deadlines
+func (c *virtualClock) update(advance time.Duration, speed float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.nowLocked().Add(advance)
+	c.base = time.Now()
+	c.speed = speed
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// retryAfter returns the Retry-After header value for polling every
+// interval of virtual time: whole wall-clock seconds, at least one
+func (c *virtualClock) retryAfter(interval time.Duration) string {
+	seconds := math.Ceil(c.RealDuration(interval).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return fmt.Sprintf("%d", int(seconds))
+}
+
+// handleClock serves the virtual clock API:
+//
+//	GET  /admin/clock  the current virtual time and speed
+//	POST /admin/clock  {"advance": "20m", "speed": 60}, both optional
+func (c *virtualClock) handleClock(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req struct {
+			Advance string   `json:"advance"`
+			Speed   *float64 `json:"speed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeCloudError(w, http.StatusBadRequest, invalidRequestContent(err))
+			return
+		}
+		var advance time.Duration
+		if req.Advance != "" {
+			var err error
+			if advance, err = time.ParseDuration(req.Advance); err != nil || advance < 0 {
+				writeCloudError(w, http.StatusBadRequest, &CloudErrorBody{
+					Code:    "InvalidClockChange",
+					Message: fmt.Sprintf("advance must be a positive duration such as \"20m\", got %q.", req.Advance),
+				})
+				return
+			}
+		}
+		c.mu.Lock()
+		speed := c.speed
+		c.mu.Unlock()
+		if req.Speed != nil {
+			if *req.Speed < 0 {
+				writeCloudError(w, http.StatusBadRequest, &CloudErrorBody{
+					Code:    "InvalidClockChange",
+					Message: "speed must not be negative.",
+				})
+				return
+			}
+			speed = *req.Speed
+		}
+		c.update(advance, speed)
+		log.Printf("Virtual clock advanced by %s, speed %g", advance, speed)
+	default:
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+		return
+	}
+
+	c.mu.Lock()
+	state := map[string]interface{}{
+		"now":   c.nowLocked().UTC().Format(time.RFC3339Nano),
+		"speed": c.speed,
+	}
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVirtualClockNow(t *testing.T) {
+	tests := []struct {
+		name    string
+		speed   float64
+		advance time.Duration
+		minGain time.Duration // virtual time gained over the wall-clock time
+		maxGain time.Duration
+	}{
+		{name: "stopped", speed: 0, minGain: -time.Hour, maxGain: -20 * time.Millisecond},
+		{name: "stopped and advanced", speed: 0, advance: time.Hour, minGain: 59 * time.Minute, maxGain: time.Hour},
+		{name: "real time", speed: 1, minGain: -10 * time.Millisecond, maxGain: 10 * time.Millisecond},
+		{name: "fast", speed: 1000, minGain: 20 * time.Second, maxGain: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newVirtualClock(tt.speed)
+			start := time.Now()
+			c.update(tt.advance, tt.speed)
+			time.Sleep(25 * time.Millisecond)
+			virtual := c.Since(start)
+			wall := time.Since(start)
+			if gain := virtual - wall; gain < tt.minGain || gain > tt.maxGain {
+				t.Errorf("virtual time %s after %s of wall-clock time, want a gain within [%s, %s]", virtual, wall, tt.minGain, tt.maxGain)
+			}
+		})
+	}
+
+	var nilClock *virtualClock
+	if d := time.Since(nilClock.Now()); d < 0 || d > time.Second {
+		t.Errorf("nil clock is %s off the wall clock", d)
+	}
+}
+
+func TestVirtualClockSleep(t *testing.T) {
+	tests := []struct {
+		name    string
+		speed   float64
+		sleep   time.Duration
+		advance time.Duration // applied while sleeping
+		maxWall time.Duration
+	}{
+		{name: "fast clock", speed: 3600, sleep: time.Minute, maxWall: time.Second},
+		{name: "stopped clock advanced", speed: 0, sleep: time.Hour, advance: time.Hour, maxWall: time.Second},
+		{name: "advanced past the deadline", speed: 1, sleep: time.Hour, advance: 2 * time.Hour, maxWall: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newVirtualClock(tt.speed)
+			start := time.Now()
+			done := make(chan struct{})
+			go func() {
+				c.Sleep(tt.sleep)
+				close(done)
+			}()
+			if tt.advance > 0 {
+				time.Sleep(10 * time.Millisecond)
+				c.update(tt.advance, tt.speed)
+			}
+			select {
+			case <-done:
+			case <-time.After(tt.maxWall):
+				t.Fatalf("Sleep(%s) still asleep after %s", tt.sleep, tt.maxWall)
+			}
+			if wall := time.Since(start); wall > tt.maxWall {
+				t.Errorf("Sleep(%s) took %s", tt.sleep, wall)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		clock    *virtualClock
+		interval time.Duration
+		want     string
+	}{
+		{name: "wall clock", clock: nil, interval: 5 * time.Second, want: "5"},
+		{name: "rounded up", clock: newVirtualClock(2), interval: 5 * time.Second, want: "3"},
+		{name: "at least a second", clock: newVirtualClock(60), interval: 5 * time.Second, want: "1"},
+		{name: "stopped clock", clock: newVirtualClock(0), interval: 10 * time.Second, want: "10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.clock.retryAfter(tt.interval); got != tt.want {
+				t.Errorf("retryAfter(%s) = %s, want %s", tt.interval, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleClock(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantSpeed  float64
+		wantAhead  time.Duration
+	}{
+		{name: "get", method: "GET", wantStatus: http.StatusOK, wantSpeed: 1},
+		{name: "advance", method: "POST", body: `{"advance":"20m"}`, wantStatus: http.StatusOK, wantSpeed: 1, wantAhead: 20 * time.Minute},
+		{name: "speed", method: "POST", body: `{"speed":60}`, wantStatus: http.StatusOK, wantSpeed: 60},
+		{name: "stop", method: "POST", body: `{"speed":0}`, wantStatus: http.StatusOK, wantSpeed: 0},
+		{name: "negative advance", method: "POST", body: `{"advance":"-1m"}`, wantStatus: http.StatusBadRequest},
+		{name: "bad advance", method: "POST", body: `{"advance":"soon"}`, wantStatus: http.StatusBadRequest},
+		{name: "negative speed", method: "POST", body: `{"speed":-1}`, wantStatus: http.StatusBadRequest},
+		{name: "bad body", method: "POST", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "method", method: "DELETE", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newVirtualClock(1)
+			w := httptest.NewRecorder()
+			c.handleClock(w, httptest.NewRequest(tt.method, "/admin/clock", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var state struct {
+				Now   time.Time `json:"now"`
+				Speed float64   `json:"speed"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+				t.Fatalf("decode %s: %v", w.Body, err)
+			}
+			if state.Speed != tt.wantSpeed {
+				t.Errorf("speed %g, want %g", state.Speed, tt.wantSpeed)
+			}
+			if ahead := time.Until(state.Now); ahead < tt.wantAhead-time.Second || ahead > tt.wantAhead+time.Second {
+				t.Errorf("clock %s ahead of the wall clock, want %s", ahead, tt.wantAhead)
+			}
+		})
+	}
+}
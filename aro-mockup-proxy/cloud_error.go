@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// CloudError is the error envelope returned by Azure Resource Manager
+type CloudError struct {
+	Error CloudErrorBody `json:"error"`
+}
+
+// CloudErrorBody describes a single ARM error and its optional details
+type CloudErrorBody struct {
+	Code    string           `json:"code"`
+	Message string           `json:"message"`
+	Target  string           `json:"target,omitempty"`
+	Details []CloudErrorBody `json:"details,omitempty"`
+}
+
+// writeCloudError writes an ARM error envelope with the given HTTP status
+func writeCloudError(w http.ResponseWriter, status int, body *CloudErrorBody) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(CloudError{Error: *body})
+}
+
+// invalidRequestContent reports a request body that is not valid JSON
+func invalidRequestContent(err error) *CloudErrorBody {
+	return &CloudErrorBody{
+		Code:    "InvalidRequestContent",
+		Message: fmt.Sprintf("The request content was invalid and could not be deserialized: %v", err),
+	}
+}
+
+// invalidResourceID reports a request path that does not address a resource
+// the proxy knows how to serve
+func invalidResourceID(path string) *CloudErrorBody {
+	return &CloudErrorBody{
+		Code:    "InvalidResourceId",
+		Message: fmt.Sprintf("The resource path '%s' is not valid.", path),
+	}
+}
+
+// resourceNotFound reports a missing resource the way ARM does; armType is
+// the qualified type and name, e.g. "Microsoft.KeyVault/vaults/kv1"
+func resourceNotFound(armType, resourceGroup string) *CloudErrorBody {
+	return &CloudErrorBody{
+		Code:    "ResourceNotFound",
+		Message: fmt.Sprintf("The Resource '%s' under resource group '%s' was not found. For more details please go to https://aka.ms/ARMResourceNotFoundFix", armType, resourceGroup),
+	}
+}
+
+func resourceGroupNotFound(resourceGroup string) *CloudErrorBody {
+	return &CloudErrorBody{
+		Code:    "ResourceGroupNotFound",
+		Message: fmt.Sprintf("Resource group '%s' could not be found.", resourceGroup),
+	}
+}
+
+func methodNotAllowed(method string) *CloudErrorBody {
+	return &CloudErrorBody{
+		Code:    "MethodNotAllowed",
+		Message: fmt.Sprintf("The HTTP method '%s' is not supported for this resource.", method),
+	}
+}
+
+// internalServerError hides the underlying error from the client, which is
+// logged by the caller instead
+func internalServerError() *CloudErrorBody {
+	return &CloudErrorBody{
+		Code:    "InternalServerError",
+		Message: "An internal server error occurred.",
+	}
+}
+
+// proxyErrorHandler replaces the empty 502 that httputil.ReverseProxy sends
+// when the upstream (Azure or the dev frontend) cannot be reached
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Proxy error for %s %s: %v", r.Method, r.URL.Path, err)
+	writeCloudError(w, http.StatusBadGateway, &CloudErrorBody{
+		Code:    "BadGateway",
+		Message: fmt.Sprintf("The mockup proxy could not reach the upstream endpoint: %v", err),
+	})
+}
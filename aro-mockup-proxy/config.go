@@ -17,19 +17,88 @@ type Config struct {
 	CertFile      string
 	KeyFile       string
 
+	// Admin API (mock store, operations, credentials, faults, clock) on its
+	// own plain HTTP listener. It is unauthenticated, so it listens on the
+	// loopback address unless told otherwise; empty disables it.
+	AdminPort string
+
 	// Feature flags
 	EnableAsyncOperations bool
 	EnableValidation      bool
 	EnableMetrics         bool
 
+	// Reject node pool and external auth writes while the parent cluster
+	// has not reached Succeeded. Writes under a missing or deleting
+	// cluster are always rejected.
+	EnforceParentState bool
+
+	// OpenShift version catalog (channel groups, enabled versions and
+	// upgrade edges); the built-in versions.yaml is used when empty
+	VersionCatalogPath string
+
+	// Provisioning scenarios: YAML files that script the timeline of the
+	// operations on matching resources instead of ProvisioningDelay
+	ScenarioDir string
+
+	// Offline mode: serve the Azure resources the ARO templates depend on
+	// (resource groups, key vaults, network, managed identities) from the
+	// local SQLite mock instead of forwarding them to AzureEndpoint, and
+	// reject any other non-ARO-HCP request.
+	OfflineMode bool
+
+	// Azure cassettes: "record" saves the redacted Azure responses to
+	// CassetteDir, "replay" serves them from there without network access
+	CassetteMode string
+	CassetteDir  string
+
 	// Behavior configuration
 	ProvisioningDelay    time.Duration
 	DefaultProvisioningState string
 	SimulateFailures     bool
 	FailureRate          float64
 
-	// Async operation configuration
+	// Speed of the virtual clock behind provisioning progress, scenarios,
+	// Retry-After and credential expiry, relative to wall-clock time; it can
+	// be changed and advanced at runtime through /admin/clock
+	ClockSpeed float64
+
+	// Admin credentials: requestAdminCredential returns a kubeconfig for the
+	// workload API server of WorkloadKubeconfigPath with a client certificate
+	// signed by the CA in AdminCACertFile/AdminCAKeyFile (generated there if
+	// missing). Unset, the CA lives in memory and only per-cluster control
+	// planes trust it; the workload kubeconfig's own user is handed out.
+	WorkloadKubeconfigPath string
+	AdminCACertFile        string
+	AdminCAKeyFile         string
+	AdminCredentialTTL     time.Duration
+
+	// How long the result of a finished action, such as the kubeconfig of
+	// requestAdminCredential, can be fetched from its hcpOperationResults URL
+	OperationResultTTL time.Duration
+
+	// Per-cluster control planes: start an etcd and kube-apiserver from the
+	// envtest binaries in ControlPlaneAssets for every Succeeded cluster,
+	// reachable at ControlPlaneHost
+	EnableControlPlanes      bool
+	ControlPlaneAssets       string
+	ControlPlaneHost         string
+	ControlPlaneStartTimeout time.Duration
+
+	// Create a Node object per node pool replica in the workload API server
+	// the admin kubeconfig points at (the cluster's control plane, or else
+	// WorkloadKubeconfigPath), and keep it Ready with a Lease and status
+	// heartbeats like a kubelet
+	MaterializeNodes bool
+
+	// Page size of list responses; clients may ask for smaller pages with
+	// $top and follow nextLink for the rest
+	ListPageSize int
+
+	// Async operation configuration: operations still running after
+	// AsyncOperationTimeout fail with OperationTimedOut, finished ones are
+	// removed OperationRetention after they ended (0 disables either)
 	AsyncOperationTimeout time.Duration
+	OperationRetention    time.Duration
 	PollingInterval       time.Duration
 
 	// Dev environment proxy: when set, hcpOpenShiftCluster requests are
@@ -49,14 +118,34 @@ func LoadConfig() *Config {
 		EnableTLS:                getEnvBool("ENABLE_TLS", true),
 		CertFile:                 getEnv("TLS_CERT_FILE", "./server.crt"),
 		KeyFile:                  getEnv("TLS_KEY_FILE", "./server.key"),
+		AdminPort:                getEnv("ADMIN_PORT", "127.0.0.1:8081"),
 		EnableAsyncOperations:    getEnvBool("ENABLE_ASYNC_OPS", true),
 		EnableValidation:         getEnvBool("ENABLE_VALIDATION", false),
 		EnableMetrics:            getEnvBool("ENABLE_METRICS", false),
+		EnforceParentState:       getEnvBool("ENFORCE_PARENT_STATE", true),
+		VersionCatalogPath:       getEnv("VERSION_CATALOG", ""),
+		ScenarioDir:              getEnv("SCENARIO_DIR", ""),
+		OfflineMode:              getEnvBool("OFFLINE_MODE", false),
+		CassetteMode:             getEnv("AZURE_CASSETTE_MODE", ""),
+		CassetteDir:              getEnv("AZURE_CASSETTE_DIR", "./cassettes"),
 		ProvisioningDelay:        getEnvDuration("PROVISIONING_DELAY", 10*time.Second),
 		DefaultProvisioningState: getEnv("DEFAULT_PROVISIONING_STATE", "Succeeded"),
 		SimulateFailures:         getEnvBool("SIMULATE_FAILURES", false),
 		FailureRate:              getEnvFloat("FAILURE_RATE", 0.0),
+		ClockSpeed:               getEnvFloat("CLOCK_SPEED", 1.0),
+		WorkloadKubeconfigPath:   getEnv("MOCK_KUBECONFIG_PATH", "/data/workload-kubeconfig.yaml"),
+		AdminCACertFile:          getEnv("ADMIN_CA_CERT_FILE", ""),
+		AdminCAKeyFile:           getEnv("ADMIN_CA_KEY_FILE", ""),
+		AdminCredentialTTL:       getEnvDuration("ADMIN_CREDENTIAL_TTL", 24*time.Hour),
+		OperationResultTTL:       getEnvDuration("OPERATION_RESULT_TTL", time.Hour),
+		EnableControlPlanes:      getEnvBool("ENABLE_CONTROL_PLANES", false),
+		ControlPlaneAssets:       getEnv("KUBEBUILDER_ASSETS", "/usr/local/kubebuilder/bin"),
+		ControlPlaneHost:         getEnv("CONTROL_PLANE_HOST", "127.0.0.1"),
+		ControlPlaneStartTimeout: getEnvDuration("CONTROL_PLANE_START_TIMEOUT", time.Minute),
+		MaterializeNodes:         getEnvBool("MATERIALIZE_NODES", false),
+		ListPageSize:             getEnvInt("LIST_PAGE_SIZE", 100),
 		AsyncOperationTimeout:    getEnvDuration("ASYNC_TIMEOUT", 5*time.Minute),
+		OperationRetention:       getEnvDuration("OPERATION_RETENTION", time.Hour),
 		PollingInterval:          getEnvDuration("POLLING_INTERVAL", 5*time.Second),
 		DevEndpoint:              getEnv("DEV_ENDPOINT", ""),
 	}
@@ -89,6 +178,16 @@ func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		n, err := strconv.Atoi(value)
+		if err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
 func getEnvFloat(key string, defaultValue float64) float64 {
 	if value := os.Getenv(key); value != "" {
 		f, err := strconv.ParseFloat(value, 64)
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// clusterIDRE matches the ID of an hcpOpenShiftClusters resource, but not
+// of its node pools or external auths
+var clusterIDRE = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.RedHatOpenShift/hcpOpenShiftClusters/[^/]+$`)
+
+// controlPlane is an etcd and kube-apiserver started from the envtest
+// binaries for one cluster
+type controlPlane struct {
+	clusterID string
+	dir       string
+	url       string
+	client    *http.Client // authenticated as system:masters
+	processes []*controlPlaneProcess
+}
+
+type controlPlaneProcess struct {
+	name string
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// errPortInUse is returned when another process took one of the ports a
+// control plane was about to listen on
+var errPortInUse = errors.New("control plane port already in use")
+
+// controlPlaneManager runs a control plane per Succeeded cluster when
+// ENABLE_CONTROL_PLANES is set. The API servers trust the admin credential
+// CA, so requestAdminCredential kubeconfigs work against them. A nil
+// manager runs nothing.
+type controlPlaneManager struct {
+	config   *Config
+	ca       *credentialAuthority
+	mu       sync.Mutex
+	planes   map[string]*controlPlane // by lower-cased cluster ID
+	starting map[string]chan struct{} // closed once the start has finished; Start and Stop of a cluster wait for it
+
+	// launch starts the processes of a control plane and waits until it is
+	// ready; m.start unless a test stubs it
+	launch func(clusterID string) (*controlPlane, error)
+}
+
+func newControlPlaneManager(config *Config, ca *credentialAuthority) (*controlPlaneManager, error) {
+	for _, binary := range []string{"etcd", "kube-apiserver"} {
+		if _, err := os.Stat(filepath.Join(config.ControlPlaneAssets, binary)); err != nil {
+			return nil, fmt.Errorf("ENABLE_CONTROL_PLANES needs the envtest binaries in KUBEBUILDER_ASSETS: %w", err)
+		}
+	}
+	m := &controlPlaneManager{
+		config:   config,
+		ca:       ca,
+		planes:   make(map[string]*controlPlane),
+		starting: make(map[string]chan struct{}),
+	}
+	m.launch = m.start
+	return m, nil
+}
+
+// URL returns the API server URL of a cluster's control plane, or "" if it
+// has none
+func (m *controlPlaneManager) URL(clusterID string) string {
+	if cp := m.Get(clusterID); cp != nil {
+		return cp.url
+	}
+	return ""
+}
+
+// Get returns the running control plane of a cluster, or nil
+func (m *controlPlaneManager) Get(clusterID string) *controlPlane {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.planes[strings.ToLower(clusterID)]
+}
+
+// waitStarting waits for a start of the cluster's control plane in
+// progress to finish. m.mu must be held; it is released while waiting.
+func (m *controlPlaneManager) waitStarting(key string) {
+	for {
+		done, ok := m.starting[key]
+		if !ok {
+			return
+		}
+		m.mu.Unlock()
+		<-done
+		m.mu.Lock()
+	}
+}
+
+// Start starts the control plane of a cluster and waits until it is ready.
+// It returns the running one if the cluster already has a control plane.
+func (m *controlPlaneManager) Start(clusterID string) (*controlPlane, error) {
+	key := strings.ToLower(clusterID)
+	m.mu.Lock()
+	m.waitStarting(key)
+	if cp, ok := m.planes[key]; ok {
+		m.mu.Unlock()
+		return cp, nil
+	}
+	done := make(chan struct{})
+	m.starting[key] = done
+	m.mu.Unlock()
+
+	// The ports are picked before the processes bind them, so another
+	// process can take one in between; start over on fresh ports then
+	var cp *controlPlane
+	var err error
+	for attempt := 1; attempt <= 3; attempt++ {
+		if cp, err = m.launch(clusterID); !errors.Is(err, errPortInUse) {
+			break
+		}
+		log.Printf("Control plane of %s lost a port to another process, retrying", clusterID)
+	}
+
+	m.mu.Lock()
+	if err == nil {
+		m.planes[key] = cp
+	}
+	delete(m.starting, key)
+	close(done)
+	m.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Started control plane for %s at %s", clusterID, cp.url)
+	return cp, nil
+}
+
+func (m *controlPlaneManager) start(clusterID string) (*controlPlane, error) {
+	dir, err := os.MkdirTemp("", "aro-mockup-proxy-envtest-")
+	if err != nil {
+		return nil, err
+	}
+	cp := &controlPlane{clusterID: clusterID, dir: dir}
+	ok := false
+	defer func() {
+		if !ok {
+			cp.stop()
+		}
+	}()
+
+	ports, err := freePorts(3)
+	if err != nil {
+		return nil, err
+	}
+	etcdURL := fmt.Sprintf("http://127.0.0.1:%d", ports[0])
+	host := m.config.ControlPlaneHost
+	cp.url = "https://" + net.JoinHostPort(host, fmt.Sprint(ports[2]))
+
+	if err := m.writeCertificates(cp, host); err != nil {
+		return nil, err
+	}
+
+	if err := cp.run("etcd", filepath.Join(m.config.ControlPlaneAssets, "etcd"),
+		"--data-dir="+filepath.Join(dir, "etcd"),
+		"--listen-client-urls="+etcdURL,
+		"--advertise-client-urls="+etcdURL,
+		fmt.Sprintf("--listen-peer-urls=http://127.0.0.1:%d", ports[1]),
+		"--unsafe-no-fsync=true",
+	); err != nil {
+		return nil, err
+	}
+
+	bindAddress := "0.0.0.0"
+	if ip := net.ParseIP(host); (ip != nil && ip.IsLoopback()) || host == "localhost" {
+		bindAddress = "127.0.0.1"
+	}
+	if err := cp.run("kube-apiserver", filepath.Join(m.config.ControlPlaneAssets, "kube-apiserver"),
+		"--etcd-servers="+etcdURL,
+		"--bind-address="+bindAddress,
+		fmt.Sprintf("--secure-port=%d", ports[2]),
+		"--cert-dir="+dir,
+		"--tls-cert-file="+filepath.Join(dir, "apiserver.crt"),
+		"--tls-private-key-file="+filepath.Join(dir, "apiserver.key"),
+		"--client-ca-file="+filepath.Join(dir, "ca.crt"),
+		"--service-account-key-file="+filepath.Join(dir, "sa.key"),
+		"--service-account-signing-key-file="+filepath.Join(dir, "sa.key"),
+		"--service-account-issuer=https://kubernetes.default.svc",
+		"--service-cluster-ip-range=10.0.0.0/24",
+		"--authorization-mode=RBAC",
+		"--allow-privileged=true",
+		"--disable-admission-plugins=ServiceAccount",
+	); err != nil {
+		return nil, err
+	}
+
+	if err := cp.waitReady(m.config.ControlPlaneStartTimeout); err != nil {
+		if cp.lostPort() {
+			return nil, errPortInUse
+		}
+		return nil, fmt.Errorf("%w: %s", err, cp.lastLogLine())
+	}
+	ok = true
+	return cp, nil
+}
+
+// writeCertificates writes the CA, the API server serving certificate and
+// the service account key, and sets up the admin client of the control
+// plane
+func (m *controlPlaneManager) writeCertificates(cp *controlPlane, host string) error {
+	servingCert, servingKey, err := m.ca.issueServing([]string{host, "127.0.0.1", "localhost", "kubernetes.default.svc"}, 10*365*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	saKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	saKeyDER, err := x509.MarshalECPrivateKey(saKey)
+	if err != nil {
+		return err
+	}
+
+	for name, data := range map[string][]byte{
+		"ca.crt":        m.ca.certPEM(),
+		"apiserver.crt": servingCert,
+		"apiserver.key": servingKey,
+		"sa.key":        pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: saKeyDER}),
+	} {
+		if err := os.WriteFile(filepath.Join(cp.dir, name), data, 0600); err != nil {
+			return err
+		}
+	}
+
+	_, clientCert, clientKey, err := m.ca.issue(cp.clusterID, time.Now(), 10*365*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	keyPair, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(m.ca.cert)
+	cp.client = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: []tls.Certificate{keyPair},
+				// the advertised host may not be the one we connect to
+				ServerName: "127.0.0.1",
+			},
+		},
+	}
+	return nil
+}
+
+// run starts one of the control plane binaries, logging to <name>.log in
+// the control plane directory
+func (cp *controlPlane) run(name, binary string, args ...string) error {
+	logFile, err := os.Create(filepath.Join(cp.dir, name+".log"))
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return fmt.Errorf("starting %s: %w", name, err)
+	}
+
+	proc := &controlPlaneProcess{name: name, cmd: cmd, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		logFile.Close()
+		close(proc.done)
+	}()
+	cp.processes = append(cp.processes, proc)
+	return nil
+}
+
+// lastLogLine returns the last line logged by the control plane process
+// that stopped or, if none did, by the API server
+func (cp *controlPlane) lastLogLine() string {
+	name := "kube-apiserver"
+	for _, proc := range cp.processes {
+		select {
+		case <-proc.done:
+			name = proc.name
+		default:
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(cp.dir, name+".log"))
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	return name + ": " + lines[len(lines)-1]
+}
+
+// lostPort reports whether a control plane process exited because its port
+// was already taken
+func (cp *controlPlane) lostPort() bool {
+	for _, proc := range cp.processes {
+		select {
+		case <-proc.done:
+			data, _ := os.ReadFile(filepath.Join(cp.dir, proc.name+".log"))
+			if strings.Contains(string(data), "address already in use") {
+				return true
+			}
+		default:
+		}
+	}
+	return false
+}
+
+// localURL is the API server URL on the loopback address, which is where
+// the proxy reaches it from
+func (cp *controlPlane) localURL() string {
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(cp.url, "https://"))
+	return "https://127.0.0.1:" + port
+}
+
+// waitReady polls /readyz until the API server reports ready
+func (cp *controlPlane) waitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for _, proc := range cp.processes {
+			select {
+			case <-proc.done:
+				return fmt.Errorf("%s exited during startup", proc.name)
+			default:
+			}
+		}
+		if resp, err := cp.client.Get(cp.localURL() + "/readyz"); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("control plane not ready after %s", timeout)
+}
+
+// stop terminates the processes in reverse start order and removes the
+// control plane directory
+func (cp *controlPlane) stop() {
+	for i := len(cp.processes) - 1; i >= 0; i-- {
+		proc := cp.processes[i]
+		proc.cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-proc.done:
+		case <-time.After(10 * time.Second):
+			proc.cmd.Process.Kill()
+			<-proc.done
+		}
+	}
+	os.RemoveAll(cp.dir)
+}
+
+// Stop tears down the control plane of a cluster, if it has one. A start in
+// progress is waited for, so its control plane does not outlive the stop.
+func (m *controlPlaneManager) Stop(clusterID string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	key := strings.ToLower(clusterID)
+	m.waitStarting(key)
+	cp, ok := m.planes[key]
+	delete(m.planes, key)
+	m.mu.Unlock()
+
+	if ok {
+		cp.stop()
+		log.Printf("Stopped control plane for %s", clusterID)
+	}
+}
+
+// Close tears down every control plane
+func (m *controlPlaneManager) Close() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	var ids []string
+	for _, cp := range m.planes {
+		ids = append(ids, cp.clusterID)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		m.Stop(id)
+	}
+}
+
+// freePorts returns n distinct TCP ports the kernel picked as free on the
+// loopback address. They are released on return, so callers must handle
+// losing one of them before binding it.
+func freePorts(n int) ([]int, error) {
+	var ports []int
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// startControlPlane starts the control plane of a cluster and points its
+// api.url at it. Failures are logged; the cluster then keeps its mock URL.
+func (p *AROHCPMockProxyEnhanced) startControlPlane(clusterID string) {
+	if p.controlPlanes == nil || !clusterIDRE.MatchString(clusterID) {
+		return
+	}
+	cp, err := p.controlPlanes.Start(clusterID)
+	if err != nil {
+		log.Printf("Failed to start control plane for %s: %v", clusterID, err)
+		return
+	}
+
+	// The cluster may have been deleted while the control plane started,
+	// after the stop that came with the delete found nothing to tear down
+	resource, err := p.getResource(clusterID)
+	if err != nil || resource.ProvisioningState == "Deleting" {
+		p.controlPlanes.Stop(clusterID)
+		return
+	}
+	var props map[string]interface{}
+	json.Unmarshal([]byte(resource.Properties), &props)
+	api, ok := props["api"].(map[string]interface{})
+	if !ok || api["url"] == cp.url {
+		return
+	}
+	api["url"] = cp.url
+	properties, _ := json.Marshal(props)
+	p.db.Exec("UPDATE resources SET properties = ? WHERE id = ?", string(properties), clusterID)
+}
+
+// clusterAPIURL returns the api.url of a cluster: its control plane if it
+// has one, or a made-up mock host
+func (p *AROHCPMockProxyEnhanced) clusterAPIURL(clusterID, name string) string {
+	if url := p.controlPlanes.URL(clusterID); url != "" {
+		return url
+	}
+	return fmt.Sprintf("https://%s-api.mock.arodev.io:6443", name)
+}
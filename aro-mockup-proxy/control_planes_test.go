@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newStubControlPlaneManager returns a manager whose control planes are
+// started by launch instead of the envtest binaries
+func newStubControlPlaneManager(t *testing.T, launch func(clusterID string) (*controlPlane, error)) *controlPlaneManager {
+	t.Helper()
+	assets := t.TempDir()
+	for _, binary := range []string{"etcd", "kube-apiserver"} {
+		if err := os.WriteFile(filepath.Join(assets, binary), nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := newControlPlaneManager(&Config{ControlPlaneAssets: assets}, nil)
+	if err != nil {
+		t.Fatalf("create manager: %v", err)
+	}
+	m.launch = launch
+	t.Cleanup(m.Close)
+	return m
+}
+
+// stubControlPlane returns a control plane without processes; its
+// directory is removed when it is stopped
+func stubControlPlane(t *testing.T, clusterID string) *controlPlane {
+	dir, err := os.MkdirTemp(t.TempDir(), "cp-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &controlPlane{clusterID: clusterID, dir: dir, url: "https://127.0.0.1:6443"}
+}
+
+func TestNewControlPlaneManager(t *testing.T) {
+	if _, err := newControlPlaneManager(&Config{ControlPlaneAssets: t.TempDir()}, nil); err == nil || !strings.Contains(err.Error(), "KUBEBUILDER_ASSETS") {
+		t.Errorf("error %v without the envtest binaries, want one naming KUBEBUILDER_ASSETS", err)
+	}
+
+	// A nil manager runs nothing
+	var m *controlPlaneManager
+	m.Stop(testClusterID)
+	m.Close()
+	if m.Get(testClusterID) != nil || m.URL(testClusterID) != "" {
+		t.Errorf("nil manager has a control plane")
+	}
+}
+
+func TestControlPlaneStartOnce(t *testing.T) {
+	var launches atomic.Int32
+	release := make(chan struct{})
+	m := newStubControlPlaneManager(t, func(clusterID string) (*controlPlane, error) {
+		launches.Add(1)
+		<-release
+		return stubControlPlane(t, clusterID), nil
+	})
+
+	// Starts of one cluster, in any casing, share a single launch
+	ids := []string{testClusterID, strings.ToUpper(testClusterID), testClusterID, strings.ToLower(testClusterID)}
+	planes := make([]*controlPlane, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cp, err := m.Start(id)
+			if err != nil {
+				t.Errorf("start %s: %v", id, err)
+			}
+			planes[i] = cp
+		}()
+	}
+	eventually(t, "the launch", func() bool { return launches.Load() == 1 })
+	if m.Get(testClusterID) != nil {
+		t.Errorf("control plane listed before its start finished")
+	}
+	close(release)
+	wg.Wait()
+
+	if n := launches.Load(); n != 1 {
+		t.Errorf("%d launches, want 1", n)
+	}
+	for i, cp := range planes {
+		if cp == nil || cp != planes[0] {
+			t.Errorf("start %d returned %p, want the shared control plane %p", i, cp, planes[0])
+		}
+	}
+	if m.URL(strings.ToUpper(testClusterID)) != planes[0].url {
+		t.Errorf("URL %q, want %q", m.URL(testClusterID), planes[0].url)
+	}
+
+	// A running control plane is returned as is
+	if cp, err := m.Start(testClusterID); err != nil || cp != planes[0] || launches.Load() != 1 {
+		t.Errorf("start of a running control plane = %p, %v after %d launches", cp, err, launches.Load())
+	}
+}
+
+func TestControlPlaneStopWaitsForStart(t *testing.T) {
+	release := make(chan struct{})
+	var launched atomic.Pointer[controlPlane]
+	m := newStubControlPlaneManager(t, func(clusterID string) (*controlPlane, error) {
+		cp := stubControlPlane(t, clusterID)
+		launched.Store(cp)
+		<-release
+		return cp, nil
+	})
+
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		m.Start(testClusterID)
+	}()
+	eventually(t, "the launch", func() bool { return launched.Load() != nil })
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		m.Stop(strings.ToUpper(testClusterID))
+	}()
+	select {
+	case <-stopped:
+		t.Fatalf("stop returned while the start was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-started
+	<-stopped
+	if m.Get(testClusterID) != nil {
+		t.Errorf("control plane outlived the stop")
+	}
+	if _, err := os.Stat(launched.Load().dir); !os.IsNotExist(err) {
+		t.Errorf("control plane directory left behind: %v", err)
+	}
+}
+
+func TestControlPlaneStartErrors(t *testing.T) {
+	errFailed := errors.New("kube-apiserver exited during startup")
+
+	tests := []struct {
+		name         string
+		errs         []error // returned by the launches in turn, then success
+		wantLaunches int32
+		wantErr      error
+	}{
+		{name: "started", wantLaunches: 1},
+		{name: "port lost once", errs: []error{errPortInUse}, wantLaunches: 2},
+		{name: "port lost every time", errs: []error{errPortInUse, errPortInUse, errPortInUse}, wantLaunches: 3, wantErr: errPortInUse},
+		{name: "failed", errs: []error{errFailed}, wantLaunches: 1, wantErr: errFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var launches atomic.Int32
+			m := newStubControlPlaneManager(t, func(clusterID string) (*controlPlane, error) {
+				if n := int(launches.Add(1)); n <= len(tt.errs) {
+					return nil, tt.errs[n-1]
+				}
+				return stubControlPlane(t, clusterID), nil
+			})
+
+			cp, err := m.Start(testClusterID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("start error %v, want %v", err, tt.wantErr)
+			}
+			if n := launches.Load(); n != tt.wantLaunches {
+				t.Errorf("%d launches, want %d", n, tt.wantLaunches)
+			}
+			if got := m.Get(testClusterID); got != cp {
+				t.Errorf("listed control plane %p, want %p", got, cp)
+			}
+
+			// A failed start is not remembered, the next one launches again
+			if tt.wantErr != nil {
+				if _, err := m.Start(testClusterID); err != nil || launches.Load() != tt.wantLaunches+1 {
+					t.Errorf("start after the failure = %v after %d launches", err, launches.Load())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// credentialAuthority is the local CA that signs the client certificates
+// handed out by requestAdminCredential. Load the workload cluster's CA
+// (ADMIN_CA_CERT_FILE/ADMIN_CA_KEY_FILE) for the API server to accept them.
+type credentialAuthority struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// loadCredentialAuthority reads the CA from certFile and keyFile, generating
+// and writing a new self-signed one if they do not exist yet. Without paths
+// the CA only lives as long as the process.
+func loadCredentialAuthority(certFile, keyFile string) (*credentialAuthority, error) {
+	if certFile != "" && keyFile != "" {
+		certPEM, certErr := os.ReadFile(certFile)
+		keyPEM, keyErr := os.ReadFile(keyFile)
+		if certErr == nil && keyErr == nil {
+			return parseCredentialAuthority(certPEM, keyPEM)
+		}
+		if !errors.Is(certErr, os.ErrNotExist) && certErr != nil {
+			return nil, certErr
+		}
+		if !errors.Is(keyErr, os.ErrNotExist) && keyErr != nil {
+			return nil, keyErr
+		}
+	}
+
+	ca, certPEM, keyPEM, err := generateCredentialAuthority()
+	if err != nil {
+		return nil, err
+	}
+	if certFile != "" && keyFile != "" {
+		if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return nil, err
+		}
+		log.Printf("Generated admin credential CA in %s", certFile)
+	}
+	return ca, nil
+}
+
+func parseCredentialAuthority(certPEM, keyPEM []byte) (*credentialAuthority, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	var key interface{}
+	switch keyBlock.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(keyBlock.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return &credentialAuthority{cert: cert, key: signer}, nil
+}
+
+func generateCredentialAuthority() (*credentialAuthority, []byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "aro-mockup-proxy-admin-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &credentialAuthority{cert: cert, key: key},
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// adminCredential is one client certificate issued for a cluster
+type adminCredential struct {
+	ID           string     `json:"id"`
+	ClusterID    string     `json:"clusterId"`
+	SerialNumber string     `json:"serialNumber"`
+	Subject      string     `json:"subject"`
+	IssuedAt     time.Time  `json:"issuedAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+	Status       string     `json:"status"`
+}
+
+// issue signs a new client certificate for clusterID valid for ttl from
+// now. The user is a member of system:masters so it is a cluster admin on
+// any API server that trusts the CA.
+func (ca *credentialAuthority) issue(clusterID string, now time.Time, ttl time.Duration) (*adminCredential, []byte, []byte, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cred := &adminCredential{
+		ID:           stableUUID(clusterID + ":" + serial.Text(16)),
+		ClusterID:    clusterID,
+		SerialNumber: serial.Text(16),
+		IssuedAt:     now.UTC().Truncate(time.Second),
+		ExpiresAt:    now.Add(ttl).UTC().Truncate(time.Second),
+	}
+	subject := pkix.Name{
+		CommonName:   "system:customer-break-glass:" + cred.ID,
+		Organization: []string{"system:masters"},
+	}
+	cred.Subject = subject.String()
+
+	// now may be ahead of the wall clock API servers check NotBefore against
+	certPEM, keyPEM, err := ca.sign(&x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    minTime(now, time.Now()).Add(-time.Minute),
+		NotAfter:     cred.ExpiresAt,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cred, certPEM, keyPEM, nil
+}
+
+// minTime returns the earlier of two times
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// issueServing signs a serving certificate for the given host names and IPs
+func (ca *credentialAuthority) issueServing(hosts []string, ttl time.Duration) ([]byte, []byte, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return ca.sign(template)
+}
+
+// sign generates a key pair and signs template for its public key. It
+// returns the PEM encoded certificate and private key.
+func (ca *credentialAuthority) sign(template *x509.Certificate) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+// certPEM returns the PEM encoded CA certificate
+func (ca *credentialAuthority) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// kubeconfig is the subset of a kubeconfig file the proxy reads and writes
+type kubeconfig struct {
+	APIVersion     string            `json:"apiVersion"`
+	Kind           string            `json:"kind"`
+	Clusters       []kubeconfigEntry `json:"clusters"`
+	Users          []kubeconfigEntry `json:"users"`
+	Contexts       []kubeconfigEntry `json:"contexts"`
+	CurrentContext string            `json:"current-context"`
+}
+
+type kubeconfigEntry struct {
+	Name    string                 `json:"name"`
+	Cluster map[string]interface{} `json:"cluster,omitempty"`
+	User    map[string]interface{} `json:"user,omitempty"`
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+// workloadContext returns the cluster entry (server and CA) and the user
+// entry of the current context of the workload kubeconfig at path
+func workloadContext(path string) (cluster, user map[string]interface{}, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var config kubeconfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, nil, err
+	}
+
+	clusterName, userName := "", ""
+	for _, c := range config.Contexts {
+		if c.Name == config.CurrentContext {
+			clusterName, _ = c.Context["cluster"].(string)
+			userName, _ = c.Context["user"].(string)
+		}
+	}
+	for _, u := range config.Users {
+		if u.Name == userName {
+			user = u.User
+		}
+	}
+	for _, c := range config.Clusters {
+		if c.Name == clusterName || (clusterName == "" && len(config.Clusters) == 1) {
+			return c.Cluster, user, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no cluster for current context %q", config.CurrentContext)
+}
+
+// buildAdminKubeconfig returns a kubeconfig for the cluster's own control
+// plane, or else the workload API server, that authenticates with the
+// given client certificate. Without either it points at the cluster's mock
+// api.url. The workload API server only trusts a CA it was set up with, so
+// without ADMIN_CA_CERT_FILE the kubeconfig carries the workload
+// kubeconfig's own user instead.
+func (p *AROHCPMockProxyEnhanced) buildAdminKubeconfig(cluster *Resource, certPEM, keyPEM []byte) ([]byte, error) {
+	user := map[string]interface{}{
+		"client-certificate-data": certPEM,
+		"client-key-data":         keyPEM,
+	}
+	var server, workloadUser map[string]interface{}
+	var err error
+	if url := p.controlPlanes.URL(cluster.ID); url != "" {
+		server = map[string]interface{}{
+			"server":                     url,
+			"certificate-authority-data": p.credentialCA.certPEM(),
+		}
+	} else if server, workloadUser, err = workloadContext(p.config.WorkloadKubeconfigPath); err == nil {
+		if p.config.AdminCACertFile == "" && workloadUser != nil {
+			log.Printf("No admin credential CA configured, handing out the workload kubeconfig user for %s", cluster.ID)
+			user = workloadUser
+		}
+	} else {
+		log.Printf("Workload kubeconfig %s not usable (%v), using the cluster API URL", p.config.WorkloadKubeconfigPath, err)
+		var props struct {
+			API struct {
+				URL string `json:"url"`
+			} `json:"api"`
+		}
+		json.Unmarshal([]byte(cluster.Properties), &props)
+		server = map[string]interface{}{
+			"server":                   props.API.URL,
+			"insecure-skip-tls-verify": true,
+		}
+	}
+
+	name := cluster.Name
+	return yaml.Marshal(kubeconfig{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters:   []kubeconfigEntry{{Name: name, Cluster: server}},
+		Users:      []kubeconfigEntry{{Name: name + "-admin", User: user}},
+		Contexts: []kubeconfigEntry{{Name: name, Context: map[string]interface{}{
+			"cluster": name,
+			"user":    name + "-admin",
+		}}},
+		CurrentContext: name,
+	})
+}
+
+// issueAdminCredential mints and records a new credential for a cluster and
+// returns the requestAdminCredential result
+func (p *AROHCPMockProxyEnhanced) issueAdminCredential(cluster *Resource) (map[string]interface{}, error) {
+	// The certificate expires when the recorded credential does, on the
+	// virtual clock
+	cred, certPEM, keyPEM, err := p.credentialCA.issue(cluster.ID, p.clock.Now(), p.config.AdminCredentialTTL)
+	if err != nil {
+		return nil, err
+	}
+	kubeconfigBytes, err := p.buildAdminKubeconfig(cluster, certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.db.Exec(`
+		INSERT INTO admin_credentials (id, cluster_id, serial_number, subject, issued_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, cred.ID, cred.ClusterID, cred.SerialNumber, cred.Subject, cred.IssuedAt, cred.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Issued admin credential %s for %s (expires %s)", cred.ID, cluster.ID, cred.ExpiresAt.Format(time.RFC3339))
+
+	return map[string]interface{}{
+		"kubeconfig":          string(kubeconfigBytes),
+		"expirationTimestamp": cred.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// revokeAdminCredentials marks every unrevoked credential of a cluster as
+// revoked and returns how many were
+func (p *AROHCPMockProxyEnhanced) revokeAdminCredentials(clusterID string) (int64, error) {
+	result, err := p.db.Exec(`
+		UPDATE admin_credentials SET revoked_at = ?
+		WHERE cluster_id = ? COLLATE NOCASE AND revoked_at IS NULL
+	`, p.clock.Now().UTC().Truncate(time.Second), clusterID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// handleAdminCredentials lists the issued admin credentials and whether
+// they are active, expired or revoked. ?clusterId= limits the list to one
+// cluster.
+func (p *AROHCPMockProxyEnhanced) handleAdminCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+		return
+	}
+
+	query := `SELECT id, cluster_id, serial_number, subject, issued_at, expires_at, revoked_at
+		FROM admin_credentials`
+	var args []interface{}
+	if clusterID := r.URL.Query().Get("clusterId"); clusterID != "" {
+		query += " WHERE cluster_id = ? COLLATE NOCASE"
+		args = append(args, clusterID)
+	}
+	rows, err := p.db.Query(query+" ORDER BY issued_at", args...)
+	if err != nil {
+		log.Printf("Database error listing admin credentials: %v", err)
+		writeCloudError(w, http.StatusInternalServerError, internalServerError())
+		return
+	}
+	defer rows.Close()
+
+	credentials := []adminCredential{}
+	now := p.clock.Now()
+	for rows.Next() {
+		var cred adminCredential
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&cred.ID, &cred.ClusterID, &cred.SerialNumber, &cred.Subject,
+			&cred.IssuedAt, &cred.ExpiresAt, &revokedAt); err != nil {
+			log.Printf("Failed to scan admin credential: %v", err)
+			continue
+		}
+		switch {
+		case revokedAt.Valid:
+			cred.RevokedAt = &revokedAt.Time
+			cred.Status = "Revoked"
+		case now.After(cred.ExpiresAt):
+			cred.Status = "Expired"
+		default:
+			cred.Status = "Active"
+		}
+		credentials = append(credentials, cred)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"caCertificate": string(p.credentialCA.certPEM()),
+		"value":         credentials,
+	})
+}
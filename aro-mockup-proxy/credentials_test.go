@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const testWorkloadKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: workload
+  cluster:
+    server: https://workload.example:6443
+contexts:
+- name: workload
+  context:
+    cluster: workload
+    user: workload-admin
+current-context: workload
+users:
+- name: workload-admin
+  user:
+    token: workload-token
+`
+
+// adminKubeconfig issues a credential for the cluster and returns the
+// cluster and user entries of its kubeconfig
+func adminKubeconfig(t *testing.T, p *AROHCPMockProxyEnhanced, cluster *Resource) (server, user map[string]interface{}) {
+	t.Helper()
+	result, err := p.issueAdminCredential(cluster)
+	if err != nil {
+		t.Fatalf("issue credential: %v", err)
+	}
+	var config kubeconfig
+	if err := yaml.Unmarshal([]byte(result["kubeconfig"].(string)), &config); err != nil {
+		t.Fatalf("decode kubeconfig: %v", err)
+	}
+	if len(config.Clusters) != 1 || len(config.Users) != 1 {
+		t.Fatalf("kubeconfig has %d clusters and %d users, want one each", len(config.Clusters), len(config.Users))
+	}
+	return config.Clusters[0].Cluster, config.Users[0].User
+}
+
+// verifyClientCertificate fails the test unless the client certificate of
+// a kubeconfig user chains to caPEM
+func verifyClientCertificate(t *testing.T, user map[string]interface{}, caPEM []byte) {
+	t.Helper()
+	data, _ := user["client-certificate-data"].(string)
+	certPEM, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatalf("decode client-certificate-data: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatalf("no client certificate in %v", user)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse client certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatalf("no CA certificate in %s", caPEM)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("client certificate does not chain to the CA: %v", err)
+	}
+}
+
+func TestAdminKubeconfig(t *testing.T) {
+	dir := t.TempDir()
+	workloadPath := filepath.Join(dir, "workload-kubeconfig.yaml")
+	if err := os.WriteFile(workloadPath, []byte(testWorkloadKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, caCertPEM, caKeyPEM, err := generateCredentialAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertFile, caKeyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if err := os.WriteFile(caCertFile, caCertPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(caKeyFile, caKeyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("configured CA", func(t *testing.T) {
+		p := newTestProxy(t, func(c *Config) {
+			c.WorkloadKubeconfigPath = workloadPath
+			c.AdminCACertFile, c.AdminCAKeyFile = caCertFile, caKeyFile
+		})
+		insertTestResource(t, p.db, testClusterID, "Succeeded")
+		cluster, _ := p.getResource(testClusterID)
+
+		server, user := adminKubeconfig(t, p, cluster)
+		if server["server"] != "https://workload.example:6443" {
+			t.Errorf("server %v, want the workload API server", server["server"])
+		}
+		verifyClientCertificate(t, user, caCertPEM)
+	})
+
+	t.Run("no CA", func(t *testing.T) {
+		p := newTestProxy(t, func(c *Config) { c.WorkloadKubeconfigPath = workloadPath })
+		insertTestResource(t, p.db, testClusterID, "Succeeded")
+		cluster, _ := p.getResource(testClusterID)
+
+		// The workload API server does not trust the generated CA
+		server, user := adminKubeconfig(t, p, cluster)
+		if server["server"] != "https://workload.example:6443" || user["token"] != "workload-token" || len(user) != 1 {
+			t.Errorf("server %v with user %v, want the workload kubeconfig's", server["server"], user)
+		}
+	})
+
+	t.Run("no workload kubeconfig", func(t *testing.T) {
+		p := newTestProxy(t, func(c *Config) { c.WorkloadKubeconfigPath = filepath.Join(dir, "missing.yaml") })
+		insertTestResource(t, p.db, testClusterID, "Succeeded")
+		p.db.Exec(`UPDATE resources SET properties = ? WHERE id = ?`, `{"api":{"url":"https://api.c1.example:6443"}}`, testClusterID)
+		cluster, _ := p.getResource(testClusterID)
+
+		server, user := adminKubeconfig(t, p, cluster)
+		if server["server"] != "https://api.c1.example:6443" || server["insecure-skip-tls-verify"] != true {
+			t.Errorf("server %v, want the cluster API URL without TLS verification", server)
+		}
+		verifyClientCertificate(t, user, p.credentialCA.certPEM())
+	})
+}
+
+func TestAdminCredentials(t *testing.T) {
+	p := newTestProxy(t, nil)
+	otherClusterID := testClusterID + "x"
+	insertTestResource(t, p.db, testClusterID, "Succeeded")
+	insertTestResource(t, p.db, otherClusterID, "Succeeded")
+	cluster, _ := p.getResource(testClusterID)
+	otherCluster, _ := p.getResource(otherClusterID)
+
+	list := func(clusterID string) map[string]string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		p.adminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/admin/credentials?clusterId="+clusterID, nil))
+		var response struct {
+			CACertificate string            `json:"caCertificate"`
+			Value         []adminCredential `json:"value"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decode credentials: %v: %s", err, w.Body)
+		}
+		if response.CACertificate != string(p.credentialCA.certPEM()) {
+			t.Errorf("caCertificate %q, want the credential CA", response.CACertificate)
+		}
+		statuses := map[string]string{}
+		for _, cred := range response.Value {
+			if cred.ClusterID != clusterID {
+				t.Errorf("credential %s of %s listed for %s", cred.ID, cred.ClusterID, clusterID)
+			}
+			if (cred.Status == "Revoked") != (cred.RevokedAt != nil) {
+				t.Errorf("credential %s is %s revoked at %v", cred.ID, cred.Status, cred.RevokedAt)
+			}
+			statuses[cred.ID] = cred.Status
+		}
+		return statuses
+	}
+	issue := func(cluster *Resource) {
+		t.Helper()
+		if _, err := p.issueAdminCredential(cluster); err != nil {
+			t.Fatalf("issue credential: %v", err)
+		}
+	}
+
+	issue(cluster)
+	issue(cluster)
+	issue(otherCluster)
+	statuses := list(testClusterID)
+	if len(statuses) != 2 {
+		t.Fatalf("listed %v, want two credentials", statuses)
+	}
+	for id, status := range statuses {
+		if status != "Active" {
+			t.Errorf("credential %s is %s, want Active", id, status)
+		}
+	}
+
+	if w := serve(p, "POST", testClusterID+"/revokeCredentials"+testAPIVersion, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("revoke: status %d, want 204: %s", w.Code, w.Body)
+	}
+	for id, status := range list(testClusterID) {
+		if status != "Revoked" {
+			t.Errorf("credential %s is %s after the revoke, want Revoked", id, status)
+		}
+	}
+	for id, status := range list(otherClusterID) {
+		if status != "Active" {
+			t.Errorf("credential %s of the other cluster is %s, want Active", id, status)
+		}
+	}
+
+	// Credentials issued after the revoke stay valid until they expire
+	issue(cluster)
+	if statuses := list(testClusterID); len(statuses) != 3 {
+		t.Fatalf("listed %v, want three credentials", statuses)
+	}
+	p.clock.update(p.config.AdminCredentialTTL+time.Minute, 1)
+	active := 0
+	for _, status := range list(testClusterID) {
+		if status == "Active" {
+			active++
+		}
+	}
+	if active != 0 {
+		t.Errorf("%d credentials active past their TTL, want none", active)
+	}
+	if statuses := list(otherClusterID); len(statuses) != 1 {
+		t.Errorf("listed %v for the other cluster, want one credential", statuses)
+	}
+	for _, status := range list(otherClusterID) {
+		if status != "Expired" {
+			t.Errorf("credential of the other cluster is %s past its TTL, want Expired", status)
+		}
+	}
+
+	if w := serve(p, "POST", testClusterID+"y/revokeCredentials"+testAPIVersion, ""); w.Code != http.StatusNotFound {
+		t.Errorf("revoke on a missing cluster: status %d, want 404", w.Code)
+	}
+}
+
+func TestRequestAdminCredential(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      string // "" when there is no cluster
+		method     string
+		wantStatus int
+	}{
+		{name: "succeeded cluster", state: "Succeeded", method: "POST", wantStatus: http.StatusAccepted},
+		{name: "provisioning cluster", state: "Provisioning", method: "POST", wantStatus: http.StatusConflict},
+		{name: "missing cluster", method: "POST", wantStatus: http.StatusNotFound},
+		{name: "GET", state: "Succeeded", method: "GET", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, nil)
+			if tt.state != "" {
+				insertTestResource(t, p.db, testClusterID, tt.state)
+			}
+			w := serve(p, tt.method, testClusterID+"/requestAdminCredential"+testAPIVersion, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var issued int
+			p.db.QueryRow(`SELECT COUNT(*) FROM admin_credentials`).Scan(&issued)
+			if wantIssued := tt.wantStatus == http.StatusAccepted; (issued == 1) != wantIssued {
+				t.Errorf("%d credentials issued after status %d", issued, w.Code)
+			}
+			if w.Code == http.StatusAccepted && (w.Header().Get("Azure-AsyncOperation") == "" || w.Header().Get("Location") == "") {
+				t.Errorf("202 without the operation URLs: %v", w.Header())
+			}
+		})
+	}
+}
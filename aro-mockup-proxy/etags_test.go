@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{name: "quoted", header: `"abc"`, want: true},
+		{name: "unquoted", header: `abc`, want: true},
+		{name: "weak", header: `W/"abc"`, want: true},
+		{name: "wildcard", header: `*`, want: true},
+		{name: "in a list", header: `"x", "abc"`, want: true},
+		{name: "other", header: `"abd"`, want: false},
+		{name: "other list", header: `"x","y"`, want: false},
+		{name: "case sensitive", header: `"ABC"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, "abc"); got != tt.want {
+				t.Errorf("etagMatches(%s) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	existing := &Resource{ID: testClusterID, ETag: "abc"}
+
+	tests := []struct {
+		name        string
+		ifMatch     string
+		ifNoneMatch string
+		existing    *Resource
+		wantETag    string
+		wantFailed  bool
+	}{
+		{name: "no headers", existing: existing},
+		{name: "no headers on create"},
+		{name: "If-Match", ifMatch: `"abc"`, existing: existing, wantETag: "abc"},
+		{name: "If-Match wildcard", ifMatch: `*`, existing: existing, wantETag: "abc"},
+		{name: "If-Match stale", ifMatch: `"old"`, existing: existing, wantFailed: true},
+		{name: "If-Match on create", ifMatch: `*`, wantFailed: true},
+		{name: "If-None-Match wildcard on create", ifNoneMatch: `*`},
+		{name: "If-None-Match wildcard on update", ifNoneMatch: `*`, existing: existing, wantFailed: true},
+		{name: "If-None-Match other", ifNoneMatch: `"old"`, existing: existing},
+		{name: "If-None-Match current", ifNoneMatch: `"abc"`, existing: existing, wantFailed: true},
+		{name: "If-Match wins", ifMatch: `"abc"`, ifNoneMatch: `*`, existing: existing, wantETag: "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", testClusterID, nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			etag, perr := checkPreconditions(r, tt.existing)
+			if (perr != nil) != tt.wantFailed {
+				t.Fatalf("error %+v, want failed %v", perr, tt.wantFailed)
+			}
+			if perr != nil && perr.Code != "PreconditionFailed" {
+				t.Errorf("code %s, want PreconditionFailed", perr.Code)
+			}
+			if etag != tt.wantETag {
+				t.Errorf("etag %q, want %q", etag, tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestETagTriggers(t *testing.T) {
+	db := newTestDB(t)
+	etag := func() string {
+		var etag string
+		if err := db.QueryRow(`SELECT etag FROM resources WHERE id = ?`, testClusterID).Scan(&etag); err != nil {
+			t.Fatalf("read etag: %v", err)
+		}
+		return etag
+	}
+
+	insertTestResource(t, db, testClusterID, "Creating")
+	created := etag()
+	if len(created) != 32 {
+		t.Fatalf("etag %q on insert, want 32 hex digits", created)
+	}
+
+	db.Exec(`UPDATE resources SET provisioning_state = 'Succeeded' WHERE id = ?`, testClusterID)
+	updated := etag()
+	if updated == created {
+		t.Errorf("etag unchanged by an update")
+	}
+
+	// A migration on an up-to-date database keeps the etags
+	if err := migrateETags(db); err != nil {
+		t.Fatalf("migrateETags: %v", err)
+	}
+	if etag() != updated {
+		t.Errorf("etag changed by a repeated migration")
+	}
+	if got := formatETag(updated); got != `"`+updated+`"` {
+		t.Errorf("formatETag = %s", got)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Fault actions
+const (
+	faultFail    = "fail"    // fail the long-running operation with Code
+	faultHang    = "hang"    // keep the operation InProgress until the rule is removed
+	faultRespond = "respond" // answer the request itself with StatusCode and Code
+)
+
+// faultRule injects a failure into the requests or operations it matches.
+// Empty match fields match anything.
+type faultRule struct {
+	ID string `json:"id"`
+
+	// Matching
+	ResourceType  string `json:"resourceType,omitempty"`  // e.g. nodePools or Microsoft.Network/virtualNetworks
+	NamePattern   string `json:"namePattern,omitempty"`   // glob on the resource name
+	Method        string `json:"method,omitempty"`        // HTTP method
+	OperationType string `json:"operationType,omitempty"` // Create, Update, Delete, RequestAdminCredential
+	Attempt       int    `json:"attempt,omitempty"`       // only the Nth match triggers; 0 triggers on every match
+
+	// Action
+	Action     string `json:"action"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Code       string `json:"code,omitempty"`
+	Message    string `json:"message,omitempty"`
+	RetryAfter int    `json:"retryAfter,omitempty"` // seconds, sent with respond
+
+	Matches   int `json:"matches"`
+	Triggered int `json:"triggered"`
+
+	released chan struct{} // closed when the rule is removed, ends hangs
+}
+
+// faultInjector holds the fault rules registered through /admin/faults.
+// A nil injector injects nothing.
+type faultInjector struct {
+	mu     sync.Mutex
+	rules  []*faultRule
+	nextID int
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{}
+}
+
+// operationMethods are the HTTP methods that start each operation type
+var operationMethods = map[string][]string{
+	"Create":                 {"PUT"},
+	"Update":                 {"PUT", "PATCH"},
+	"Delete":                 {"DELETE"},
+	"RequestAdminCredential": {"POST"},
+}
+
+// resourceTypeAndName returns the ARM type (e.g.
+// Microsoft.RedHatOpenShift/hcpOpenShiftClusters/nodePools) and name of the
+// resource a path addresses. A trailing collection or action segment is
+// treated as a type with an empty name.
+func resourceTypeAndName(resourcePath string) (string, string) {
+	segments := strings.Split(strings.Trim(resourcePath, "/"), "/")
+	providers := -1
+	for i, segment := range segments {
+		if strings.EqualFold(segment, "providers") {
+			providers = i
+		}
+	}
+	if providers < 0 || providers+1 >= len(segments) {
+		// subscription or resource group level
+		if len(segments) >= 4 && strings.EqualFold(segments[2], "resourceGroups") {
+			return "Microsoft.Resources/resourceGroups", segments[3]
+		}
+		return "", ""
+	}
+
+	armType := segments[providers+1]
+	name := ""
+	rest := segments[providers+2:]
+	for i := 0; i < len(rest); i += 2 {
+		armType += "/" + rest[i]
+		name = ""
+		if i+1 < len(rest) {
+			name = rest[i+1]
+		}
+	}
+	return armType, name
+}
+
+// hasMethod reports whether methods contains method, ignoring case
+func hasMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule applies to a resource. method is empty
+// for operations and operationType is empty for requests, which match an
+// operation type by the methods that start it.
+func (rule *faultRule) matches(resourceID, method, operationType string) bool {
+	armType, name := resourceTypeAndName(resourceID)
+	if rule.ResourceType != "" && !strings.EqualFold(rule.ResourceType, armType) &&
+		!strings.EqualFold(rule.ResourceType, armType[strings.LastIndex(armType, "/")+1:]) {
+		return false
+	}
+	if rule.NamePattern != "" {
+		if ok, _ := path.Match(strings.ToLower(rule.NamePattern), strings.ToLower(name)); !ok {
+			return false
+		}
+	}
+	if rule.OperationType != "" {
+		if operationType != "" && !strings.EqualFold(rule.OperationType, operationType) {
+			return false
+		}
+		if operationType == "" && !hasMethod(operationMethods[rule.OperationType], method) {
+			return false
+		}
+	}
+	if rule.Method != "" {
+		methods := []string{method}
+		if method == "" {
+			methods = operationMethods[operationType]
+		}
+		if !hasMethod(methods, rule.Method) {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns the first rule of the given actions that matches and
+// triggers on this attempt
+func (f *faultInjector) match(actions []string, resourceID, method, operationType string) *faultRule {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rule := range f.rules {
+		isAction := false
+		for _, action := range actions {
+			isAction = isAction || rule.Action == action
+		}
+		if !isAction || !rule.matches(resourceID, method, operationType) {
+			continue
+		}
+		rule.Matches++
+		if rule.Attempt == 0 || rule.Matches == rule.Attempt {
+			rule.Triggered++
+			return rule
+		}
+	}
+	return nil
+}
+
+// respond answers a request for which a respond rule triggers and reports
+// whether it did
+func (f *faultInjector) respond(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == "GET" && isOperationPollRequest(r.URL.Path) {
+		return false
+	}
+	rule := f.match([]string{faultRespond}, r.URL.Path, r.Method, "")
+	if rule == nil {
+		return false
+	}
+	log.Printf("  -> Fault rule %s: responding %d %s", rule.ID, rule.StatusCode, rule.Code)
+	if rule.RetryAfter > 0 {
+		w.Header().Set("Retry-After", fmt.Sprint(rule.RetryAfter))
+	}
+	writeCloudError(w, rule.StatusCode, &CloudErrorBody{Code: rule.Code, Message: rule.Message})
+	return true
+}
+
+// operationFault returns the fail or hang rule that triggers for an
+// operation, if any
+func (f *faultInjector) operationFault(op *AsyncOperation) *faultRule {
+	return f.match([]string{faultFail, faultHang}, op.ResourceID, "", op.OperationType)
+}
+
+// add validates a rule, fills in defaults and registers it
+func (f *faultInjector) add(rule *faultRule) error {
+	switch rule.Action {
+	case faultFail:
+		if rule.Code == "" {
+			return fmt.Errorf("action %q needs an ARM error code", rule.Action)
+		}
+	case faultHang:
+	case faultRespond:
+		if rule.StatusCode < 400 || rule.StatusCode > 599 {
+			return fmt.Errorf("action %q needs a 4xx or 5xx statusCode", rule.Action)
+		}
+		if rule.Code == "" {
+			rule.Code = map[int]string{
+				http.StatusTooManyRequests:     "TooManyRequests",
+				http.StatusConflict:            "Conflict",
+				http.StatusInternalServerError: "InternalServerError",
+			}[rule.StatusCode]
+		}
+		if rule.Code == "" {
+			rule.Code = strings.ReplaceAll(http.StatusText(rule.StatusCode), " ", "")
+		}
+	default:
+		return fmt.Errorf("unknown action %q, want %q, %q or %q", rule.Action, faultFail, faultHang, faultRespond)
+	}
+	if _, ok := operationMethods[rule.OperationType]; rule.OperationType != "" && !ok {
+		return fmt.Errorf("unknown operationType %q", rule.OperationType)
+	}
+	if rule.NamePattern != "" {
+		if _, err := path.Match(rule.NamePattern, ""); err != nil {
+			return fmt.Errorf("namePattern: %w", err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	rule.ID = fmt.Sprint(f.nextID)
+	if rule.Message == "" {
+		rule.Message = fmt.Sprintf("Injected fault from rule %s.", rule.ID)
+	}
+	rule.Matches, rule.Triggered = 0, 0
+	rule.released = make(chan struct{})
+	f.rules = append(f.rules, rule)
+	return nil
+}
+
+// remove unregisters a rule, or every rule if id is empty, releasing the
+// operations they hang. It returns the number of removed rules.
+func (f *faultInjector) remove(id string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := f.rules[:0]
+	removed := 0
+	for _, rule := range f.rules {
+		if id == "" || rule.ID == id {
+			close(rule.released)
+			removed++
+		} else {
+			kept = append(kept, rule)
+		}
+	}
+	f.rules = kept
+	return removed
+}
+
+// handleFaults serves the fault rules API:
+//
+//	GET    /admin/faults       list the rules and how often they triggered
+//	POST   /admin/faults       register a rule
+//	DELETE /admin/faults       remove every rule
+//	DELETE /admin/faults/{id}  remove one rule
+func (f *faultInjector) handleFaults(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/faults"), "/")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == "GET" && id == "":
+		f.mu.Lock()
+		data, _ := json.Marshal(map[string]interface{}{"value": append([]*faultRule{}, f.rules...)})
+		f.mu.Unlock()
+		w.Write(data)
+	case r.Method == "POST" && id == "":
+		var rule faultRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			writeCloudError(w, http.StatusBadRequest, invalidRequestContent(err))
+			return
+		}
+		if err := f.add(&rule); err != nil {
+			writeCloudError(w, http.StatusBadRequest, &CloudErrorBody{Code: "InvalidFaultRule", Message: err.Error()})
+			return
+		}
+		log.Printf("Registered fault rule %s: %s %s", rule.ID, rule.Action, rule.Code)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&rule)
+	case r.Method == "DELETE":
+		if f.remove(id) == 0 && id != "" {
+			writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+				Code:    "NotFound",
+				Message: fmt.Sprintf("Fault rule '%s' was not found.", id),
+			})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+	}
+}
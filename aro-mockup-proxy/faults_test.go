@@ -0,0 +1,140 @@
+package main
+
+import "testing"
+
+func TestFaultRuleMatches(t *testing.T) {
+	nodePoolID := testClusterID + "/nodePools/np1"
+
+	tests := []struct {
+		name          string
+		rule          faultRule
+		resourceID    string
+		method        string
+		operationType string
+		want          bool
+	}{
+		{name: "empty rule", rule: faultRule{}, resourceID: testClusterID, method: "PUT", want: true},
+		{
+			name: "full resource type", rule: faultRule{ResourceType: "Microsoft.RedHatOpenShift/hcpOpenShiftClusters/nodePools"},
+			resourceID: nodePoolID, method: "PUT", want: true,
+		},
+		{
+			name: "short resource type", rule: faultRule{ResourceType: "NODEPOOLS"},
+			resourceID: nodePoolID, method: "PUT", want: true,
+		},
+		{
+			name: "other resource type", rule: faultRule{ResourceType: "nodePools"},
+			resourceID: testClusterID, method: "PUT", want: false,
+		},
+		{
+			name: "resource group", rule: faultRule{ResourceType: "resourceGroups", NamePattern: "rg1"},
+			resourceID: "/subscriptions/s1/resourceGroups/rg1", method: "DELETE", want: true,
+		},
+		{
+			name: "name pattern", rule: faultRule{NamePattern: "NP*"},
+			resourceID: nodePoolID, method: "PUT", want: true,
+		},
+		{
+			name: "name pattern mismatch", rule: faultRule{NamePattern: "np2"},
+			resourceID: nodePoolID, method: "PUT", want: false,
+		},
+		{
+			name: "request method", rule: faultRule{Method: "delete"},
+			resourceID: testClusterID, method: "DELETE", want: true,
+		},
+		{
+			name: "request method mismatch", rule: faultRule{Method: "DELETE"},
+			resourceID: testClusterID, method: "PUT", want: false,
+		},
+		{
+			name: "operation type of a request", rule: faultRule{OperationType: "Delete"},
+			resourceID: testClusterID, method: "DELETE", want: true,
+		},
+		{
+			name: "operation type of another request", rule: faultRule{OperationType: "Delete"},
+			resourceID: testClusterID, method: "PUT", want: false,
+		},
+		{
+			name: "update by PATCH", rule: faultRule{OperationType: "Update"},
+			resourceID: testClusterID, method: "PATCH", want: true,
+		},
+		{
+			name: "operation type", rule: faultRule{OperationType: "create"},
+			resourceID: testClusterID, operationType: "Create", want: true,
+		},
+		{
+			name: "operation type mismatch", rule: faultRule{OperationType: "Create"},
+			resourceID: testClusterID, operationType: "Update", want: false,
+		},
+		{
+			name: "method of an operation", rule: faultRule{Method: "PATCH"},
+			resourceID: testClusterID, operationType: "Update", want: true,
+		},
+		{
+			name: "method that does not start the operation", rule: faultRule{Method: "DELETE"},
+			resourceID: testClusterID, operationType: "Create", want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches(tt.resourceID, tt.method, tt.operationType); got != tt.want {
+				t.Errorf("matches(%s, %q, %q) = %v, want %v", tt.resourceID, tt.method, tt.operationType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFaultInjectorAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     faultRule
+		wantErr  bool
+		wantCode string
+	}{
+		{name: "fail", rule: faultRule{Action: faultFail, Code: "InternalServerError"}, wantCode: "InternalServerError"},
+		{name: "fail without code", rule: faultRule{Action: faultFail}, wantErr: true},
+		{name: "hang", rule: faultRule{Action: faultHang}},
+		{name: "respond with default code", rule: faultRule{Action: faultRespond, StatusCode: 429}, wantCode: "TooManyRequests"},
+		{name: "respond with status text code", rule: faultRule{Action: faultRespond, StatusCode: 503}, wantCode: "ServiceUnavailable"},
+		{name: "respond without error status", rule: faultRule{Action: faultRespond, StatusCode: 200}, wantErr: true},
+		{name: "unknown action", rule: faultRule{Action: "explode"}, wantErr: true},
+		{name: "unknown operation type", rule: faultRule{Action: faultHang, OperationType: "Upgrade"}, wantErr: true},
+		{name: "bad name pattern", rule: faultRule{Action: faultHang, NamePattern: "["}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFaultInjector()
+			err := f.add(&tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("add error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(f.rules) != 0 {
+					t.Errorf("invalid rule registered")
+				}
+				return
+			}
+			if tt.rule.Code != tt.wantCode || tt.rule.ID == "" || tt.rule.Message == "" {
+				t.Errorf("registered %+v, want code %q with an ID and message", tt.rule, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestFaultInjectorAttempt(t *testing.T) {
+	f := newFaultInjector()
+	if err := f.add(&faultRule{Action: faultFail, Code: "Conflict", Attempt: 2}); err != nil {
+		t.Fatal(err)
+	}
+	op := &AsyncOperation{ResourceID: testClusterID, OperationType: "Create"}
+	for attempt, want := range []bool{false, true, false} {
+		if got := f.operationFault(op) != nil; got != want {
+			t.Errorf("attempt %d triggered %v, want %v", attempt+1, got, want)
+		}
+	}
+	if removed := f.remove(""); removed != 1 {
+		t.Errorf("removed %d rules, want 1", removed)
+	}
+}
@@ -10,8 +10,10 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -43,15 +45,24 @@ type Resource struct {
 	ProvisioningState string
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
+	ETag              string // changes on every write, see etagSchema
 }
 
 // AROHCPMockProxyEnhanced with async operations and configuration
 type AROHCPMockProxyEnhanced struct {
-	db         *sql.DB
-	azureProxy *httputil.ReverseProxy
-	devProxy   *httputil.ReverseProxy // optional: proxy hcpOpenShiftCluster* to dev environment
-	asyncOps   *AsyncOperationManager
-	config     *Config
+	db            *sql.DB
+	azureProxy    *httputil.ReverseProxy
+	devProxy      *httputil.ReverseProxy // optional: proxy hcpOpenShiftCluster* to dev environment
+	asyncOps      *AsyncOperationManager
+	metrics       *Metrics // nil unless ENABLE_METRICS is set
+	versions      *versionCatalog
+	credentialCA  *credentialAuthority // signs requestAdminCredential client certificates
+	controlPlanes *controlPlaneManager // nil unless ENABLE_CONTROL_PLANES is set
+	cassettes     *cassetteStore       // nil unless AZURE_CASSETTE_MODE is set
+	faults        *faultInjector
+	clock         *virtualClock // shared with asyncOps
+	admin         http.Handler
+	config        *Config
 }
 
 func NewAROHCPMockProxyEnhanced(config *Config) (*AROHCPMockProxyEnhanced, error) {
@@ -78,9 +89,53 @@ func NewAROHCPMockProxyEnhanced(config *Config) (*AROHCPMockProxyEnhanced, error
 		originalDirector(req)
 		req.Host = azureURL.Host
 	}
+	azureProxy.ErrorHandler = proxyErrorHandler
+
+	versions, err := loadVersionCatalog(config.VersionCatalogPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load version catalog: %w", err)
+	}
+
+	scenarios, err := loadScenarios(config.ScenarioDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load scenarios: %w", err)
+	}
+
+	credentialCA, err := loadCredentialAuthority(config.AdminCACertFile, config.AdminCAKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load admin credential CA: %w", err)
+	}
+
+	cassettes, err := newCassetteStore(config.CassetteMode, config.CassetteDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Azure cassettes: %w", err)
+	}
+
+	var controlPlanes *controlPlaneManager
+	if config.EnableControlPlanes {
+		if controlPlanes, err = newControlPlaneManager(config, credentialCA); err != nil {
+			return nil, err
+		}
+	}
 
 	// Create async operation manager
-	asyncOps := NewAsyncOperationManager(config)
+	if config.ClockSpeed < 0 {
+		return nil, fmt.Errorf("CLOCK_SPEED must not be negative")
+	}
+	if config.AsyncOperationTimeout < 0 || config.OperationRetention < 0 {
+		return nil, fmt.Errorf("ASYNC_TIMEOUT and OPERATION_RETENTION must not be negative")
+	}
+	if config.ListPageSize < 1 || config.ListPageSize > maxPageSize {
+		return nil, fmt.Errorf("LIST_PAGE_SIZE must be between 1 and %d", maxPageSize)
+	}
+	asyncOps := NewAsyncOperationManager(config, db)
+
+	var metrics *Metrics
+	if config.EnableMetrics {
+		metrics = NewMetrics(db)
+		metrics.SetOperationSettings(config.AsyncOperationTimeout, config.OperationRetention)
+		asyncOps.metrics = metrics
+	}
 
 	// Create optional dev environment proxy
 	var devProxy *httputil.ReverseProxy
@@ -128,15 +183,35 @@ func NewAROHCPMockProxyEnhanced(config *Config) (*AROHCPMockProxyEnhanced, error
 		devProxy.Transport = &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		}
-	}
-
-	return &AROHCPMockProxyEnhanced{
-		db:         db,
-		azureProxy: azureProxy,
-		devProxy:   devProxy,
-		asyncOps:   asyncOps,
-		config:     config,
-	}, nil
+		devProxy.ErrorHandler = proxyErrorHandler
+	}
+
+	proxy := &AROHCPMockProxyEnhanced{
+		db:            db,
+		azureProxy:    azureProxy,
+		devProxy:      devProxy,
+		asyncOps:      asyncOps,
+		metrics:       metrics,
+		versions:      versions,
+		credentialCA:  credentialCA,
+		controlPlanes: controlPlanes,
+		cassettes:     cassettes,
+		faults:        newFaultInjector(),
+		clock:         asyncOps.clock,
+		config:        config,
+	}
+
+	// Role assignments only live in the mock store in offline mode; online
+	// they are created in Azure where the proxy cannot see them.
+	if config.OfflineMode {
+		asyncOps.inflightCheck = proxy.checkClusterRoleAssignments
+	}
+	asyncOps.finalize = proxy.finalizeOperation
+	asyncOps.faults = proxy.faults
+	asyncOps.scenarios = scenarios
+	proxy.admin = proxy.adminHandler()
+
+	return proxy, nil
 }
 
 func (p *AROHCPMockProxyEnhanced) baseURL(r *http.Request) string {
@@ -166,12 +241,34 @@ func (sr *statusRecorder) WriteHeader(code int) {
 }
 
 func (p *AROHCPMockProxyEnhanced) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.metrics != nil && r.URL.Path == "/metrics" {
+		p.metrics.Handler().ServeHTTP(w, r)
+		return
+	}
+
+	// The admin API is only served on its own listener
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		message := "The admin API is disabled."
+		if p.config.AdminPort != "" {
+			message = fmt.Sprintf("The admin API is served on %s.", p.config.AdminPort)
+		}
+		writeCloudError(w, http.StatusNotFound, &CloudErrorBody{Code: "NotFound", Message: message})
+		return
+	}
+
 	rec := &statusRecorder{ResponseWriter: w, status: 200}
 	log.Printf("[%s] %s (Host: %s)", r.Method, r.URL.Path, r.Host)
 
+	start := time.Now()
+	route := routeAzure
+	defer func() {
+		p.metrics.ObserveRequest(route, r.Method, rec.status, time.Since(start))
+	}()
+
 	// Handle async operation status requests
 	if strings.Contains(r.URL.Path, "/operations/") && !strings.Contains(r.URL.Path, "/providers/") {
 		log.Println("  -> Routing to Async Operation Status")
+		route = routeOperationStatus
 		p.asyncOps.ServeHTTP(rec, r)
 		log.Printf("  <- %d", rec.status)
 		return
@@ -183,19 +280,41 @@ func (p *AROHCPMockProxyEnhanced) ServeHTTP(w http.ResponseWriter, r *http.Reque
 		// to the real ARO HCP frontend (e.g. via oc port-forward)
 		if p.devProxy != nil && isHcpClusterRequest(r.URL.Path) {
 			log.Printf("  -> Routing to Dev ARO-HCP frontend (%s)", p.config.DevEndpoint)
+			route = routeDevProxy
 			p.devProxy.ServeHTTP(rec, r)
 			log.Printf("  <- %d", rec.status)
 			return
 		}
 		log.Println("  -> Routing to ARO-HCP Mock (SQLite)")
-		p.handleAROHCP(rec, r)
+		route = routeMock
+		if isOperationPollRequest(r.URL.Path) {
+			route = routeOperationStatus
+		}
+		if !p.faults.respond(rec, r) {
+			p.handleAROHCP(rec, r)
+		}
+		log.Printf("  <- %d", rec.status)
+		return
+	}
+
+	// In offline mode serve everything else from the local store as well
+	if p.config.OfflineMode {
+		route = routeMock
+		if !p.faults.respond(rec, r) {
+			p.handleOffline(rec, r)
+		}
 		log.Printf("  <- %d", rec.status)
 		return
 	}
 
-	// Forward to real Azure
-	log.Println("  -> Routing to Azure ARM")
-	p.azureProxy.ServeHTTP(rec, r)
+	// Forward to real Azure, recording or replaying the traffic if
+	// cassettes are enabled
+	if p.config.CassetteMode == cassetteReplay {
+		route = routeReplay
+	} else {
+		log.Println("  -> Routing to Azure ARM")
+	}
+	p.cassettes.ServeHTTP(rec, r, p.azureProxy, p.baseURL(r))
 	log.Printf("  <- %d", rec.status)
 }
 
@@ -214,11 +333,11 @@ func isHcpClusterRequest(path string) bool {
 
 func (p *AROHCPMockProxyEnhanced) handleResourceGroup(w http.ResponseWriter, r *http.Request) {
 	// Parse path to extract subscription and resource group
-	re := regexp.MustCompile(`/subscriptions/([^/]+)/resourceGroups/([^/?]+)`)
+	re := regexp.MustCompile(`(?i)/subscriptions/([^/]+)/resourceGroups/([^/?]+)`)
 	matches := re.FindStringSubmatch(r.URL.Path)
 
 	if len(matches) < 3 {
-		http.Error(w, "Invalid ResourceGroup path", http.StatusBadRequest)
+		writeCloudError(w, http.StatusBadRequest, invalidResourceID(r.URL.Path))
 		return
 	}
 
@@ -231,7 +350,7 @@ func (p *AROHCPMockProxyEnhanced) handleResourceGroup(w http.ResponseWriter, r *
 		// Create ResourceGroup
 		var body map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			writeCloudError(w, http.StatusBadRequest, invalidRequestContent(err))
 			return
 		}
 
@@ -255,7 +374,7 @@ func (p *AROHCPMockProxyEnhanced) handleResourceGroup(w http.ResponseWriter, r *
 
 		if err != nil {
 			log.Printf("Database error creating ResourceGroup: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
 			return
 		}
 
@@ -286,7 +405,7 @@ func (p *AROHCPMockProxyEnhanced) handleResourceGroup(w http.ResponseWriter, r *
 		`, resourceID).Scan(&r.ID, &r.SubscriptionID, &r.ResourceGroup, &r.Name, &r.Tags, &r.Location, &r.ProvisioningState)
 
 		if err != nil {
-			http.Error(w, "ResourceGroup not found", http.StatusNotFound)
+			writeCloudError(w, http.StatusNotFound, resourceGroupNotFound(rgName))
 			return
 		}
 
@@ -311,39 +430,86 @@ func (p *AROHCPMockProxyEnhanced) handleResourceGroup(w http.ResponseWriter, r *
 		json.NewEncoder(w).Encode(response)
 
 	case "DELETE":
-		// Delete ResourceGroup
-		result, err := p.db.Exec("DELETE FROM resources WHERE id = ? AND resource_type = 'ResourceGroup'", resourceID)
+		// Delete the ResourceGroup with everything in it
+		deleted, err := p.deleteResourceGroup(subscriptionID, rgName, resourceID)
 		if err != nil {
-			http.Error(w, "Delete failed", http.StatusInternalServerError)
+			log.Printf("Database error deleting %s: %v", resourceID, err)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
 			return
 		}
-
-		rows, _ := result.RowsAffected()
-		if rows == 0 {
-			http.Error(w, "ResourceGroup not found", http.StatusNotFound)
+		if deleted == nil {
+			writeCloudError(w, http.StatusNotFound, resourceGroupNotFound(rgName))
 			return
 		}
+		for _, id := range deleted {
+			p.resourceDeleted(id)
+		}
+		log.Printf("Deleted %s with %d resource(s)", resourceID, len(deleted))
 
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
 	}
 }
 
+// deleteResourceGroup removes a resource group and the resources in it in
+// one transaction. It returns the IDs of the removed resources, nil when the
+// resource group does not exist.
+func (p *AROHCPMockProxyEnhanced) deleteResourceGroup(subscriptionID, rgName, resourceID string) ([]string, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM resources WHERE id = ? AND resource_type = 'ResourceGroup'", resourceID)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(`SELECT id FROM resources
+		WHERE subscription_id = ? COLLATE NOCASE AND resource_group = ? COLLATE NOCASE`, subscriptionID, rgName)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deleted = append(deleted, id)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(`DELETE FROM resources
+		WHERE subscription_id = ? COLLATE NOCASE AND resource_group = ? COLLATE NOCASE`, subscriptionID, rgName); err != nil {
+		return nil, err
+	}
+	return deleted, tx.Commit()
+}
+
 func (p *AROHCPMockProxyEnhanced) handleKeyVault(w http.ResponseWriter, r *http.Request) {
 	// Handle deletedVaults checks (always return 404 - vault not in soft delete)
 	if strings.Contains(r.URL.Path, "/deletedVaults/") {
-		w.WriteHeader(http.StatusNotFound)
+		writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+			Code:    "DeletedVaultNotFound",
+			Message: fmt.Sprintf("Deleted vault '%s' not found.", r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]),
+		})
 		return
 	}
 
 	// Parse path to extract subscription, resource group, and vault name
-	re := regexp.MustCompile(`/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.KeyVault/vaults/([^/?]+)`)
+	re := regexp.MustCompile(`(?i)/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.KeyVault/vaults/([^/?]+)`)
 	matches := re.FindStringSubmatch(r.URL.Path)
 
 	if len(matches) < 4 {
-		http.Error(w, "Invalid KeyVault path", http.StatusBadRequest)
+		writeCloudError(w, http.StatusBadRequest, invalidResourceID(r.URL.Path))
 		return
 	}
 
@@ -357,7 +523,7 @@ func (p *AROHCPMockProxyEnhanced) handleKeyVault(w http.ResponseWriter, r *http.
 		// Create KeyVault
 		var body map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			writeCloudError(w, http.StatusBadRequest, invalidRequestContent(err))
 			return
 		}
 
@@ -383,7 +549,7 @@ func (p *AROHCPMockProxyEnhanced) handleKeyVault(w http.ResponseWriter, r *http.
 
 		if err != nil {
 			log.Printf("Database error creating KeyVault: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
 			return
 		}
 
@@ -422,7 +588,7 @@ func (p *AROHCPMockProxyEnhanced) handleKeyVault(w http.ResponseWriter, r *http.
 		`, resourceID).Scan(&r.ID, &r.SubscriptionID, &r.ResourceGroup, &r.Name, &r.Properties, &r.Tags, &r.Location, &r.ProvisioningState)
 
 		if err != nil {
-			http.Error(w, "KeyVault not found", http.StatusNotFound)
+			writeCloudError(w, http.StatusNotFound, resourceNotFound("Microsoft.KeyVault/vaults/"+vaultName, rgName))
 			return
 		}
 
@@ -459,20 +625,21 @@ func (p *AROHCPMockProxyEnhanced) handleKeyVault(w http.ResponseWriter, r *http.
 		// Delete KeyVault
 		result, err := p.db.Exec("DELETE FROM resources WHERE id = ? AND resource_type = 'Vault'", resourceID)
 		if err != nil {
-			http.Error(w, "Delete failed", http.StatusInternalServerError)
+			log.Printf("Database error deleting %s: %v", resourceID, err)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
 			return
 		}
 
 		rows, _ := result.RowsAffected()
 		if rows == 0 {
-			http.Error(w, "KeyVault not found", http.StatusNotFound)
+			writeCloudError(w, http.StatusNotFound, resourceNotFound("Microsoft.KeyVault/vaults/"+vaultName, rgName))
 			return
 		}
 
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
 	}
 }
 
@@ -480,7 +647,7 @@ func (p *AROHCPMockProxyEnhanced) handleAROHCP(w http.ResponseWriter, r *http.Re
 	// Parse the request path to extract resource info
 	parsed := p.parseARMPath(r.URL.Path)
 	if parsed == nil {
-		http.Error(w, "Invalid ARM path", http.StatusBadRequest)
+		writeCloudError(w, http.StatusBadRequest, invalidResourceID(r.URL.Path))
 		return
 	}
 
@@ -508,7 +675,7 @@ func (p *AROHCPMockProxyEnhanced) handleAROHCP(w http.ResponseWriter, r *http.Re
 		if isAction {
 			// Actions can be GET requests too (e.g., polling the Location URL)
 			p.handleAction(w, r, parsed)
-		} else if parsed.ResourceName == "" && parsed.SubResourceName == "" {
+		} else if parsed.ResourceName == "" || (parsed.SubResource != "" && parsed.SubResourceName == "") {
 			p.handleList(w, r, parsed)
 		} else {
 			p.handleGet(w, r, parsed)
@@ -520,7 +687,7 @@ func (p *AROHCPMockProxyEnhanced) handleAROHCP(w http.ResponseWriter, r *http.Re
 	case "POST":
 		p.handleAction(w, r, parsed)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
 	}
 }
 
@@ -570,97 +737,15 @@ func (p *AROHCPMockProxyEnhanced) handleLocationBasedResource(w http.ResponseWri
 		p.handleHcpOpenShiftVersions(w, r, parsed)
 	case "hcpOperatorIdentityRoleSets":
 		p.handleHcpOperatorIdentityRoleSets(w, r, parsed)
+	case operationStatusesType:
+		p.handleOperationStatus(w, r, parsed)
+	case operationResultsType:
+		p.handleOperationResult(w, r, parsed)
 	default:
-		http.Error(w, "Unknown location-based resource type", http.StatusNotFound)
-	}
-}
-
-func (p *AROHCPMockProxyEnhanced) handleHcpOpenShiftVersions(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
-	// Mock data for available OpenShift versions
-	versions := []map[string]interface{}{
-		{
-			"id":   fmt.Sprintf("/subscriptions/%s/providers/Microsoft.RedHatOpenShift/locations/%s/hcpOpenShiftVersions/4.14.0", parsed.SubscriptionID, parsed.Location),
-			"name": "4.14.0",
-			"type": "Microsoft.RedHatOpenShift/hcpOpenShiftVersions",
-			"properties": map[string]interface{}{
-				"version":      "4.14.0",
-				"channelGroup": "stable",
-			},
-		},
-		{
-			"id":   fmt.Sprintf("/subscriptions/%s/providers/Microsoft.RedHatOpenShift/locations/%s/hcpOpenShiftVersions/4.15.0", parsed.SubscriptionID, parsed.Location),
-			"name": "4.15.0",
-			"type": "Microsoft.RedHatOpenShift/hcpOpenShiftVersions",
-			"properties": map[string]interface{}{
-				"version":      "4.15.0",
-				"channelGroup": "stable",
-			},
-		},
-	}
-
-	if r.Method == "GET" {
-		if parsed.ResourceName != "" {
-			// Get specific version
-			for _, v := range versions {
-				if v["name"] == parsed.ResourceName {
-					w.Header().Set("Content-Type", "application/json")
-					json.NewEncoder(w).Encode(v)
-					return
-				}
-			}
-			http.Error(w, "Version not found", http.StatusNotFound)
-		} else {
-			// List versions
-			response := map[string]interface{}{
-				"value": versions,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-		}
-	} else {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
-
-func (p *AROHCPMockProxyEnhanced) handleHcpOperatorIdentityRoleSets(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
-	// Mock data for operator identity role sets
-	roleSets := []map[string]interface{}{
-		{
-			"id":   fmt.Sprintf("/subscriptions/%s/providers/Microsoft.RedHatOpenShift/locations/%s/hcpOperatorIdentityRoleSets/4.14", parsed.SubscriptionID, parsed.Location),
-			"name": "4.14",
-			"type": "Microsoft.RedHatOpenShift/hcpOperatorIdentityRoleSets",
-			"properties": map[string]interface{}{
-				"version": "4.14",
-				"roles": []string{
-					"Contributor",
-					"Network Contributor",
-					"Storage Account Contributor",
-				},
-			},
-		},
-	}
-
-	if r.Method == "GET" {
-		if parsed.ResourceName != "" {
-			// Get specific role set
-			for _, rs := range roleSets {
-				if rs["name"] == parsed.ResourceName {
-					w.Header().Set("Content-Type", "application/json")
-					json.NewEncoder(w).Encode(rs)
-					return
-				}
-			}
-			http.Error(w, "Role set not found", http.StatusNotFound)
-		} else {
-			// List role sets
-			response := map[string]interface{}{
-				"value": roleSets,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-		}
-	} else {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+			Code:    "InvalidResourceType",
+			Message: fmt.Sprintf("The resource type '%s' could not be found in the namespace 'Microsoft.RedHatOpenShift'.", parsed.ResourceType),
+		})
 	}
 }
 
@@ -668,7 +753,7 @@ func (p *AROHCPMockProxyEnhanced) handleCreateEnhanced(w http.ResponseWriter, r
 	// Read request body
 	var body map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeCloudError(w, http.StatusBadRequest, invalidRequestContent(err))
 		return
 	}
 
@@ -687,6 +772,66 @@ func (p *AROHCPMockProxyEnhanced) handleCreateEnhanced(w http.ResponseWriter, r
 		resourceType = parsed.ResourceType
 	}
 
+	// Child resources need a parent cluster that is not going away
+	if parsed.SubResource != "" {
+		if verr, status := p.checkParentCluster(parsed); verr != nil {
+			log.Printf("Rejected %s: %s", resourceID, verr.Message)
+			writeCloudError(w, status, verr)
+			return
+		}
+	}
+
+	// Check if resource already exists and is fully provisioned
+	existingResource, _ := p.getResource(resourceID)
+	isNewResource := existingResource == nil
+	needsProvisioning := isNewResource || (existingResource != nil && existingResource.ProvisioningState != "Succeeded")
+
+	ifMatch, perr := checkPreconditions(r, existingResource)
+	if perr != nil {
+		writeCloudError(w, http.StatusPreconditionFailed, perr)
+		return
+	}
+
+	// A PUT while another operation runs would restart provisioning; the RP
+	// refuses it the same way as an update
+	if existingResource != nil && (existingResource.ProvisioningState == "Deleting" || p.asyncOps.HasActiveOperation(resourceID)) {
+		writeCloudError(w, http.StatusConflict, provisioningStateConflict(resourceID, existingResource.ProvisioningState))
+		return
+	}
+
+	// Validate the body before read-only fields are injected
+	if p.config.EnableValidation && isValidatedResourceType(resourceType) {
+		if verr := validateResourceBody(resourceType, r.URL.Query().Get("api-version"), body, existingResource, false); verr != nil {
+			log.Printf("Validation failed for %s: %s", resourceID, verr.Message)
+			writeCloudError(w, http.StatusBadRequest, verr)
+			return
+		}
+	}
+
+	upgrading, verr := p.checkResourceVersion(resourceType, body, existingResource)
+	if verr != nil {
+		log.Printf("Version check failed for %s: %s", resourceID, verr.Message)
+		writeCloudError(w, http.StatusBadRequest, verr)
+		return
+	}
+
+	// In offline mode the referenced network and identity resources live in
+	// the mock store, so run the same existence preflight as the RP
+	if p.config.OfflineMode {
+		var verr *CloudErrorBody
+		if isNewResource {
+			verr = p.checkNetworkReferences(resourceType, body)
+		}
+		if verr == nil {
+			verr = p.checkIdentityReferences(resourceType, body)
+		}
+		if verr != nil {
+			log.Printf("Preflight failed for %s: %s", resourceID, verr.Message)
+			writeCloudError(w, http.StatusBadRequest, verr)
+			return
+		}
+	}
+
 	// Extract fields and inject read-only properties based on resource type
 	var propertiesMap map[string]interface{}
 	if props, ok := body["properties"].(map[string]interface{}); ok {
@@ -695,6 +840,17 @@ func (p *AROHCPMockProxyEnhanced) handleCreateEnhanced(w http.ResponseWriter, r
 		propertiesMap = make(map[string]interface{})
 	}
 
+	// upgradeStatus is read-only: start a new one on upgrade, otherwise
+	// keep the stored one so a re-PUT of the same spec is not a change
+	if upgrading {
+		versionID, _ := resourceVersion(body)
+		propertiesMap["upgradeStatus"] = newUpgradeStatus(existingResource, versionID, p.clock.Now())
+	} else if existingResource != nil {
+		if status, ok := existingResource.toBody()["properties"].(map[string]interface{})["upgradeStatus"]; ok {
+			propertiesMap["upgradeStatus"] = status
+		}
+	}
+
 	// Inject read-only fields for HcpOpenShiftClusters
 	if parsed.ResourceType == "hcpOpenShiftClusters" && parsed.SubResource == "" {
 		// Inject console URL (read-only)
@@ -714,7 +870,7 @@ func (p *AROHCPMockProxyEnhanced) handleCreateEnhanced(w http.ResponseWriter, r
 
 		// Inject API URL if api section exists
 		if api, ok := propertiesMap["api"].(map[string]interface{}); ok {
-			api["url"] = fmt.Sprintf("https://%s-api.mock.arodev.io:6443", parsed.ResourceName)
+			api["url"] = p.clusterAPIURL(resourceID, parsed.ResourceName)
 			propertiesMap["api"] = api
 		}
 	}
@@ -727,13 +883,19 @@ func (p *AROHCPMockProxyEnhanced) handleCreateEnhanced(w http.ResponseWriter, r
 		location = loc
 	}
 
-	// Check if resource already exists and is fully provisioned
-	existingResource, _ := p.getResource(resourceID)
-	isNewResource := existingResource == nil
-	needsProvisioning := isNewResource || (existingResource != nil && existingResource.ProvisioningState != "Succeeded")
-
-	// Determine initial provisioning state
+	// Determine initial provisioning state: resources that never finished
+	// provisioning are created again, Succeeded ones with a changed spec
+	// go through Updating
+	operationType := ""
+	if needsProvisioning {
+		operationType = "Create"
+	} else if resourceChanged(existingResource, string(properties), string(identity), string(tags), location) {
+		operationType = "Update"
+	}
 	initialState := "Creating"
+	if operationType == "Update" {
+		initialState = "Updating"
+	}
 	if !p.config.EnableAsyncOperations {
 		initialState = "Succeeded"
 	}
@@ -749,16 +911,17 @@ func (p *AROHCPMockProxyEnhanced) handleCreateEnhanced(w http.ResponseWriter, r
 
 		if err != nil {
 			log.Printf("Database error: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
 			return
 		}
 	} else {
 		// Update existing resource; reset to Creating if not yet Succeeded
 		updateState := existingResource.ProvisioningState
-		if needsProvisioning {
+		if operationType != "" {
 			updateState = initialState
 		}
-		_, err := p.db.Exec(`
+		// A concurrent write since the If-Match check changes the etag
+		result, err := p.db.Exec(`
 			UPDATE resources SET
 				properties = ?,
 				identity = ?,
@@ -766,39 +929,47 @@ func (p *AROHCPMockProxyEnhanced) handleCreateEnhanced(w http.ResponseWriter, r
 				location = ?,
 				provisioning_state = ?,
 				updated_at = CURRENT_TIMESTAMP
-			WHERE id = ?
-		`, string(properties), string(identity), string(tags), location, updateState, resourceID)
+			WHERE id = ? AND (? = '' OR etag = ?)
+		`, string(properties), string(identity), string(tags), location, updateState, resourceID, ifMatch, ifMatch)
 
 		if err != nil {
 			log.Printf("Database error: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			writeCloudError(w, http.StatusPreconditionFailed, preconditionFailed("If-Match", r.Header.Get("If-Match")))
 			return
 		}
 	}
 
-	// Start async operation for new resources or those stuck in non-Succeeded state
+	// Start async operation for new resources, those stuck in non-Succeeded
+	// state and changed ones
 	var asyncOp *AsyncOperation
-	if p.config.EnableAsyncOperations && needsProvisioning {
-		asyncOp = p.asyncOps.StartOperation(resourceID, "Create", p.db)
-		log.Printf("Started async operation: %s", asyncOp.ID)
+	if p.config.EnableAsyncOperations && operationType != "" {
+		asyncOp = p.asyncOps.StartOperation(resourceID, operationType)
+		log.Printf("Started async %s operation: %s", strings.ToLower(operationType), asyncOp.ID)
+	} else if operationType != "" {
+		p.resourceReady(resourceID)
 	}
 
 	// Return created resource
 	resource, err := p.getResource(resourceID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve created resource", http.StatusInternalServerError)
+		log.Printf("Failed to retrieve created resource %s: %v", resourceID, err)
+		writeCloudError(w, http.StatusInternalServerError, internalServerError())
 		return
 	}
 
 	response := p.buildResourceResponse(resource)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(resource.ETag))
 
 	// Add async operation headers if enabled
 	if p.config.EnableAsyncOperations && asyncOp != nil {
-		base := p.baseURL(r)
-		w.Header().Set("Azure-AsyncOperation", fmt.Sprintf("%s/operations/%s", base, asyncOp.ID))
-		w.Header().Set("Location", fmt.Sprintf("%s%s", base, resourceID))
-		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(p.config.PollingInterval.Seconds())))
+		p.setAsyncOperationHeaders(w, r, asyncOp, resourceID)
+	}
+	if asyncOp != nil && operationType == "Create" {
 		w.WriteHeader(http.StatusCreated)
 	} else {
 		w.WriteHeader(http.StatusOK)
@@ -807,6 +978,89 @@ func (p *AROHCPMockProxyEnhanced) handleCreateEnhanced(w http.ResponseWriter, r
 	json.NewEncoder(w).Encode(response)
 }
 
+func provisioningStateConflict(resourceID, state string) *CloudErrorBody {
+	return &CloudErrorBody{
+		Code:    "Conflict",
+		Message: fmt.Sprintf("Cannot update resource '%s' while its provisioning state is '%s'.", resourceID, state),
+		Target:  resourceID,
+	}
+}
+
+// setAsyncOperationHeaders points the client at the status of a started
+// long-running operation. A delete has no resource left to GET at the end,
+// so its Location is the operation result.
+func (p *AROHCPMockProxyEnhanced) setAsyncOperationHeaders(w http.ResponseWriter, r *http.Request, asyncOp *AsyncOperation, resourceID string) {
+	w.Header().Set("Azure-AsyncOperation", p.operationURL(r, asyncOp, operationStatusesType))
+	if asyncOp.OperationType == "Delete" {
+		w.Header().Set("Location", p.operationURL(r, asyncOp, operationResultsType))
+	} else {
+		w.Header().Set("Location", fmt.Sprintf("%s%s", p.baseURL(r), resourceID))
+	}
+	w.Header().Set("Retry-After", p.clock.retryAfter(p.config.PollingInterval))
+}
+
+// resourceChanged reports whether a PUT changes the stored spec of a
+// resource. encoding/json sorts map keys, so equal documents marshal to
+// equal strings.
+func resourceChanged(existing *Resource, properties, identity, tags, location string) bool {
+	return !jsonEqual(existing.Properties, properties) ||
+		!jsonEqual(existing.Identity, identity) ||
+		!jsonEqual(existing.Tags, tags) ||
+		existing.Location != location
+}
+
+func jsonEqual(a, b string) bool {
+	var va, vb interface{}
+	json.Unmarshal([]byte(a), &va)
+	json.Unmarshal([]byte(b), &vb)
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
+
+// parentResourceID returns the ID of the cluster a child resource belongs to
+func parentResourceID(parsed *ARMPath) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.RedHatOpenShift/%s/%s",
+		parsed.SubscriptionID, parsed.ResourceGroup, parsed.ResourceType, parsed.ResourceName)
+}
+
+// checkParentCluster rejects writing a node pool or external auth whose
+// cluster does not exist or is being deleted, and returns the HTTP status
+// to reject it with. Unless ENFORCE_PARENT_STATE is disabled, the cluster
+// must also have finished provisioning, as the RP requires.
+func (p *AROHCPMockProxyEnhanced) checkParentCluster(parsed *ARMPath) (*CloudErrorBody, int) {
+	parentID := parentResourceID(parsed)
+	parent, err := p.getResource(parentID)
+	if err != nil {
+		return &CloudErrorBody{
+			Code: "ParentResourceNotFound",
+			Message: fmt.Sprintf("Failed to perform 'write' on resource(s) of type '%s/%s', because the parent resource '%s' could not be found.",
+				parsed.ResourceType, parsed.SubResource, parentID),
+		}, http.StatusNotFound
+	}
+	if parent.ProvisioningState == "Deleting" ||
+		(p.config.EnforceParentState && parent.ProvisioningState != "Succeeded") {
+		return &CloudErrorBody{
+			Code: "Conflict",
+			Message: fmt.Sprintf("Cluster '%s' is in state '%s', can't create or update %s '%s'.",
+				parentID, parent.ProvisioningState, parsed.SubResource, parsed.SubResourceName),
+			Target: buildResourceID(parsed),
+		}, http.StatusConflict
+	}
+	return nil, 0
+}
+
+// hcpResourceNotFound reports a missing Microsoft.RedHatOpenShift resource
+func hcpResourceNotFound(parsed *ARMPath) *CloudErrorBody {
+	id := buildResourceID(parsed)
+	return resourceNotFound(id[strings.Index(id, "/providers/")+len("/providers/"):], parsed.ResourceGroup)
+}
+
+// clusterNotFound is hcpResourceNotFound for the cluster of an action path
+func clusterNotFound(parsed *ARMPath) *CloudErrorBody {
+	return resourceNotFound(fmt.Sprintf("Microsoft.RedHatOpenShift/%s/%s", parsed.ResourceType, parsed.ResourceName), parsed.ResourceGroup)
+}
+
 func getResourceName(parsed *ARMPath) string {
 	if parsed.SubResourceName != "" {
 		return parsed.SubResourceName
@@ -827,54 +1081,87 @@ func buildResourceID(parsed *ARMPath) string {
 func (p *AROHCPMockProxyEnhanced) handleDeleteEnhanced(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
 	resourceID := buildResourceID(parsed)
 
-	// Check if resource exists
-	_, err := p.getResource(resourceID)
+	// Deleting a resource that does not exist succeeds, as in ARM
+	existing, err := p.getResource(resourceID)
 	if err != nil {
-		http.Error(w, "Resource not found", http.StatusNotFound)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if _, perr := checkPreconditions(r, existing); perr != nil {
+		writeCloudError(w, http.StatusPreconditionFailed, perr)
+		return
+	}
+
+	// A repeated DELETE joins the delete in progress, that of the resource
+	// or of the cluster it belongs to
+	if p.config.EnableAsyncOperations {
+		if op := p.activeDelete(resourceID, parentResourceID(parsed)); op != nil {
+			log.Printf("Delete of %s already in progress: %s", resourceID, op.ID)
+			p.setAsyncOperationHeaders(w, r, op, op.ResourceID)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
 
 	// Start async operation if enabled
 	var asyncOp *AsyncOperation
 	if p.config.EnableAsyncOperations {
-		// Update state to Deleting
-		p.db.Exec("UPDATE resources SET provisioning_state = 'Deleting' WHERE id = ?", resourceID)
+		// Update state to Deleting, together with the cluster's node pools
+		// and external auths, which go away with it
+		if _, err := p.db.Exec("UPDATE resources SET provisioning_state = 'Deleting' WHERE id = ? OR id LIKE ? ESCAPE '\\'",
+			resourceID, childrenOf(resourceID)); err != nil {
+			log.Printf("Database error deleting %s: %v", resourceID, err)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
+			return
+		}
 
-		asyncOp = p.asyncOps.StartOperation(resourceID, "Delete", p.db)
+		// The rows are removed by the operation once it completes
+		asyncOp = p.asyncOps.StartOperation(resourceID, "Delete")
 		log.Printf("Started async delete operation: %s", asyncOp.ID)
-
-		// Schedule actual deletion after operation completes
-		go func() {
-			time.Sleep(p.config.ProvisioningDelay)
-			p.db.Exec("DELETE FROM resources WHERE id = ?", resourceID)
-		}()
 	} else {
 		// Immediate deletion
-		result, err := p.db.Exec("DELETE FROM resources WHERE id = ?", resourceID)
+		p.resourceDeleted(resourceID)
+		result, err := p.db.Exec("DELETE FROM resources WHERE id = ? OR id LIKE ? ESCAPE '\\'", resourceID, childrenOf(resourceID))
 		if err != nil {
-			http.Error(w, "Delete failed", http.StatusInternalServerError)
+			log.Printf("Database error deleting %s: %v", resourceID, err)
+			writeCloudError(w, http.StatusInternalServerError, internalServerError())
 			return
 		}
 
-		rows, _ := result.RowsAffected()
-		if rows == 0 {
-			http.Error(w, "Resource not found", http.StatusNotFound)
-			return
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			log.Printf("%s was already deleted", resourceID)
 		}
 	}
 
 	// Add async operation headers if enabled
 	if p.config.EnableAsyncOperations && asyncOp != nil {
-		base := p.baseURL(r)
-		w.Header().Set("Azure-AsyncOperation", fmt.Sprintf("%s/operations/%s", base, asyncOp.ID))
-		w.Header().Set("Location", fmt.Sprintf("%s%s", base, resourceID))
-		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(p.config.PollingInterval.Seconds())))
+		p.setAsyncOperationHeaders(w, r, asyncOp, resourceID)
 		w.WriteHeader(http.StatusAccepted)
 	} else {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
+// likeEscaper escapes the LIKE wildcards, which are valid in resource names
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// childrenOf returns the LIKE pattern, for use with ESCAPE '\', that matches
+// the IDs below id, e.g. the node pools and external auths of a cluster
+func childrenOf(id string) string {
+	return likeEscaper.Replace(id) + "/%"
+}
+
+// activeDelete returns the Delete operation in progress on a resource or on
+// its parent cluster, or nil
+func (p *AROHCPMockProxyEnhanced) activeDelete(resourceID, clusterID string) *AsyncOperation {
+	for _, id := range []string{resourceID, clusterID} {
+		if op := p.asyncOps.ActiveOperation(id); op != nil && op.OperationType == "Delete" {
+			return op
+		}
+	}
+	return nil
+}
+
 // Copy other methods from main.go
 func (p *AROHCPMockProxyEnhanced) parseARMPath(path string) *ARMPath {
 	// Provider-level operations: /providers/Microsoft.RedHatOpenShift/operations
@@ -939,42 +1226,57 @@ func (p *AROHCPMockProxyEnhanced) parseARMPath(path string) *ARMPath {
 }
 
 func (p *AROHCPMockProxyEnhanced) handleGet(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
-	resourceID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.RedHatOpenShift/%s/%s",
-		parsed.SubscriptionID, parsed.ResourceGroup, parsed.ResourceType, parsed.ResourceName)
+	resourceID := buildResourceID(parsed)
 
 	resource, err := p.getResource(resourceID)
 	if err != nil {
-		http.Error(w, "Resource not found", http.StatusNotFound)
+		writeCloudError(w, http.StatusNotFound, hcpResourceNotFound(parsed))
 		return
 	}
 
 	response := p.buildResourceResponse(resource)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(resource.ETag))
 	json.NewEncoder(w).Encode(response)
 }
 
 func (p *AROHCPMockProxyEnhanced) handleList(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
-	var rows *sql.Rows
-	var err error
-
-	if parsed.ResourceGroup != "" {
-		rows, err = p.db.Query(`
-			SELECT id, resource_type, subscription_id, resource_group, name,
-				properties, identity, tags, location, provisioning_state, created_at, updated_at
-			FROM resources
-			WHERE subscription_id = ? AND resource_group = ? AND resource_type = ?
-		`, parsed.SubscriptionID, parsed.ResourceGroup, parsed.ResourceType)
+	pageSize, after, perr := p.listPage(r)
+	if perr != nil {
+		writeCloudError(w, http.StatusBadRequest, perr)
+		return
+	}
+
+	var where string
+	var args []interface{}
+	if parsed.SubResource != "" {
+		// Child collection, e.g. .../hcpOpenShiftClusters/{name}/nodePools
+		where = "resource_type = ? AND id LIKE ? ESCAPE '\\'"
+		args = []interface{}{parsed.SubResource, childrenOf(parentResourceID(parsed) + "/" + parsed.SubResource)}
+	} else if parsed.ResourceGroup != "" {
+		where = "subscription_id = ? AND resource_group = ? AND resource_type = ?"
+		args = []interface{}{parsed.SubscriptionID, parsed.ResourceGroup, parsed.ResourceType}
 	} else {
-		rows, err = p.db.Query(`
-			SELECT id, resource_type, subscription_id, resource_group, name,
-				properties, identity, tags, location, provisioning_state, created_at, updated_at
-			FROM resources
-			WHERE subscription_id = ? AND resource_type = ?
-		`, parsed.SubscriptionID, parsed.ResourceType)
+		where = "subscription_id = ? AND resource_type = ?"
+		args = []interface{}{parsed.SubscriptionID, parsed.ResourceType}
+	}
+	if after != "" {
+		where += " AND id > ?"
+		args = append(args, after)
 	}
 
+	// One row more than the page tells whether there is a next page
+	rows, err := p.db.Query(`
+		SELECT id, resource_type, subscription_id, resource_group, name,
+			properties, identity, tags, location, provisioning_state, created_at, updated_at, etag
+		FROM resources
+		WHERE `+where+`
+		ORDER BY id
+		LIMIT ?
+	`, append(args, pageSize+1)...)
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Printf("Database error listing %s: %v", parsed.ResourceType, err)
+		writeCloudError(w, http.StatusInternalServerError, internalServerError())
 		return
 	}
 	defer rows.Close()
@@ -983,21 +1285,24 @@ func (p *AROHCPMockProxyEnhanced) handleList(w http.ResponseWriter, r *http.Requ
 	for rows.Next() {
 		var r Resource
 		err := rows.Scan(&r.ID, &r.ResourceType, &r.SubscriptionID, &r.ResourceGroup, &r.Name,
-			&r.Properties, &r.Identity, &r.Tags, &r.Location, &r.ProvisioningState, &r.CreatedAt, &r.UpdatedAt)
+			&r.Properties, &r.Identity, &r.Tags, &r.Location, &r.ProvisioningState, &r.CreatedAt, &r.UpdatedAt, &r.ETag)
 		if err != nil {
 			continue
 		}
 		resources = append(resources, r)
 	}
 
+	response := map[string]interface{}{}
+	if len(resources) > pageSize {
+		resources = resources[:pageSize]
+		response["nextLink"] = p.nextLink(r, resources[pageSize-1].ID)
+	}
+
 	values := make([]interface{}, 0, len(resources))
 	for _, res := range resources {
 		values = append(values, p.buildResourceResponse(&res))
 	}
-
-	response := map[string]interface{}{
-		"value": values,
-	}
+	response["value"] = values
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -1006,38 +1311,108 @@ func (p *AROHCPMockProxyEnhanced) handleList(w http.ResponseWriter, r *http.Requ
 func (p *AROHCPMockProxyEnhanced) handleUpdate(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
 	var body map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeCloudError(w, http.StatusBadRequest, invalidRequestContent(err))
 		return
 	}
 
-	resourceID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.RedHatOpenShift/%s/%s",
-		parsed.SubscriptionID, parsed.ResourceGroup, parsed.ResourceType, parsed.ResourceName)
+	resourceID := buildResourceID(parsed)
 
-	_, err := p.getResource(resourceID)
+	existingResource, err := p.getResource(resourceID)
 	if err != nil {
-		http.Error(w, "Resource not found", http.StatusNotFound)
+		writeCloudError(w, http.StatusNotFound, hcpResourceNotFound(parsed))
 		return
 	}
 
-	properties, _ := json.Marshal(body["properties"])
-	tags, _ := json.Marshal(body["tags"])
+	ifMatch, perr := checkPreconditions(r, existingResource)
+	if perr != nil {
+		writeCloudError(w, http.StatusPreconditionFailed, perr)
+		return
+	}
+
+	// Like the RP, refuse to start an update while another operation on
+	// the resource is still running
+	if existingResource.ProvisioningState == "Deleting" || p.asyncOps.HasActiveOperation(resourceID) {
+		writeCloudError(w, http.StatusConflict, provisioningStateConflict(resourceID, existingResource.ProvisioningState))
+		return
+	}
+
+	if p.config.EnableValidation && isValidatedResourceType(existingResource.ResourceType) {
+		if verr := validateResourceBody(existingResource.ResourceType, r.URL.Query().Get("api-version"), body, existingResource, true); verr != nil {
+			log.Printf("Validation failed for %s: %s", resourceID, verr.Message)
+			writeCloudError(w, http.StatusBadRequest, verr)
+			return
+		}
+	}
+
+	// PATCH is a JSON merge patch (RFC 7386) of the stored resource; tags
+	// are replaced as a whole, like ARM does
+	current := existingResource.toBody()
+	if patch, ok := body["properties"].(map[string]interface{}); ok {
+		current["properties"] = mergePatch(current["properties"], patch)
+	}
+	if patch, ok := body["identity"].(map[string]interface{}); ok {
+		current["identity"] = mergePatch(current["identity"], patch)
+	}
+	if tags, ok := body["tags"]; ok {
+		current["tags"] = tags
+	}
+
+	upgrading, verr := p.checkResourceVersion(existingResource.ResourceType, current, existingResource)
+	if verr != nil {
+		log.Printf("Version check failed for %s: %s", resourceID, verr.Message)
+		writeCloudError(w, http.StatusBadRequest, verr)
+		return
+	}
+	if upgrading {
+		versionID, _ := resourceVersion(current)
+		current["properties"].(map[string]interface{})["upgradeStatus"] = newUpgradeStatus(existingResource, versionID, p.clock.Now())
+	}
 
-	_, err = p.db.Exec(`
+	properties, _ := json.Marshal(current["properties"])
+	identity, _ := json.Marshal(current["identity"])
+	tags, _ := json.Marshal(current["tags"])
+
+	state := existingResource.ProvisioningState
+	if p.config.EnableAsyncOperations {
+		state = "Updating"
+	}
+
+	result, err := p.db.Exec(`
 		UPDATE resources
-		SET properties = COALESCE(?, properties),
-			tags = COALESCE(?, tags),
+		SET properties = ?,
+			identity = ?,
+			tags = ?,
+			provisioning_state = ?,
 			updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`, nullIfEmpty(string(properties)), nullIfEmpty(string(tags)), resourceID)
+		WHERE id = ? AND (? = '' OR etag = ?)
+	`, string(properties), string(identity), string(tags), state, resourceID, ifMatch, ifMatch)
 
 	if err != nil {
-		http.Error(w, "Update failed", http.StatusInternalServerError)
+		log.Printf("Database error updating %s: %v", resourceID, err)
+		writeCloudError(w, http.StatusInternalServerError, internalServerError())
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		writeCloudError(w, http.StatusPreconditionFailed, preconditionFailed("If-Match", r.Header.Get("If-Match")))
 		return
 	}
 
+	var asyncOp *AsyncOperation
+	if p.config.EnableAsyncOperations {
+		asyncOp = p.asyncOps.StartOperation(resourceID, "Update")
+		log.Printf("Started async update operation: %s", asyncOp.ID)
+	} else {
+		p.resourceReady(resourceID)
+	}
+
 	resource, _ := p.getResource(resourceID)
 	response := p.buildResourceResponse(resource)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(resource.ETag))
+	if asyncOp != nil {
+		p.setAsyncOperationHeaders(w, r, asyncOp, resourceID)
+		w.WriteHeader(http.StatusAccepted)
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -1048,108 +1423,89 @@ func (p *AROHCPMockProxyEnhanced) handleAction(w http.ResponseWriter, r *http.Re
 		if r.Method == "POST" {
 			p.handleRequestAdminCredential(w, r, parsed)
 			return
-		} else if r.Method == "GET" {
-			// Return result if operation completed
-			// This is called via the Location header after the async operation completes
-			p.handleGetAdminCredential(w, r, parsed)
-			return
 		}
+		// The credential is fetched from the hcpOperationResults URL of
+		// the operation, see handleOperationResult
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+		return
 	}
 
-	if strings.HasSuffix(r.URL.Path, "/revokeCredentials") {
-		w.WriteHeader(http.StatusNoContent)
+	if strings.HasSuffix(r.URL.Path, "/revokeCredentials") && r.Method == "POST" {
+		p.handleRevokeCredentials(w, r, parsed)
 		return
 	}
 
-	http.Error(w, "Action not implemented", http.StatusNotImplemented)
+	writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+		Code:    "InvalidResourceType",
+		Message: fmt.Sprintf("The action '%s' is not supported for resource type '%s'.", r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], parsed.ResourceType),
+	})
 }
 
 func (p *AROHCPMockProxyEnhanced) handleRequestAdminCredential(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
 	resourceID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.RedHatOpenShift/%s/%s",
 		parsed.SubscriptionID, parsed.ResourceGroup, parsed.ResourceType, parsed.ResourceName)
 
-	// Read kubeconfig from file (path configurable via MOCK_KUBECONFIG_PATH env)
-	kubeconfigPath := os.Getenv("MOCK_KUBECONFIG_PATH")
-	if kubeconfigPath == "" {
-		kubeconfigPath = "/data/workload-kubeconfig.yaml"
-	}
-	kubeconfigBytes, err := os.ReadFile(kubeconfigPath)
+	cluster, err := p.getResource(resourceID)
 	if err != nil {
-		log.Printf("Failed to read kubeconfig from %s: %v", kubeconfigPath, err)
-		http.Error(w, "Failed to read kubeconfig", http.StatusInternalServerError)
+		writeCloudError(w, http.StatusNotFound, clusterNotFound(parsed))
+		return
+	}
+	if cluster.ProvisioningState != "Succeeded" {
+		writeCloudError(w, http.StatusConflict, &CloudErrorBody{
+			Code:    "Conflict",
+			Message: fmt.Sprintf("Cannot request credential while cluster '%s' is in state '%s'.", resourceID, cluster.ProvisioningState),
+			Target:  resourceID,
+		})
 		return
 	}
 
-	// Create the credential response
-	expirationTime := time.Now().Add(24 * time.Hour)
-	credentialResponse := map[string]interface{}{
-		"kubeconfig":          string(kubeconfigBytes),
-		"expirationTimestamp": expirationTime.Format(time.RFC3339),
+	// Every request gets its own client certificate
+	credentialResponse, err := p.issueAdminCredential(cluster)
+	if err != nil {
+		log.Printf("Failed to issue admin credential for %s: %v", resourceID, err)
+		writeCloudError(w, http.StatusInternalServerError, internalServerError())
+		return
 	}
 
 	// Start async operation with the credential result
 	asyncOp := p.asyncOps.StartOperationWithResult(resourceID, "RequestAdminCredential", credentialResponse)
 	log.Printf("Started async operation for requestAdminCredential: %s", asyncOp.ID)
 
-	// Return 202 with async operation headers
-	base := p.baseURL(r)
-
 	// Azure LRO pattern: Azure-AsyncOperation for status polling, Location for final result
-	w.Header().Set("Azure-AsyncOperation", fmt.Sprintf("%s/operations/%s", base, asyncOp.ID))
-	w.Header().Set("Location", fmt.Sprintf("%s%s", base, r.URL.Path))
-	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(p.config.PollingInterval.Seconds())))
+	w.Header().Set("Azure-AsyncOperation", p.operationURL(r, asyncOp, operationStatusesType))
+	w.Header().Set("Location", p.operationURL(r, asyncOp, operationResultsType))
+	w.Header().Set("Retry-After", p.clock.retryAfter(p.config.PollingInterval))
 	w.WriteHeader(http.StatusAccepted)
 	// No body for 202 response per Azure LRO spec
 }
 
-func (p *AROHCPMockProxyEnhanced) handleGetAdminCredential(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
-	// This is called when polling the Location URL
-	// We need to find the completed operation and return its result
-
-	// For simplicity, we'll look for the most recent completed RequestAdminCredential operation
-	// In a real implementation, we'd store operation ID associations
-
-	resourceID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.RedHatOpenShift/%s/%s",
-		parsed.SubscriptionID, parsed.ResourceGroup, parsed.ResourceType, parsed.ResourceName)
-
-	// Find the operation for this resource
-	var completedOp *AsyncOperation
-	p.asyncOps.mu.RLock()
-	for _, op := range p.asyncOps.operations {
-		op.mu.RLock()
-		if op.ResourceID == resourceID && op.OperationType == "RequestAdminCredential" && op.Status == "Succeeded" && op.Result != nil {
-			completedOp = op
-			op.mu.RUnlock()
-			break
-		}
-		op.mu.RUnlock()
+// handleRevokeCredentials revokes every admin credential issued for the
+// cluster so far
+func (p *AROHCPMockProxyEnhanced) handleRevokeCredentials(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
+	resourceID := parentResourceID(parsed)
+	if _, err := p.getResource(resourceID); err != nil {
+		writeCloudError(w, http.StatusNotFound, clusterNotFound(parsed))
+		return
 	}
-	p.asyncOps.mu.RUnlock()
 
-	if completedOp == nil {
-		// Operation not found or not completed yet - return 404 or tell client to wait
-		http.Error(w, "Credential request not completed yet", http.StatusNotFound)
+	revoked, err := p.revokeAdminCredentials(resourceID)
+	if err != nil {
+		log.Printf("Failed to revoke admin credentials for %s: %v", resourceID, err)
+		writeCloudError(w, http.StatusInternalServerError, internalServerError())
 		return
 	}
-
-	// Return the credential response
-	completedOp.mu.RLock()
-	result := completedOp.Result
-	completedOp.mu.RUnlock()
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	log.Printf("Revoked %d admin credential(s) for %s", revoked, resourceID)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (p *AROHCPMockProxyEnhanced) getResource(id string) (*Resource, error) {
 	var r Resource
 	err := p.db.QueryRow(`
 		SELECT id, resource_type, subscription_id, resource_group, name,
-			properties, identity, tags, location, provisioning_state, created_at, updated_at
+			properties, identity, tags, location, provisioning_state, created_at, updated_at, etag
 		FROM resources WHERE id = ?
 	`, id).Scan(&r.ID, &r.ResourceType, &r.SubscriptionID, &r.ResourceGroup, &r.Name,
-		&r.Properties, &r.Identity, &r.Tags, &r.Location, &r.ProvisioningState, &r.CreatedAt, &r.UpdatedAt)
+		&r.Properties, &r.Identity, &r.Tags, &r.Location, &r.ProvisioningState, &r.CreatedAt, &r.UpdatedAt, &r.ETag)
 
 	if err != nil {
 		return nil, err
@@ -1157,6 +1513,23 @@ func (p *AROHCPMockProxyEnhanced) getResource(id string) (*Resource, error) {
 	return &r, nil
 }
 
+// toBody reassembles the stored resource into the shape of a request body
+func (r *Resource) toBody() map[string]interface{} {
+	body := map[string]interface{}{
+		"location": r.Location,
+	}
+	for key, raw := range map[string]string{"properties": r.Properties, "identity": r.Identity, "tags": r.Tags} {
+		if raw == "" {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(raw), &v); err == nil && v != nil {
+			body[key] = v
+		}
+	}
+	return body
+}
+
 func (p *AROHCPMockProxyEnhanced) buildResourceResponse(r *Resource) map[string]interface{} {
 	response := map[string]interface{}{
 		"id":       r.ID,
@@ -1172,6 +1545,7 @@ func (p *AROHCPMockProxyEnhanced) buildResourceResponse(r *Resource) map[string]
 				props = make(map[string]interface{})
 			}
 			props["provisioningState"] = r.ProvisioningState
+			p.decorateUpgradeStatus(r, props)
 			response["properties"] = props
 		}
 	}
@@ -1194,31 +1568,108 @@ func (p *AROHCPMockProxyEnhanced) buildResourceResponse(r *Resource) map[string]
 		"createdAt":      r.CreatedAt.Format(time.RFC3339),
 		"lastModifiedAt": r.UpdatedAt.Format(time.RFC3339),
 	}
+	response["etag"] = formatETag(r.ETag)
 
 	return response
 }
 
-// recoverStuckResources transitions any resources left in non-terminal
-// provisioning states (Creating, Deleting, Updating) to Succeeded on startup.
-// This handles the case where the proxy was restarted while async operations
-// were in progress — those in-memory operations are lost, so without this
-// the resources would stay stuck forever.
+// finalizeOperation brings up what backs a resource whose create or update
+// is about to succeed, and tears it down when the resource is deleted
+func (p *AROHCPMockProxyEnhanced) finalizeOperation(op *AsyncOperation) {
+	switch op.OperationType {
+	case "Create", "Update":
+		p.resourceReady(op.ResourceID)
+	case "Delete":
+		p.resourceDeleted(op.ResourceID)
+	}
+}
+
+// resourceReady starts the control plane of a cluster and creates the
+// nodes of a node pool, if those features are enabled
+func (p *AROHCPMockProxyEnhanced) resourceReady(resourceID string) {
+	if clusterIDRE.MatchString(resourceID) {
+		p.startControlPlane(resourceID)
+	}
+	if p.config.MaterializeNodes && nodePoolIDRE.MatchString(resourceID) {
+		if err := p.reconcileNodes(resourceID); err != nil {
+			log.Printf("Failed to reconcile nodes of %s: %v", resourceID, err)
+		}
+	}
+}
+
+// resourceDeleted removes the nodes of a deleted node pool or cluster and
+// stops the cluster's control plane
+func (p *AROHCPMockProxyEnhanced) resourceDeleted(resourceID string) {
+	isCluster := clusterIDRE.MatchString(resourceID)
+	// a stopped control plane takes its nodes with it
+	if p.config.MaterializeNodes && (nodePoolIDRE.MatchString(resourceID) || (isCluster && p.controlPlanes.Get(resourceID) == nil)) {
+		if err := p.removeNodes(resourceID); err != nil {
+			log.Printf("Failed to remove nodes of %s: %v", resourceID, err)
+		}
+	}
+	if isCluster {
+		p.controlPlanes.Stop(resourceID)
+	}
+}
+
+// restoreWorkloads starts the control planes and nodes of the resources
+// that were Succeeded when the proxy stopped. Control plane contents are
+// not kept, so the nodes are created again. Ordering by ID handles each
+// cluster before its node pools.
+func (p *AROHCPMockProxyEnhanced) restoreWorkloads() {
+	for _, resource := range p.queryResources("resource_type IN ('hcpOpenShiftClusters', 'nodePools') AND provisioning_state = 'Succeeded'") {
+		p.resourceReady(resource.ID)
+	}
+}
+
+// recoverStuckResources handles resources left in non-terminal provisioning
+// states (Creating, Deleting, Updating) that have no in-progress operation to
+// drive them, e.g. rows written before operations were persisted. It must run
+// after ResumeOperations. Orphaned deletes are finished by removing the row;
+// everything else transitions to Succeeded so it does not stay stuck forever.
 func (p *AROHCPMockProxyEnhanced) recoverStuckResources() {
-	result, err := p.db.Exec(`
-		UPDATE resources
-		SET provisioning_state = 'Succeeded', updated_at = CURRENT_TIMESTAMP
+	rows, err := p.db.Query(`
+		SELECT id, provisioning_state FROM resources
 		WHERE provisioning_state NOT IN ('Succeeded', 'Failed', 'Canceled')
 	`)
 	if err != nil {
 		log.Printf("Warning: failed to recover stuck resources: %v", err)
 		return
 	}
-	if rows, _ := result.RowsAffected(); rows > 0 {
-		log.Printf("Recovered %d resource(s) stuck in non-terminal state -> Succeeded", rows)
+
+	stuck := make(map[string]string)
+	for rows.Next() {
+		var id, state string
+		if err := rows.Scan(&id, &state); err != nil {
+			continue
+		}
+		if !p.asyncOps.HasActiveOperation(id) {
+			stuck[id] = state
+		}
+	}
+	rows.Close()
+
+	for id, state := range stuck {
+		if state == "Deleting" {
+			// Child resources go with their cluster, as in the Delete operation
+			_, err = p.db.Exec("DELETE FROM resources WHERE id = ? OR id LIKE ? ESCAPE '\\'", id, childrenOf(id))
+		} else {
+			_, err = p.db.Exec(`
+				UPDATE resources
+				SET provisioning_state = 'Succeeded', updated_at = CURRENT_TIMESTAMP
+				WHERE id = ?
+			`, id)
+		}
+		if err != nil {
+			log.Printf("Warning: failed to recover stuck resource %s: %v", id, err)
+			continue
+		}
+		log.Printf("Recovered resource %s stuck in %s without an operation", id, state)
 	}
 }
 
 func (p *AROHCPMockProxyEnhanced) Close() error {
+	p.controlPlanes.Close()
 	return p.db.Close()
 }
 
@@ -1237,23 +1688,68 @@ func initDatabase(db *sql.DB) error {
 		provisioning_state TEXT DEFAULT 'Succeeded',
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		etag TEXT,
 		UNIQUE(subscription_id, resource_group, resource_type, name)
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_subscription ON resources(subscription_id);
 	CREATE INDEX IF NOT EXISTS idx_resource_group ON resources(subscription_id, resource_group);
 	CREATE INDEX IF NOT EXISTS idx_type ON resources(resource_type);
+
+	CREATE TABLE IF NOT EXISTS operations (
+		id TEXT PRIMARY KEY,
+		resource_id TEXT NOT NULL,
+		operation_type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		percent_complete INTEGER DEFAULT 0,
+		start_time TIMESTAMP NOT NULL,
+		end_time TIMESTAMP,
+		error_code TEXT,
+		error_message TEXT,
+		result TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_operations_status ON operations(status);
+	CREATE INDEX IF NOT EXISTS idx_operations_resource ON operations(resource_id);
+
+	CREATE TABLE IF NOT EXISTS admin_credentials (
+		id TEXT PRIMARY KEY,
+		cluster_id TEXT NOT NULL,
+		serial_number TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		issued_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_admin_credentials_cluster ON admin_credentials(cluster_id);
 	`
 
-	_, err := db.Exec(schema)
-	return err
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	return migrateETags(db)
 }
 
-func nullIfEmpty(s string) interface{} {
-	if s == "" || s == "null" {
-		return nil
+// mergePatch applies a JSON merge patch (RFC 7386): objects are merged
+// recursively, null removes a member and anything else replaces it
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = map[string]interface{}{}
 	}
-	return s
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+		} else {
+			targetMap[key] = mergePatch(targetMap[key], value)
+		}
+	}
+	return targetMap
 }
 
 func main() {
@@ -1277,8 +1773,31 @@ func main() {
 	if config.EnableAsyncOperations {
 		log.Printf("  Provisioning Delay: %s", config.ProvisioningDelay)
 		log.Printf("  Polling Interval: %s", config.PollingInterval)
+		log.Printf("  Operation Timeout: %s", config.AsyncOperationTimeout)
+		log.Printf("  Operation Retention: %s", config.OperationRetention)
+		if config.ClockSpeed != 1 {
+			log.Printf("  Clock Speed: %gx", config.ClockSpeed)
+		}
 	}
 	log.Printf("  Validation: %v", config.EnableValidation)
+	log.Printf("  Metrics: %v", config.EnableMetrics)
+	log.Printf("  Enforce Parent State: %v", config.EnforceParentState)
+	log.Printf("  List Page Size: %d", config.ListPageSize)
+	if config.VersionCatalogPath != "" {
+		log.Printf("  Version Catalog: %s", config.VersionCatalogPath)
+	} else {
+		log.Printf("  Version Catalog: built-in")
+	}
+	if config.ScenarioDir != "" {
+		log.Printf("  Scenarios: %s", config.ScenarioDir)
+	}
+	log.Printf("  Admin Credential TTL: %s", config.AdminCredentialTTL)
+	log.Printf("  Operation Result TTL: %s", config.OperationResultTTL)
+	log.Printf("  Control Planes: %v", config.EnableControlPlanes)
+	log.Printf("  Materialize Nodes: %v", config.MaterializeNodes)
+	if config.CassetteMode != "" {
+		log.Printf("  Azure Cassettes: %s (%s)", config.CassetteMode, config.CassetteDir)
+	}
 	log.Printf("  Failure Simulation: %v (rate: %.1f%%)", config.SimulateFailures, config.FailureRate*100)
 	log.Printf("")
 	log.Printf("Routing:")
@@ -1288,7 +1807,22 @@ func main() {
 	} else {
 		log.Printf("  ARO-HCP requests -> SQLite Mock")
 	}
-	log.Printf("  Other requests -> %s", config.AzureEndpoint)
+	if config.OfflineMode {
+		log.Printf("  ResourceGroup/KeyVault/Network/ManagedIdentity/RoleAssignment requests -> SQLite Mock (offline mode)")
+		log.Printf("  Other requests -> rejected (offline mode)")
+	} else if config.CassetteMode == cassetteReplay {
+		log.Printf("  Other requests -> replayed from %s", config.CassetteDir)
+	} else if config.CassetteMode == cassetteRecord {
+		log.Printf("  Other requests -> %s, recorded to %s", config.AzureEndpoint, config.CassetteDir)
+	} else {
+		log.Printf("  Other requests -> %s", config.AzureEndpoint)
+	}
+	if config.EnableMetrics {
+		log.Printf("  /metrics -> Prometheus metrics")
+	}
+	if config.AdminPort != "" {
+		log.Printf("  /admin/* -> Admin API on http://%s", config.AdminPort)
+	}
 	log.Printf("")
 
 	proxy, err := NewAROHCPMockProxyEnhanced(config)
@@ -1297,10 +1831,30 @@ func main() {
 	}
 	defer proxy.Close()
 
-	// Recover resources stuck in non-terminal states from a previous run.
-	// Async operations are in-memory only and lost on restart, so any resource
-	// still in "Creating"/"Deleting" will never transition without this.
+	// Resume async operations that were in progress when the proxy stopped,
+	// then clean up any resource left in a non-terminal state without one.
+	// From then on the reaper times out and removes operations.
+	proxy.asyncOps.ResumeOperations()
 	proxy.recoverStuckResources()
+	proxy.asyncOps.StartReaper()
+	go proxy.restoreWorkloads()
+	proxy.startNodeHeartbeats()
+
+	// Control planes are child processes, stop them with the proxy
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		proxy.Close()
+		os.Exit(0)
+	}()
+
+	if config.AdminPort != "" {
+		if _, err := proxy.serveAdmin(config.AdminPort); err != nil {
+			log.Fatalf("Admin server failed: %v", err)
+		}
+		log.Printf("Admin API ready on http://%s", config.AdminPort)
+	}
 
 	log.Printf("Server ready on %s://%s", protocol, config.Port)
 
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAPIVersion = "?api-version=2024-06-10-preview"
+
+func TestDeleteCascade(t *testing.T) {
+	// "_" is a LIKE wildcard: c_1 must not take ca1 and its node pool along
+	clusterID := strings.TrimSuffix(testClusterID, "c1") + "c_1"
+	siblingID := strings.TrimSuffix(testClusterID, "c1") + "ca1"
+	deleted := []string{clusterID, clusterID + "/nodePools/np1", clusterID + "/externalAuths/ea1"}
+	kept := []string{siblingID, siblingID + "/nodePools/np2"}
+
+	for _, async := range []bool{false, true} {
+		name := "sync"
+		if async {
+			name = "async"
+		}
+		t.Run(name, func(t *testing.T) {
+			p := newTestProxy(t, func(c *Config) {
+				c.EnableAsyncOperations = async
+				c.ProvisioningDelay = 50 * time.Millisecond
+			})
+			for _, id := range append(append([]string{}, deleted...), kept...) {
+				insertTestResource(t, p.db, id, "Succeeded")
+			}
+
+			wantStatus := http.StatusNoContent
+			if async {
+				wantStatus = http.StatusAccepted
+			}
+			w := serve(p, "DELETE", clusterID+testAPIVersion, "")
+			if w.Code != wantStatus {
+				t.Fatalf("delete: status %d, want %d: %s", w.Code, wantStatus, w.Body)
+			}
+
+			if async {
+				for _, id := range deleted {
+					if state := resourceState(t, p.db, id); state != "Deleting" {
+						t.Errorf("%s is %q during the delete, want Deleting", id, state)
+					}
+				}
+				// A repeated DELETE of the cluster or a child joins the running one
+				for _, id := range []string{clusterID, deleted[1]} {
+					w := serve(p, "DELETE", id+testAPIVersion, "")
+					if w.Code != http.StatusAccepted || w.Header().Get("Azure-AsyncOperation") == "" {
+						t.Errorf("repeated delete of %s: status %d, want 202 with the operation", id, w.Code)
+					}
+				}
+				eventually(t, "the delete to finish", func() bool { return resourceState(t, p.db, clusterID) == "" })
+			}
+
+			for _, id := range deleted {
+				if state := resourceState(t, p.db, id); state != "" {
+					t.Errorf("%s is %q after the delete, want it gone", id, state)
+				}
+			}
+			for _, id := range kept {
+				if state := resourceState(t, p.db, id); state != "Succeeded" {
+					t.Errorf("%s is %q after deleting %s, want Succeeded", id, state, clusterID)
+				}
+			}
+
+			// Deleting it again succeeds
+			if w := serve(p, "DELETE", clusterID+testAPIVersion, ""); w.Code != http.StatusNoContent {
+				t.Errorf("repeated delete: status %d, want 204", w.Code)
+			}
+		})
+	}
+}
+
+func TestParentGating(t *testing.T) {
+	tests := []struct {
+		name         string
+		clusterState string // "" when there is no cluster
+		enforce      bool
+		child        string
+		wantStatus   int
+		wantCode     string
+	}{
+		{name: "no cluster", child: "nodePools/np1", enforce: true, wantStatus: http.StatusNotFound, wantCode: "ParentResourceNotFound"},
+		{name: "creating cluster", clusterState: "Creating", child: "nodePools/np1", enforce: true, wantStatus: http.StatusConflict, wantCode: "Conflict"},
+		{name: "failed cluster", clusterState: "Failed", child: "externalAuths/ea1", enforce: true, wantStatus: http.StatusConflict, wantCode: "Conflict"},
+		{name: "deleting cluster", clusterState: "Deleting", child: "nodePools/np1", wantStatus: http.StatusConflict, wantCode: "Conflict"},
+		{name: "creating cluster not enforced", clusterState: "Creating", child: "nodePools/np1", wantStatus: http.StatusOK},
+		{name: "succeeded cluster", clusterState: "Succeeded", child: "nodePools/np1", enforce: true, wantStatus: http.StatusOK},
+		{name: "external auth", clusterState: "Succeeded", child: "externalAuths/ea1", enforce: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, func(c *Config) { c.EnforceParentState = tt.enforce })
+			if tt.clusterState != "" {
+				insertTestResource(t, p.db, testClusterID, tt.clusterState)
+			}
+
+			childID := testClusterID + "/" + tt.child
+			w := serve(p, "PUT", childID+testAPIVersion, `{"location":"eastus","properties":{}}`)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("response %s, want code %s", w.Body, tt.wantCode)
+			}
+			if stored := resourceState(t, p.db, childID) != ""; stored != (w.Code == http.StatusOK) {
+				t.Errorf("%s stored: %v after status %d", tt.child, stored, w.Code)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{name: "add", target: `{"a":1}`, patch: `{"b":2}`, want: `{"a":1,"b":2}`},
+		{name: "replace", target: `{"a":1}`, patch: `{"a":"x"}`, want: `{"a":"x"}`},
+		{name: "remove", target: `{"a":1,"b":2}`, patch: `{"a":null}`, want: `{"b":2}`},
+		{name: "nested", target: `{"a":{"b":1,"c":2}}`, patch: `{"a":{"b":null,"d":3}}`, want: `{"a":{"c":2,"d":3}}`},
+		{name: "arrays are replaced", target: `{"a":[1,2]}`, patch: `{"a":[3]}`, want: `{"a":[3]}`},
+		{name: "object over a value", target: `{"a":1}`, patch: `{"a":{"b":1}}`, want: `{"a":{"b":1}}`},
+		{name: "empty target", target: `null`, patch: `{"a":{"b":null}}`, want: `{"a":{}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target, patch interface{}
+			json.Unmarshal([]byte(tt.target), &target)
+			json.Unmarshal([]byte(tt.patch), &patch)
+			got, _ := json.Marshal(mergePatch(target, patch))
+			if !jsonEqual(string(got), tt.want) {
+				t.Errorf("mergePatch(%s, %s) = %s, want %s", tt.target, tt.patch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchResource(t *testing.T) {
+	tests := []struct {
+		name           string
+		state          string
+		async          bool
+		patch          string
+		wantStatus     int
+		wantProperties string
+		wantTags       string
+		wantState      string
+	}{
+		{
+			name: "merges properties and replaces tags", state: "Succeeded",
+			patch:      `{"properties":{"spec":{"a":null,"c":3}},"tags":{"u":"2"}}`,
+			wantStatus: http.StatusOK, wantProperties: `{"spec":{"b":2,"c":3},"d":"x"}`, wantTags: `{"u":"2"}`, wantState: "Succeeded",
+		},
+		{
+			name: "keeps tags that are not patched", state: "Succeeded",
+			patch:      `{"properties":{"d":"y"}}`,
+			wantStatus: http.StatusOK, wantProperties: `{"spec":{"a":1,"b":2},"d":"y"}`, wantTags: `{"t":"1"}`, wantState: "Succeeded",
+		},
+		{
+			name: "async", state: "Succeeded", async: true,
+			patch:      `{"properties":{"d":"y"}}`,
+			wantStatus: http.StatusAccepted, wantProperties: `{"spec":{"a":1,"b":2},"d":"y"}`, wantTags: `{"t":"1"}`, wantState: "Updating",
+		},
+		{
+			name: "deleting", state: "Deleting",
+			patch:      `{"properties":{"d":"y"}}`,
+			wantStatus: http.StatusConflict, wantProperties: `{"spec":{"a":1,"b":2},"d":"x"}`, wantTags: `{"t":"1"}`, wantState: "Deleting",
+		},
+		{
+			name: "bad body", state: "Succeeded",
+			patch:      `{`,
+			wantStatus: http.StatusBadRequest, wantProperties: `{"spec":{"a":1,"b":2},"d":"x"}`, wantTags: `{"t":"1"}`, wantState: "Succeeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, func(c *Config) {
+				c.EnableAsyncOperations = tt.async
+				c.ProvisioningDelay = time.Hour
+			})
+			insertTestResource(t, p.db, testClusterID, tt.state)
+			p.db.Exec(`UPDATE resources SET properties = ?, tags = ? WHERE id = ?`, `{"spec":{"a":1,"b":2},"d":"x"}`, `{"t":"1"}`, testClusterID)
+
+			w := serve(p, "PATCH", testClusterID+testAPIVersion, tt.patch)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			resource, err := p.getResource(testClusterID)
+			if err != nil {
+				t.Fatalf("read %s: %v", testClusterID, err)
+			}
+			if !jsonEqual(resource.Properties, tt.wantProperties) || !jsonEqual(resource.Tags, tt.wantTags) {
+				t.Errorf("stored properties %s and tags %s, want %s and %s", resource.Properties, resource.Tags, tt.wantProperties, tt.wantTags)
+			}
+			if resource.ProvisioningState != tt.wantState {
+				t.Errorf("state %s, want %s", resource.ProvisioningState, tt.wantState)
+			}
+
+			// A second PATCH while the update runs is refused
+			if tt.async {
+				if w := serve(p, "PATCH", testClusterID+testAPIVersion, tt.patch); w.Code != http.StatusConflict {
+					t.Errorf("PATCH during the update: status %d, want 409", w.Code)
+				}
+			}
+		})
+	}
+
+	p := newTestProxy(t, nil)
+	if w := serve(p, "PATCH", testClusterID+testAPIVersion, `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH of a missing cluster: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	userAssignedIdentityPathRE = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.ManagedIdentity/userAssignedIdentities/([^/]+)/?$`)
+	federatedCredentialPathRE  = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.ManagedIdentity/userAssignedIdentities/([^/]+)/federatedIdentityCredentials/([^/]+)/?$`)
+)
+
+var userAssignedIdentityKind = &armResourceKind{
+	ResourceType: "UserAssignedIdentity",
+	ARMType:      "Microsoft.ManagedIdentity/userAssignedIdentities",
+	decorate: func(p *AROHCPMockProxyEnhanced, res *Resource, response map[string]interface{}) {
+		props := response["properties"].(map[string]interface{})
+		principalID, clientID := identityIDs(res.ID)
+		props["tenantId"] = mockTenantID(res.SubscriptionID)
+		props["principalId"] = principalID
+		props["clientId"] = clientID
+		// userAssignedIdentities do not report a provisioning state
+		delete(props, "provisioningState")
+	},
+	afterDelete: func(p *AROHCPMockProxyEnhanced, ref armResourceRef) {
+		p.db.Exec("DELETE FROM resources WHERE resource_type = 'FederatedIdentityCredential' AND id LIKE ? ESCAPE '\\'", childrenOf(ref.ID+"/federatedIdentityCredentials"))
+	},
+}
+
+var federatedIdentityCredentialKind = &armResourceKind{
+	ResourceType: "FederatedIdentityCredential",
+	ARMType:      "Microsoft.ManagedIdentity/userAssignedIdentities/federatedIdentityCredentials",
+	validate: func(p *AROHCPMockProxyEnhanced, ref armResourceRef, body map[string]interface{}) *CloudErrorBody {
+		identityID := ref.ID[:len(ref.ID)-len("/federatedIdentityCredentials/"+ref.displayName())]
+		if !p.resourceExists(identityID, "UserAssignedIdentity") {
+			return &CloudErrorBody{
+				Code: "ParentResourceNotFound",
+				Message: fmt.Sprintf("Failed to perform 'write' on resource(s) of type 'userAssignedIdentities/federatedIdentityCredentials', because the parent resource '%s' could not be found.",
+					identityID),
+			}
+		}
+
+		props, _ := body["properties"].(map[string]interface{})
+		for _, field := range []string{"issuer", "subject"} {
+			if v, _ := props[field].(string); v == "" {
+				return &CloudErrorBody{
+					Code:    "BadRequest",
+					Message: fmt.Sprintf("The federated identity credential property '%s' is required.", field),
+					Target:  "properties." + field,
+				}
+			}
+		}
+		if audiences, _ := props["audiences"].([]interface{}); len(audiences) == 0 {
+			return &CloudErrorBody{
+				Code:    "BadRequest",
+				Message: "The federated identity credential property 'audiences' must contain exactly one value.",
+				Target:  "properties.audiences",
+			}
+		}
+		return nil
+	},
+	decorate: func(p *AROHCPMockProxyEnhanced, res *Resource, response map[string]interface{}) {
+		delete(response["properties"].(map[string]interface{}), "provisioningState")
+	},
+}
+
+// handleManagedIdentity serves Microsoft.ManagedIdentity resources in
+// offline mode. It returns false if the path is not a mocked identity resource.
+func (p *AROHCPMockProxyEnhanced) handleManagedIdentity(w http.ResponseWriter, r *http.Request) bool {
+	path := r.URL.Path
+
+	if m := federatedCredentialPathRE.FindStringSubmatch(path); m != nil {
+		log.Println("  -> Routing to FederatedIdentityCredential Mock (SQLite)")
+		p.handleARMResource(w, r, federatedIdentityCredentialKind, armResourceRef{
+			SubscriptionID: m[1],
+			ResourceGroup:  m[2],
+			ID: fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ManagedIdentity/userAssignedIdentities/%s/federatedIdentityCredentials/%s",
+				m[1], m[2], m[3], m[4]),
+			Name: m[3] + "/" + m[4],
+		})
+		return true
+	}
+
+	if m := userAssignedIdentityPathRE.FindStringSubmatch(path); m != nil {
+		log.Println("  -> Routing to UserAssignedIdentity Mock (SQLite)")
+		p.handleARMResource(w, r, userAssignedIdentityKind, armResourceRef{
+			SubscriptionID: m[1],
+			ResourceGroup:  m[2],
+			ID:             fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ManagedIdentity/userAssignedIdentities/%s", m[1], m[2], m[3]),
+			Name:           m[3],
+		})
+		return true
+	}
+
+	return false
+}
+
+// stableUUID derives a UUID-formatted value from seed, so generated IDs stay
+// the same across re-creates and proxy restarts
+func stableUUID(seed string) string {
+	sum := sha1.Sum([]byte(seed))
+	sum[6] = (sum[6] & 0x0f) | 0x50 // version 5
+	sum[8] = (sum[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// identityIDs returns the principalId and clientId of a user-assigned identity
+func identityIDs(identityID string) (principalID, clientID string) {
+	key := strings.ToLower(identityID)
+	return stableUUID("principal:" + key), stableUUID("client:" + key)
+}
+
+func mockTenantID(subscriptionID string) string {
+	return stableUUID("tenant:" + strings.ToLower(subscriptionID))
+}
+
+// checkIdentityReferences verifies that every user-assigned identity a
+// cluster refers to exists in the mock store, and that the operator
+// identities are assigned to the cluster itself, like the RP does before
+// accepting a create. It only applies in offline mode.
+func (p *AROHCPMockProxyEnhanced) checkIdentityReferences(resourceType string, body map[string]interface{}) *CloudErrorBody {
+	if resourceType != "hcpOpenShiftClusters" {
+		return nil
+	}
+
+	assigned := map[string]bool{}
+	if identity, ok := body["identity"].(map[string]interface{}); ok {
+		uais, _ := identity["userAssignedIdentities"].(map[string]interface{})
+		for _, id := range sortedKeys(uais) {
+			if !p.resourceExists(id, "UserAssignedIdentity") {
+				return identityNotFound(id)
+			}
+			assigned[strings.ToLower(id)] = true
+		}
+	}
+
+	operators := operatorIdentities(body)
+	for _, field := range sortedKeys(operators) {
+		id := operators[field]
+		if !p.resourceExists(id, "UserAssignedIdentity") {
+			return identityNotFound(id)
+		}
+		// Data plane operator identities are federated into the workload
+		// cluster and are not assigned to the cluster resource itself
+		if !strings.Contains(field, ".dataPlaneOperators.") && !assigned[strings.ToLower(id)] {
+			return &CloudErrorBody{
+				Code:    "InvalidRequestContent",
+				Message: fmt.Sprintf("Identity '%s' referenced by '%s' is not assigned to this resource.", id, field),
+				Target:  field,
+			}
+		}
+	}
+	return nil
+}
+
+// operatorIdentities returns the identity resource IDs in
+// properties.platform.operatorsAuthentication keyed by their field path
+func operatorIdentities(body map[string]interface{}) map[string]string {
+	const base = "properties.platform.operatorsAuthentication.userAssignedIdentities"
+	result := map[string]string{}
+
+	values := resolvePath(body, base)
+	if len(values) == 0 || !values[0].found {
+		return result
+	}
+	uais, _ := values[0].value.(map[string]interface{})
+
+	for _, group := range []string{"controlPlaneOperators", "dataPlaneOperators"} {
+		operators, _ := uais[group].(map[string]interface{})
+		for name, v := range operators {
+			if id, _ := v.(string); id != "" {
+				result[base+"."+group+"."+name] = id
+			}
+		}
+	}
+	if id, _ := uais["serviceManagedIdentity"].(string); id != "" {
+		result[base+".serviceManagedIdentity"] = id
+	}
+	return result
+}
+
+func identityNotFound(id string) *CloudErrorBody {
+	return &CloudErrorBody{
+		Code:    "FailedIdentityOperation",
+		Message: fmt.Sprintf("Identity operation failed: the user assigned identity '%s' was not found.", id),
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Routes used as the "route" label of the request metrics
+const (
+	routeMock            = "mock"
+	routeDevProxy        = "dev-proxy"
+	routeAzure           = "azure"
+	routeReplay          = "replay"
+	routeOperationStatus = "operation-status"
+)
+
+// Metrics holds the Prometheus collectors exported on /metrics when
+// ENABLE_METRICS is set. A nil *Metrics is valid and records nothing, so
+// callers do not need to check whether metrics are enabled.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests           *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	operationsInFlight *prometheus.GaugeVec
+	operationsFinished *prometheus.CounterVec
+	operationDuration  *prometheus.HistogramVec
+	operationsReaped   prometheus.Counter
+	operationSettings  *prometheus.GaugeVec
+}
+
+func NewMetrics(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aro_mockup_proxy_requests_total",
+			Help: "Number of HTTP requests handled by the proxy, by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "aro_mockup_proxy_request_duration_seconds",
+			Help:    "Latency of HTTP requests handled by the proxy, by route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		operationsInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "aro_mockup_proxy_async_operations_in_flight",
+			Help: "Number of async operations currently in progress, by operation type.",
+		}, []string{"type"}),
+		operationsFinished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aro_mockup_proxy_async_operations_finished_total",
+			Help: "Number of async operations that reached a terminal state, by operation type and final status.",
+		}, []string{"type", "status"}),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "aro_mockup_proxy_async_operation_duration_seconds",
+			Help:    "Time from start to completion of async operations, by operation type and final status.",
+			Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600, 1200},
+		}, []string{"type", "status"}),
+		operationsReaped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "aro_mockup_proxy_async_operations_reaped_total",
+			Help: "Number of finished async operations removed after the retention period.",
+		}),
+		operationSettings: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "aro_mockup_proxy_async_operation_settings_seconds",
+			Help: "Async operation timeout and retention period, 0 when disabled.",
+		}, []string{"setting"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.operationsInFlight,
+		m.operationsFinished,
+		m.operationDuration,
+		m.operationsReaped,
+		m.operationSettings,
+		&resourceCollector{db: db},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a finished HTTP request
+func (m *Metrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(route, method, code).Inc()
+	m.requestDuration.WithLabelValues(route, method, code).Observe(duration.Seconds())
+}
+
+// OperationStarted records an async operation entering InProgress
+func (m *Metrics) OperationStarted(operationType string) {
+	if m == nil {
+		return
+	}
+	m.operationsInFlight.WithLabelValues(operationType).Inc()
+}
+
+// OperationFinished records an async operation reaching a terminal state
+func (m *Metrics) OperationFinished(operationType, status string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.operationsInFlight.WithLabelValues(operationType).Dec()
+	m.operationsFinished.WithLabelValues(operationType, status).Inc()
+	m.operationDuration.WithLabelValues(operationType, status).Observe(duration.Seconds())
+}
+
+// OperationsReaped records finished async operations removed by the reaper
+func (m *Metrics) OperationsReaped(n int) {
+	if m == nil {
+		return
+	}
+	m.operationsReaped.Add(float64(n))
+}
+
+// SetOperationSettings exports the async operation timeout and retention
+func (m *Metrics) SetOperationSettings(timeout, retention time.Duration) {
+	if m == nil {
+		return
+	}
+	m.operationSettings.WithLabelValues("timeout").Set(timeout.Seconds())
+	m.operationSettings.WithLabelValues("retention").Set(retention.Seconds())
+}
+
+// resourceCollector reports the number of stored resources by type and
+// provisioning state. It queries the database on every scrape so the
+// numbers always match the resources table.
+type resourceCollector struct {
+	db *sql.DB
+}
+
+var resourcesDesc = prometheus.NewDesc(
+	"aro_mockup_proxy_resources",
+	"Number of resources in the mock store, by resource type and provisioning state.",
+	[]string{"type", "provisioning_state"}, nil,
+)
+
+func (c *resourceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- resourcesDesc
+}
+
+func (c *resourceCollector) Collect(ch chan<- prometheus.Metric) {
+	rows, err := c.db.Query(`
+		SELECT resource_type, provisioning_state, COUNT(*)
+		FROM resources
+		GROUP BY resource_type, provisioning_state
+	`)
+	if err != nil {
+		log.Printf("Failed to collect resource metrics: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var resourceType, state string
+		var count int
+		if err := rows.Scan(&resourceType, &state, &count); err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(resourcesDesc, prometheus.GaugeValue, float64(count), resourceType, state)
+	}
+}
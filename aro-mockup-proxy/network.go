@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+var (
+	virtualNetworkPathRE = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Network/virtualNetworks/([^/]+)/?$`)
+	subnetPathRE         = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Network/virtualNetworks/([^/]+)/subnets/([^/]+)/?$`)
+	nsgPathRE            = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Network/networkSecurityGroups/([^/]+)/?$`)
+)
+
+var virtualNetworkKind = &armResourceKind{
+	ResourceType: "VirtualNetwork",
+	ARMType:      "Microsoft.Network/virtualNetworks",
+	validate: func(p *AROHCPMockProxyEnhanced, ref armResourceRef, body map[string]interface{}) *CloudErrorBody {
+		// Subnets are managed through their own resource; never store
+		// them inline with the VNet.
+		if props, ok := body["properties"].(map[string]interface{}); ok {
+			delete(props, "subnets")
+		}
+		return nil
+	},
+	decorate: func(p *AROHCPMockProxyEnhanced, res *Resource, response map[string]interface{}) {
+		subnets := []interface{}{}
+		for _, subnet := range p.listSubnets(res.ID) {
+			subnets = append(subnets, p.buildARMResourceResponse(subnetKind, subnet))
+		}
+		response["properties"].(map[string]interface{})["subnets"] = subnets
+	},
+	beforeDelete: func(p *AROHCPMockProxyEnhanced, ref armResourceRef) *CloudErrorBody {
+		for _, subnet := range p.listSubnets(ref.ID) {
+			if verr := subnetInUse(p, subnet.ID); verr != nil {
+				return verr
+			}
+		}
+		return nil
+	},
+	afterDelete: func(p *AROHCPMockProxyEnhanced, ref armResourceRef) {
+		p.db.Exec("DELETE FROM resources WHERE resource_type = 'Subnet' AND id LIKE ? ESCAPE '\\'", childrenOf(ref.ID+"/subnets"))
+	},
+}
+
+var subnetKind = &armResourceKind{
+	ResourceType: "Subnet",
+	ARMType:      "Microsoft.Network/virtualNetworks/subnets",
+	validate: func(p *AROHCPMockProxyEnhanced, ref armResourceRef, body map[string]interface{}) *CloudErrorBody {
+		vnetID := ref.ID[:len(ref.ID)-len("/subnets/"+ref.displayName())]
+		if !p.resourceExists(vnetID, "VirtualNetwork") {
+			return &CloudErrorBody{
+				Code: "ParentResourceNotFound",
+				Message: fmt.Sprintf("Failed to perform 'write' on resource(s) of type 'virtualNetworks/subnets', because the parent resource '%s' could not be found.",
+					vnetID),
+			}
+		}
+
+		props, _ := body["properties"].(map[string]interface{})
+		if nsg, ok := props["networkSecurityGroup"].(map[string]interface{}); ok {
+			if nsgID, _ := nsg["id"].(string); nsgID != "" && !p.resourceExists(nsgID, "NetworkSecurityGroup") {
+				return &CloudErrorBody{
+					Code:    "InvalidResourceReference",
+					Message: fmt.Sprintf("Resource %s referenced by resource %s was not found. Please make sure that the referenced resource exists, and that both resources are in the same region.", nsgID, ref.ID),
+				}
+			}
+		}
+		return nil
+	},
+	beforeDelete: func(p *AROHCPMockProxyEnhanced, ref armResourceRef) *CloudErrorBody {
+		return subnetInUse(p, ref.ID)
+	},
+}
+
+var networkSecurityGroupKind = &armResourceKind{
+	ResourceType: "NetworkSecurityGroup",
+	ARMType:      "Microsoft.Network/networkSecurityGroups",
+	decorate: func(p *AROHCPMockProxyEnhanced, res *Resource, response map[string]interface{}) {
+		props := response["properties"].(map[string]interface{})
+		if _, ok := props["securityRules"]; !ok {
+			props["securityRules"] = []interface{}{}
+		}
+	},
+	beforeDelete: func(p *AROHCPMockProxyEnhanced, ref armResourceRef) *CloudErrorBody {
+		if subnetID, inUse := p.findReferencingResource(ref.ID, "$.networkSecurityGroup.id"); inUse {
+			return &CloudErrorBody{
+				Code:    "InUseNetworkSecurityGroupCannotBeDeleted",
+				Message: fmt.Sprintf("Network security group %s cannot be deleted because it is in use by the following resources: %s.", ref.ID, subnetID),
+			}
+		}
+		if clusterID, inUse := p.findReferencingResource(ref.ID, "$.platform.networkSecurityGroupId"); inUse {
+			return &CloudErrorBody{
+				Code:    "InUseNetworkSecurityGroupCannotBeDeleted",
+				Message: fmt.Sprintf("Network security group %s cannot be deleted because it is in use by the following resources: %s.", ref.ID, clusterID),
+			}
+		}
+		return nil
+	},
+}
+
+// subnetInUse rejects deleting a subnet that an ARO-HCP cluster or node pool
+// still references
+func subnetInUse(p *AROHCPMockProxyEnhanced, subnetID string) *CloudErrorBody {
+	if referrer, inUse := p.findReferencingResource(subnetID, "$.platform.subnetId", "$.platform.vnetIntegrationSubnetId"); inUse {
+		return &CloudErrorBody{
+			Code:    "InUseSubnetCannotBeDeleted",
+			Message: fmt.Sprintf("Subnet %s is in use by %s and cannot be deleted.", subnetID, referrer),
+		}
+	}
+	return nil
+}
+
+// handleNetwork serves Microsoft.Network resources in offline mode.
+// It returns false if the path is not a mocked network resource.
+func (p *AROHCPMockProxyEnhanced) handleNetwork(w http.ResponseWriter, r *http.Request) bool {
+	path := r.URL.Path
+
+	if m := subnetPathRE.FindStringSubmatch(path); m != nil {
+		log.Println("  -> Routing to Subnet Mock (SQLite)")
+		p.handleARMResource(w, r, subnetKind, armResourceRef{
+			SubscriptionID: m[1],
+			ResourceGroup:  m[2],
+			ID: fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s/subnets/%s",
+				m[1], m[2], m[3], m[4]),
+			Name: m[3] + "/" + m[4],
+		})
+		return true
+	}
+
+	if m := virtualNetworkPathRE.FindStringSubmatch(path); m != nil {
+		log.Println("  -> Routing to VirtualNetwork Mock (SQLite)")
+		p.handleARMResource(w, r, virtualNetworkKind, armResourceRef{
+			SubscriptionID: m[1],
+			ResourceGroup:  m[2],
+			ID:             fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s", m[1], m[2], m[3]),
+			Name:           m[3],
+		})
+		return true
+	}
+
+	if m := nsgPathRE.FindStringSubmatch(path); m != nil {
+		log.Println("  -> Routing to NetworkSecurityGroup Mock (SQLite)")
+		p.handleARMResource(w, r, networkSecurityGroupKind, armResourceRef{
+			SubscriptionID: m[1],
+			ResourceGroup:  m[2],
+			ID:             fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/networkSecurityGroups/%s", m[1], m[2], m[3]),
+			Name:           m[3],
+		})
+		return true
+	}
+
+	return false
+}
+
+func (p *AROHCPMockProxyEnhanced) listSubnets(vnetID string) []*Resource {
+	return p.queryResources("resource_type = 'Subnet' AND id LIKE ? ESCAPE '\\'", childrenOf(vnetID+"/subnets"))
+}
+
+// checkNetworkReferences mirrors the RP preflight check that the subnets and
+// NSG referenced by a cluster or node pool exist. It only applies in offline
+// mode, where network resources live in the mock store.
+func (p *AROHCPMockProxyEnhanced) checkNetworkReferences(resourceType string, body map[string]interface{}) *CloudErrorBody {
+	type reference struct {
+		field        string
+		resourceType string
+	}
+	var refs []reference
+	switch resourceType {
+	case "hcpOpenShiftClusters":
+		refs = []reference{
+			{"properties.platform.subnetId", "Subnet"},
+			{"properties.platform.vnetIntegrationSubnetId", "Subnet"},
+			{"properties.platform.networkSecurityGroupId", "NetworkSecurityGroup"},
+		}
+	case "nodePools":
+		refs = []reference{
+			{"properties.platform.subnetId", "Subnet"},
+		}
+	default:
+		return nil
+	}
+
+	for _, ref := range refs {
+		values := resolvePath(body, ref.field)
+		if len(values) == 0 || !values[0].found {
+			continue
+		}
+		id, _ := values[0].value.(string)
+		if id == "" || p.resourceExists(id, ref.resourceType) {
+			continue
+		}
+		return &CloudErrorBody{
+			Code:    "InvalidRequestContent",
+			Message: fmt.Sprintf("The %s '%s' referenced by '%s' was not found.", ref.resourceType, id, ref.field),
+			Target:  ref.field,
+		}
+	}
+	return nil
+}
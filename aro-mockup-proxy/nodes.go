@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Labels on the Node objects the proxy creates for node pools
+const (
+	nodeClusterLabel  = "aro-mockup-proxy/cluster"
+	nodeNodePoolLabel = "aro-mockup-proxy/node-pool"
+)
+
+// The proxy stands in for the kubelets of the nodes: it renews their Lease
+// in kube-node-lease and the Ready heartbeat at the kubelet's intervals, so
+// the node lifecycle controller of the workload cluster keeps them Ready
+const (
+	nodeLeaseNamespace    = "kube-node-lease"
+	nodeLeaseDuration     = 40
+	nodeHeartbeatInterval = 10 * time.Second
+	kubeMicroTimeFormat   = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// nodePoolIDRE matches the ID of a node pool
+var nodePoolIDRE = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.RedHatOpenShift/hcpOpenShiftClusters/[^/]+/nodePools/[^/]+$`)
+
+// kubeClient is a minimal client for the workload API server
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// workloadClient returns a client for the API server that the admin
+// kubeconfig of a cluster points at: its own control plane, or else the
+// workload kubeconfig
+func (p *AROHCPMockProxyEnhanced) workloadClient(clusterID string) (*kubeClient, error) {
+	if cp := p.controlPlanes.Get(clusterID); cp != nil {
+		return &kubeClient{server: cp.localURL(), client: cp.client}, nil
+	}
+
+	cluster, user, err := workloadContext(p.config.WorkloadKubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	server, _ := cluster["server"].(string)
+	tlsConfig := &tls.Config{}
+	if skip, _ := cluster["insecure-skip-tls-verify"].(bool); skip {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if caData, _ := cluster["certificate-authority-data"].(string); caData != "" {
+		ca, err := base64.StdEncoding.DecodeString(caData)
+		if err != nil {
+			return nil, fmt.Errorf("certificate-authority-data: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AppendCertsFromPEM(ca)
+	}
+	certData, _ := user["client-certificate-data"].(string)
+	keyData, _ := user["client-key-data"].(string)
+	if certData != "" && keyData != "" {
+		cert, _ := base64.StdEncoding.DecodeString(certData)
+		key, _ := base64.StdEncoding.DecodeString(keyData)
+		keyPair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{keyPair}
+	}
+	token, _ := user["token"].(string)
+
+	return &kubeClient{
+		server: server,
+		token:  token,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// kubeAPIError is a non-2xx response of the API server
+type kubeAPIError struct {
+	Method     string
+	Path       string
+	Status     string
+	StatusCode int
+	Body       string
+}
+
+func (e *kubeAPIError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+// isNotFound reports whether err is a 404 from the API server
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*kubeAPIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out, if given. Non-2xx responses are returned as
+// *kubeAPIError.
+func (c *kubeClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &kubeAPIError{Method: method, Path: path, Status: resp.Status, StatusCode: resp.StatusCode,
+			Body: strings.TrimSpace(string(msg))}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// nodeNames returns the names of the nodes matching a label selector
+func (c *kubeClient) nodeNames(selector string) ([]string, error) {
+	nodes, err := c.nodeList(selector)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, node := range nodes {
+		name, _ := node["metadata"].(map[string]interface{})["name"].(string)
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// nodeList returns the nodes matching a label selector
+func (c *kubeClient) nodeList(selector string) ([]map[string]interface{}, error) {
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := c.do("GET", "/api/v1/nodes?labelSelector="+url.QueryEscape(selector), nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// leasePath is the API path of the Lease of a node
+func leasePath(name string) string {
+	return "/apis/coordination.k8s.io/v1/namespaces/" + nodeLeaseNamespace + "/leases/" + name
+}
+
+// renewLease creates the Lease of a node, owned by it, or renews it
+func (c *kubeClient) renewLease(name, uid string) error {
+	now := time.Now().UTC().Format(kubeMicroTimeFormat)
+	var lease map[string]interface{}
+	err := c.do("GET", leasePath(name), nil, &lease)
+	if isNotFound(err) {
+		return c.do("POST", "/apis/coordination.k8s.io/v1/namespaces/"+nodeLeaseNamespace+"/leases", map[string]interface{}{
+			"apiVersion": "coordination.k8s.io/v1",
+			"kind":       "Lease",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": nodeLeaseNamespace,
+				"ownerReferences": []map[string]interface{}{{
+					"apiVersion": "v1",
+					"kind":       "Node",
+					"name":       name,
+					"uid":        uid,
+				}},
+			},
+			"spec": map[string]interface{}{
+				"holderIdentity":       name,
+				"leaseDurationSeconds": nodeLeaseDuration,
+				"renewTime":            now,
+			},
+		}, nil)
+	}
+	if err != nil {
+		return err
+	}
+	spec, _ := lease["spec"].(map[string]interface{})
+	if spec == nil {
+		spec = map[string]interface{}{}
+		lease["spec"] = spec
+	}
+	spec["holderIdentity"] = name
+	spec["leaseDurationSeconds"] = nodeLeaseDuration
+	spec["renewTime"] = now
+	return c.do("PUT", leasePath(name), lease, nil)
+}
+
+// deleteNode deletes a node and its Lease. Workload clusters without a
+// garbage collector, like envtest, would keep the Lease otherwise.
+func (c *kubeClient) deleteNode(name string) error {
+	if err := c.do("DELETE", "/api/v1/nodes/"+name, nil, nil); err != nil && !isNotFound(err) {
+		return err
+	}
+	if err := c.do("DELETE", leasePath(name), nil, nil); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// heartbeat posts the Ready status of a node the way its kubelet would,
+// marking it Ready again if the node lifecycle controller gave up on it,
+// and renews its Lease
+func (c *kubeClient) heartbeat(node map[string]interface{}) error {
+	metadata, _ := node["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	uid, _ := metadata["uid"].(string)
+	status, _ := node["status"].(map[string]interface{})
+	if status == nil {
+		return fmt.Errorf("node %s has no status", name)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	ready := map[string]interface{}{"type": "Ready", "lastTransitionTime": now}
+	conditions, _ := status["conditions"].([]interface{})
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]interface{}); ok && condition["type"] == "Ready" {
+			ready = condition
+		}
+	}
+	if ready["status"] != "True" {
+		ready["lastTransitionTime"] = now
+		if _, ok := ready["status"]; !ok {
+			conditions = append(conditions, ready)
+		}
+	}
+	ready["status"] = "True"
+	ready["reason"] = "KubeletReady"
+	ready["message"] = "kubelet is posting ready status"
+	ready["lastHeartbeatTime"] = now
+	status["conditions"] = conditions
+
+	if err := c.do("PUT", "/api/v1/nodes/"+name+"/status", node, nil); err != nil {
+		return err
+	}
+	return c.renewLease(name, uid)
+}
+
+// desiredNodeCount is properties.replicas of a node pool, or the
+// autoscaling minimum when autoscaling is set
+func desiredNodeCount(pool *Resource) int {
+	var props struct {
+		Replicas    *int `json:"replicas"`
+		AutoScaling *struct {
+			Min int `json:"min"`
+		} `json:"autoScaling"`
+	}
+	json.Unmarshal([]byte(pool.Properties), &props)
+	if props.AutoScaling != nil {
+		return props.AutoScaling.Min
+	}
+	if props.Replicas != nil {
+		return *props.Replicas
+	}
+	return 0
+}
+
+// kubeletVersion maps an OpenShift 4.y version to the Kubernetes 1.(y+13)
+// release it ships
+func kubeletVersion(openshiftVersion string) string {
+	parts := strings.Split(normalizeVersion(openshiftVersion), ".")
+	if len(parts) >= 2 {
+		if minor, err := strconv.Atoi(parts[1]); err == nil && parts[0] == "4" {
+			return fmt.Sprintf("v1.%d.0", minor+13)
+		}
+	}
+	return "v1.33.0"
+}
+
+// nodeObject returns the Node the kubelet of a node pool VM would register
+func nodeObject(cluster, pool *Resource, name string) map[string]interface{} {
+	var clusterProps struct {
+		Platform struct {
+			ManagedResourceGroup string `json:"managedResourceGroup"`
+		} `json:"platform"`
+	}
+	json.Unmarshal([]byte(cluster.Properties), &clusterProps)
+	managedRG := clusterProps.Platform.ManagedResourceGroup
+	if managedRG == "" {
+		managedRG = cluster.Name + "-managed"
+	}
+	var poolProps struct {
+		Version struct {
+			ID string `json:"id"`
+		} `json:"version"`
+		Platform struct {
+			VMSize string `json:"vmSize"`
+		} `json:"platform"`
+	}
+	json.Unmarshal([]byte(pool.Properties), &poolProps)
+
+	sum := sha1.Sum([]byte(name))
+	now := time.Now().UTC().Format(time.RFC3339)
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Node",
+		"metadata": map[string]interface{}{
+			"name": name,
+			"labels": map[string]interface{}{
+				"kubernetes.io/hostname":           name,
+				"kubernetes.io/os":                 "linux",
+				"kubernetes.io/arch":               "amd64",
+				"node-role.kubernetes.io/worker":   "",
+				"node.kubernetes.io/instance-type": poolProps.Platform.VMSize,
+				"topology.kubernetes.io/region":    pool.Location,
+				"hypershift.openshift.io/nodePool": pool.Name,
+				nodeClusterLabel:                   stableUUID(strings.ToLower(cluster.ID)),
+				nodeNodePoolLabel:                  stableUUID(strings.ToLower(pool.ID)),
+			},
+		},
+		"spec": map[string]interface{}{
+			"providerID": fmt.Sprintf("azure:///subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+				cluster.SubscriptionID, managedRG, name),
+		},
+		"status": map[string]interface{}{
+			"conditions": []map[string]interface{}{{
+				"type":               "Ready",
+				"status":             "True",
+				"reason":             "KubeletReady",
+				"message":            "kubelet is posting ready status",
+				"lastHeartbeatTime":  now,
+				"lastTransitionTime": now,
+			}},
+			"addresses": []map[string]interface{}{
+				{"type": "InternalIP", "address": fmt.Sprintf("10.%d.%d.%d", sum[0], sum[1], sum[2]|1)},
+				{"type": "Hostname", "address": name},
+			},
+			"nodeInfo": map[string]interface{}{
+				"kubeletVersion":          kubeletVersion(poolProps.Version.ID),
+				"operatingSystem":         "linux",
+				"architecture":            "amd64",
+				"osImage":                 "Red Hat Enterprise Linux CoreOS",
+				"containerRuntimeVersion": "cri-o://1.33.0",
+			},
+		},
+	}
+}
+
+// reconcileNodes makes the Node objects of a node pool match its desired
+// node count. Nodes are named <pool>-<hash>-<index>, so scaling down
+// removes the highest indexes first.
+func (p *AROHCPMockProxyEnhanced) reconcileNodes(poolID string) error {
+	pool, err := p.getResource(poolID)
+	if err != nil {
+		return err
+	}
+	clusterID := poolID[:strings.LastIndex(strings.ToLower(poolID), "/nodepools/")]
+	cluster, err := p.getResource(clusterID)
+	if err != nil {
+		return err
+	}
+	client, err := p.workloadClient(clusterID)
+	if err != nil {
+		return err
+	}
+
+	existing, err := client.nodeNames(nodeNodePoolLabel + "=" + stableUUID(strings.ToLower(poolID)))
+	if err != nil {
+		return err
+	}
+	desired := map[string]bool{}
+	prefix := fmt.Sprintf("%s-%s-", pool.Name, stableUUID(strings.ToLower(poolID))[:5])
+	for i := 0; i < desiredNodeCount(pool); i++ {
+		desired[prefix+strconv.Itoa(i)] = true
+	}
+
+	for _, name := range existing {
+		if desired[name] {
+			delete(desired, name)
+			continue
+		}
+		if err := client.deleteNode(name); err != nil {
+			return err
+		}
+		log.Printf("Removed node %s of %s", name, poolID)
+	}
+	for _, name := range sortedKeys(desired) {
+		node := nodeObject(cluster, pool, name)
+		var created map[string]interface{}
+		if err := client.do("POST", "/api/v1/nodes", node, &created); err != nil {
+			return err
+		}
+		// The API server may drop the status on create; set it like the
+		// kubelet does once it has registered
+		created["status"] = node["status"]
+		if err := client.do("PUT", "/api/v1/nodes/"+name+"/status", created, nil); err != nil {
+			return err
+		}
+		uid, _ := created["metadata"].(map[string]interface{})["uid"].(string)
+		if err := client.renewLease(name, uid); err != nil {
+			return err
+		}
+		log.Printf("Created node %s of %s", name, poolID)
+	}
+	return nil
+}
+
+// removeNodes deletes the Node objects of a node pool, or of all node pools
+// of a cluster
+func (p *AROHCPMockProxyEnhanced) removeNodes(resourceID string) error {
+	clusterID, selector := resourceID, nodeClusterLabel+"="+stableUUID(strings.ToLower(resourceID))
+	if idx := strings.LastIndex(strings.ToLower(resourceID), "/nodepools/"); idx >= 0 {
+		clusterID, selector = resourceID[:idx], nodeNodePoolLabel+"="+stableUUID(strings.ToLower(resourceID))
+	}
+	client, err := p.workloadClient(clusterID)
+	if err != nil {
+		return err
+	}
+	names, err := client.nodeNames(selector)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := client.deleteNode(name); err != nil {
+			return err
+		}
+		log.Printf("Removed node %s of %s", name, resourceID)
+	}
+	return nil
+}
+
+// startNodeHeartbeats keeps the nodes of every cluster Ready in the
+// background, every nodeHeartbeatInterval of wall-clock time as the
+// workload cluster's controllers expect, when MATERIALIZE_NODES is set
+func (p *AROHCPMockProxyEnhanced) startNodeHeartbeats() {
+	if !p.config.MaterializeNodes {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(nodeHeartbeatInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, pool := range p.queryResources("resource_type = 'nodePools'") {
+				if err := p.heartbeatNodes(pool.ID); err != nil {
+					log.Printf("Failed to renew the nodes of %s: %v", pool.ID, err)
+				}
+			}
+		}
+	}()
+}
+
+// heartbeatNodes renews the nodes of a node pool
+func (p *AROHCPMockProxyEnhanced) heartbeatNodes(poolID string) error {
+	client, err := p.workloadClient(poolID[:strings.LastIndex(strings.ToLower(poolID), "/nodepools/")])
+	if err != nil {
+		return err
+	}
+	nodes, err := client.nodeList(nodeNodePoolLabel + "=" + stableUUID(strings.ToLower(poolID)))
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := client.heartbeat(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
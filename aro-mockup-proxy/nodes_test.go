@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeKubeAPI serves the Node and Lease endpoints the proxy uses, for
+// requests with the token of testWorkloadKubeconfig
+type fakeKubeAPI struct {
+	mu     sync.Mutex
+	nodes  map[string]map[string]interface{}
+	leases map[string]map[string]interface{}
+}
+
+func (f *fakeKubeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer workload-token" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body map[string]interface{}
+	if r.Method == "POST" || r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	leases := "/apis/coordination.k8s.io/v1/namespaces/" + nodeLeaseNamespace + "/leases"
+	path := r.URL.Path
+	switch {
+	case r.Method == "GET" && path == "/api/v1/nodes":
+		key, value, _ := strings.Cut(r.URL.Query().Get("labelSelector"), "=")
+		items := []map[string]interface{}{}
+		for _, name := range sortedKeys(f.nodes) {
+			labels, _ := f.nodes[name]["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+			if labels[key] == value {
+				items = append(items, f.nodes[name])
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	case r.Method == "POST" && path == "/api/v1/nodes":
+		f.create(w, f.nodes, body, false)
+	case r.Method == "PUT" && strings.HasPrefix(path, "/api/v1/nodes/") && strings.HasSuffix(path, "/status"):
+		name := strings.TrimSuffix(strings.TrimPrefix(path, "/api/v1/nodes/"), "/status")
+		if f.nodes[name] == nil {
+			http.NotFound(w, r)
+			return
+		}
+		f.nodes[name]["status"] = body["status"]
+	case r.Method == "DELETE" && strings.HasPrefix(path, "/api/v1/nodes/"):
+		f.remove(w, r, f.nodes, strings.TrimPrefix(path, "/api/v1/nodes/"))
+	case r.Method == "POST" && path == leases:
+		f.create(w, f.leases, body, true)
+	case strings.HasPrefix(path, leases+"/"):
+		name := strings.TrimPrefix(path, leases+"/")
+		switch {
+		case f.leases[name] == nil && r.Method != "DELETE":
+			http.NotFound(w, r)
+		case r.Method == "GET":
+			json.NewEncoder(w).Encode(f.leases[name])
+		case r.Method == "PUT":
+			f.leases[name] = body
+		case r.Method == "DELETE":
+			f.remove(w, r, f.leases, name)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeKubeAPI) create(w http.ResponseWriter, objects map[string]map[string]interface{}, object map[string]interface{}, keepStatus bool) {
+	metadata, _ := object["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if objects[name] != nil {
+		http.Error(w, "AlreadyExists", http.StatusConflict)
+		return
+	}
+	metadata["uid"] = stableUUID(name)
+	if !keepStatus {
+		delete(object, "status")
+	}
+	objects[name] = object
+	json.NewEncoder(w).Encode(object)
+}
+
+func (f *fakeKubeAPI) remove(w http.ResponseWriter, r *http.Request, objects map[string]map[string]interface{}, name string) {
+	if objects[name] == nil {
+		http.NotFound(w, r)
+		return
+	}
+	delete(objects, name)
+}
+
+// readyStatus returns the status of the Ready condition of a node
+func (f *fakeKubeAPI) readyStatus(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status, _ := f.nodes[name]["status"].(map[string]interface{})
+	conditions, _ := status["conditions"].([]interface{})
+	for _, c := range conditions {
+		if condition, _ := c.(map[string]interface{}); condition["type"] == "Ready" {
+			return fmt.Sprint(condition["status"])
+		}
+	}
+	return ""
+}
+
+// newNodeTestProxy returns a proxy whose workload kubeconfig points at a
+// fake API server
+func newNodeTestProxy(t *testing.T) (*AROHCPMockProxyEnhanced, *fakeKubeAPI) {
+	t.Helper()
+	api := &fakeKubeAPI{nodes: map[string]map[string]interface{}{}, leases: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	path := filepath.Join(t.TempDir(), "workload-kubeconfig.yaml")
+	kubeconfig := strings.Replace(testWorkloadKubeconfig, "https://workload.example:6443", server.URL, 1)
+	if err := os.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return newTestProxy(t, func(c *Config) { c.WorkloadKubeconfigPath = path }), api
+}
+
+func TestNodeListing(t *testing.T) {
+	p, api := newNodeTestProxy(t)
+	poolID, otherPoolID := testClusterID+"/nodePools/np1", testClusterID+"/nodePools/np2"
+	insertTestResource(t, p.db, testClusterID, "Succeeded")
+	setPoolProperties := func(id, properties string) {
+		t.Helper()
+		if _, err := p.db.Exec(`UPDATE resources SET properties = ? WHERE id = ?`, properties, id); err != nil {
+			t.Fatalf("update %s: %v", id, err)
+		}
+	}
+	insertTestResource(t, p.db, poolID, "Succeeded")
+	insertTestResource(t, p.db, otherPoolID, "Succeeded")
+	setPoolProperties(otherPoolID, `{"replicas":1}`)
+
+	client, err := p.workloadClient(testClusterID)
+	if err != nil {
+		t.Fatalf("workload client: %v", err)
+	}
+	prefix := "np1-" + stableUUID(strings.ToLower(poolID))[:5] + "-"
+	listPool := func() []string {
+		t.Helper()
+		names, err := client.nodeNames(nodeNodePoolLabel + "=" + stableUUID(strings.ToLower(poolID)))
+		if err != nil {
+			t.Fatalf("list nodes: %v", err)
+		}
+		sort.Strings(names)
+		return names
+	}
+	reconcile := func(id string) {
+		t.Helper()
+		if err := p.reconcileNodes(id); err != nil {
+			t.Fatalf("reconcile %s: %v", id, err)
+		}
+	}
+
+	steps := []struct {
+		name       string
+		properties string
+		want       []string
+	}{
+		{name: "replicas", properties: `{"replicas":3,"version":{"id":"4.20.0"}}`, want: []string{prefix + "0", prefix + "1", prefix + "2"}},
+		{name: "scaled down", properties: `{"replicas":1}`, want: []string{prefix + "0"}},
+		{name: "autoscaling minimum", properties: `{"replicas":5,"autoScaling":{"min":2,"max":4}}`, want: []string{prefix + "0", prefix + "1"}},
+		{name: "no replicas", properties: `{}`},
+	}
+	reconcile(otherPoolID)
+	for _, step := range steps {
+		setPoolProperties(poolID, step.properties)
+		reconcile(poolID)
+		got := listPool()
+		if strings.Join(got, ",") != strings.Join(step.want, ",") {
+			t.Errorf("%s: pool lists nodes %v, want %v", step.name, got, step.want)
+		}
+		for _, name := range step.want {
+			if status := api.readyStatus(name); status != "True" {
+				t.Errorf("%s: node %s is Ready=%q, want True", step.name, name, status)
+			}
+		}
+		// The Leases go with their nodes
+		if leases := len(api.leases); leases != len(step.want)+1 {
+			t.Errorf("%s: %d leases for %d nodes", step.name, leases, len(step.want)+1)
+		}
+	}
+
+	// The cluster selector lists the nodes of all its pools
+	setPoolProperties(poolID, `{"replicas":2}`)
+	reconcile(poolID)
+	names, err := client.nodeNames(nodeClusterLabel + "=" + stableUUID(strings.ToLower(testClusterID)))
+	if err != nil || len(names) != 3 {
+		t.Fatalf("cluster lists nodes %v (%v), want 3", names, err)
+	}
+
+	// A node the node lifecycle controller gave up on is Ready again after
+	// the heartbeat
+	api.mu.Lock()
+	api.nodes[prefix+"0"]["status"] = map[string]interface{}{"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "Unknown"}}}
+	api.mu.Unlock()
+	if err := p.heartbeatNodes(poolID); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if status := api.readyStatus(prefix + "0"); status != "True" {
+		t.Errorf("node is Ready=%q after the heartbeat, want True", status)
+	}
+
+	if err := p.removeNodes(poolID); err != nil {
+		t.Fatalf("remove the nodes of %s: %v", poolID, err)
+	}
+	if got := listPool(); len(got) != 0 {
+		t.Errorf("pool lists %v after removing its nodes", got)
+	}
+	if len(api.nodes) != 1 {
+		t.Errorf("%d nodes left, want the one of %s", len(api.nodes), otherPoolID)
+	}
+	if err := p.removeNodes(testClusterID); err != nil {
+		t.Fatalf("remove the nodes of %s: %v", testClusterID, err)
+	}
+	if len(api.nodes) != 0 || len(api.leases) != 0 {
+		t.Errorf("%d nodes and %d leases left after removing the cluster's", len(api.nodes), len(api.leases))
+	}
+}
+
+func TestNodeObject(t *testing.T) {
+	cluster := &Resource{ID: testClusterID, Name: "c1", SubscriptionID: "s1", Properties: `{}`}
+	pool := &Resource{ID: testClusterID + "/nodePools/np1", Name: "np1", Location: "eastus",
+		Properties: `{"version":{"id":"openshift-v4.19.7"},"platform":{"vmSize":"Standard_D8s_v3"}}`}
+
+	node := nodeObject(cluster, pool, "np1-abcde-0")
+	labels := node["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+	if labels["node.kubernetes.io/instance-type"] != "Standard_D8s_v3" || labels["topology.kubernetes.io/region"] != "eastus" ||
+		labels[nodeNodePoolLabel] != stableUUID(strings.ToLower(pool.ID)) || labels[nodeClusterLabel] != stableUUID(strings.ToLower(cluster.ID)) {
+		t.Errorf("labels %v", labels)
+	}
+	wantProviderID := "azure:///subscriptions/s1/resourceGroups/c1-managed/providers/Microsoft.Compute/virtualMachines/np1-abcde-0"
+	if providerID := node["spec"].(map[string]interface{})["providerID"]; providerID != wantProviderID {
+		t.Errorf("providerID %v, want %s", providerID, wantProviderID)
+	}
+	nodeInfo := node["status"].(map[string]interface{})["nodeInfo"].(map[string]interface{})
+	if nodeInfo["kubeletVersion"] != "v1.32.0" {
+		t.Errorf("kubeletVersion %v, want v1.32.0 for OpenShift 4.19", nodeInfo["kubeletVersion"])
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+var (
+	resourceGroupPathRE = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/?$`)
+	keyVaultPathRE      = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.KeyVault/vaults/[^/]+/?$`)
+	deletedVaultPathRE  = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/providers/Microsoft\.KeyVault/locations/[^/]+/deletedVaults/[^/]+/?$`)
+)
+
+// handleOffline serves non-ARO-HCP Azure requests (resource groups, key
+// vaults, network resources, managed identities and role assignments) from
+// the local SQLite store when OFFLINE_MODE is set, so the full ASO template
+// can run without Azure credentials or network access. Requests for
+// resource types the mock does not know about are rejected the same way ARM
+// rejects an unregistered resource provider.
+func (p *AROHCPMockProxyEnhanced) handleOffline(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	switch {
+	case resourceGroupPathRE.MatchString(path):
+		log.Println("  -> Routing to ResourceGroup Mock (SQLite)")
+		p.handleResourceGroup(w, r)
+	case keyVaultPathRE.MatchString(path), deletedVaultPathRE.MatchString(path):
+		log.Println("  -> Routing to KeyVault Mock (SQLite)")
+		p.handleKeyVault(w, r)
+	default:
+		if p.handleNetwork(w, r) || p.handleManagedIdentity(w, r) || p.handleRoleAssignment(w, r) {
+			return
+		}
+		log.Println("  -> No offline handler, rejecting")
+		writeCloudError(w, http.StatusBadRequest, &CloudErrorBody{
+			Code:    "NoRegisteredProviderFound",
+			Message: fmt.Sprintf("The mockup proxy is running in offline mode and has no handler for '%s'.", path),
+		})
+	}
+}
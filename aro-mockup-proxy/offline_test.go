@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testResourceGroupID = "/subscriptions/s1/resourceGroups/rg1"
+
+func newOfflineTestProxy(t *testing.T) *AROHCPMockProxyEnhanced {
+	return newTestProxy(t, func(c *Config) { c.OfflineMode = true })
+}
+
+// resourceIDs returns the IDs in the mock store, sorted
+func resourceIDs(t *testing.T, p *AROHCPMockProxyEnhanced) []string {
+	t.Helper()
+	rows, err := p.db.Query(`SELECT id FROM resources`)
+	if err != nil {
+		t.Fatalf("list resources: %v", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("list resources: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestOfflineResourceGroup(t *testing.T) {
+	p := newOfflineTestProxy(t)
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantTags   string
+	}{
+		{name: "get missing", method: "GET", path: testResourceGroupID, wantStatus: http.StatusNotFound, wantCode: "ResourceGroupNotFound"},
+		{name: "create", method: "PUT", path: testResourceGroupID, body: `{"location":"eastus","tags":{"a":"1"}}`, wantStatus: http.StatusCreated, wantTags: `{"a":"1"}`},
+		{name: "get", method: "GET", path: testResourceGroupID, wantStatus: http.StatusOK, wantTags: `{"a":"1"}`},
+		{name: "update", method: "PUT", path: testResourceGroupID, body: `{"location":"eastus","tags":{"a":"2"}}`, wantStatus: http.StatusCreated, wantTags: `{"a":"2"}`},
+		{name: "get updated", method: "GET", path: testResourceGroupID + "?api-version=2021-04-01", wantStatus: http.StatusOK, wantTags: `{"a":"2"}`},
+		{name: "bad body", method: "PUT", path: testResourceGroupID, body: `{`, wantStatus: http.StatusBadRequest, wantCode: "InvalidRequestContent"},
+		{name: "method", method: "POST", path: testResourceGroupID, wantStatus: http.StatusMethodNotAllowed, wantCode: "MethodNotAllowed"},
+		{name: "delete", method: "DELETE", path: testResourceGroupID, wantStatus: http.StatusNoContent},
+		{name: "get deleted", method: "GET", path: testResourceGroupID, wantStatus: http.StatusNotFound, wantCode: "ResourceGroupNotFound"},
+		{name: "delete missing", method: "DELETE", path: testResourceGroupID, wantStatus: http.StatusNotFound, wantCode: "ResourceGroupNotFound"},
+		{name: "unknown provider", method: "PUT", path: testResourceGroupID + "/providers/Microsoft.Storage/storageAccounts/sa1", body: `{}`, wantStatus: http.StatusBadRequest, wantCode: "NoRegisteredProviderFound"},
+	}
+
+	for _, step := range steps {
+		w := serve(p, step.method, step.path, step.body)
+		if w.Code != step.wantStatus {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.wantStatus, w.Body)
+		}
+		var response struct {
+			ID    string          `json:"id"`
+			Tags  json.RawMessage `json:"tags"`
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		if w.Body.Len() > 0 {
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("%s: decode %s: %v", step.name, w.Body, err)
+			}
+		}
+		if response.Error.Code != step.wantCode {
+			t.Errorf("%s: error code %q, want %q", step.name, response.Error.Code, step.wantCode)
+		}
+		if step.wantTags != "" && (response.ID != testResourceGroupID || string(response.Tags) != step.wantTags) {
+			t.Errorf("%s: resource %s with tags %s, want %s with %s", step.name, response.ID, response.Tags, testResourceGroupID, step.wantTags)
+		}
+	}
+}
+
+func TestDeleteResourceGroupCascade(t *testing.T) {
+	p := newOfflineTestProxy(t)
+	for _, rg := range []string{testResourceGroupID, "/subscriptions/s1/resourceGroups/rg2", "/subscriptions/s2/resourceGroups/rg1"} {
+		if w := serve(p, "PUT", rg, `{"location":"eastus"}`); w.Code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", rg, w.Code, w.Body)
+		}
+	}
+	kept := []string{
+		"/subscriptions/s1/resourceGroups/rg2",
+		"/subscriptions/s1/resourceGroups/rg2/providers/Microsoft.Network/virtualNetworks/v1",
+		"/subscriptions/s2/resourceGroups/rg1",
+		"/subscriptions/s2/resourceGroups/rg1/providers/Microsoft.Network/virtualNetworks/v1",
+	}
+	for _, id := range append([]string{
+		testClusterID,
+		testClusterID + "/nodePools/np1",
+		testResourceGroupID + "/providers/Microsoft.Network/virtualNetworks/v1",
+		// Resource group names are case-insensitive
+		"/subscriptions/s1/resourceGroups/RG1/providers/Microsoft.Network/networkSecurityGroups/n1",
+	}, kept[1], kept[3]) {
+		insertTestResource(t, p.db, id, "Succeeded")
+	}
+
+	if w := serve(p, "DELETE", testResourceGroupID, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if got := resourceIDs(t, p); strings.Join(got, "\n") != strings.Join(kept, "\n") {
+		t.Errorf("after the delete the store holds %v, want %v", got, kept)
+	}
+
+	// Without the resource group row nothing is deleted
+	insertTestResource(t, p.db, testResourceGroupID+"/providers/Microsoft.Network/virtualNetworks/orphan", "Succeeded")
+	if w := serve(p, "DELETE", testResourceGroupID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("delete missing: %d %s", w.Code, w.Body)
+	}
+	if got := len(resourceIDs(t, p)); got != len(kept)+1 {
+		t.Errorf("%d resources after deleting a missing resource group, want %d", got, len(kept)+1)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Long-running operations are polled through the same location-scoped
+// URLs the ARO-HCP frontend hands out, so clients take the same poller path
+// whether DEV_ENDPOINT is set or not:
+//
+//	/subscriptions/{sub}/providers/Microsoft.RedHatOpenShift/locations/{loc}/hcpOperationStatuses/{id}
+//	/subscriptions/{sub}/providers/Microsoft.RedHatOpenShift/locations/{loc}/hcpOperationResults/{id}
+//
+// The legacy /operations/{id} URLs are still served for operations started
+// by earlier versions.
+const (
+	operationStatusesType = "hcpOperationStatuses"
+	operationResultsType  = "hcpOperationResults"
+)
+
+// isOperationPollRequest reports whether path is the status or result URL
+// of an operation, which clients poll with GET
+func isOperationPollRequest(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.Contains(lower, "/operations/") ||
+		strings.Contains(lower, "/"+strings.ToLower(operationStatusesType)+"/") ||
+		strings.Contains(lower, "/"+strings.ToLower(operationResultsType)+"/")
+}
+
+// operationURL returns the hcpOperationStatuses or hcpOperationResults URL
+// of op, scoped to the location of the resource it runs on
+func (p *AROHCPMockProxyEnhanced) operationURL(r *http.Request, op *AsyncOperation, kind string) string {
+	path := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.RedHatOpenShift/locations/%s/%s/%s",
+		subscriptionOf(op.ResourceID), strings.ToLower(p.operationLocation(op.ResourceID)), kind, op.ID)
+	query := url.Values{}
+	query.Set("api-version", r.URL.Query().Get("api-version"))
+	return p.baseURL(r) + path + "?" + query.Encode()
+}
+
+// operationLocation returns the location of a resource. Node pools and
+// external auths may be created without one; theirs is their cluster's.
+// Operations are looked up by ID alone, so a resource without any location
+// is polled under "global" rather than an invalid empty segment.
+func (p *AROHCPMockProxyEnhanced) operationLocation(resourceID string) string {
+	id := resourceID
+	for {
+		if resource, err := p.getResource(id); err == nil && resource.Location != "" {
+			return resource.Location
+		}
+		// A child resource ID is its parent's plus /{type}/{name}
+		parts := splitPath(id)
+		if len(parts) <= 8 {
+			return "global"
+		}
+		id = "/" + strings.Join(parts[:len(parts)-2], "/")
+	}
+}
+
+// subscriptionOf returns the subscription ID of an ARM resource ID
+func subscriptionOf(resourceID string) string {
+	parts := splitPath(resourceID)
+	if len(parts) < 2 || !strings.EqualFold(parts[0], "subscriptions") {
+		return ""
+	}
+	return parts[1]
+}
+
+// lookupOperation returns the operation a status or result URL points at,
+// answering 404 when it does not exist or belongs to another subscription
+func (p *AROHCPMockProxyEnhanced) lookupOperation(w http.ResponseWriter, r *http.Request, parsed *ARMPath) *AsyncOperation {
+	if r.Method != "GET" {
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+		return nil
+	}
+	op, err := p.asyncOps.GetOperation(parsed.ResourceName)
+	if err == nil && strings.EqualFold(subscriptionOf(op.ResourceID), parsed.SubscriptionID) {
+		return op
+	}
+	writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+		Code:    "NotFound",
+		Message: fmt.Sprintf("The operation '%s' was not found.", parsed.ResourceName),
+		Target:  parsed.ResourceName,
+	})
+	return nil
+}
+
+// operationStatusState returns the status the frontend reports for op:
+// the provisioning state it moves the resource through while it runs, then
+// the terminal state
+func operationStatusState(op *AsyncOperation) string {
+	if op.Status != "InProgress" {
+		return op.Status
+	}
+	switch op.OperationType {
+	case "Create":
+		return "Provisioning"
+	case "Update":
+		return "Updating"
+	case "Delete":
+		return "Deleting"
+	}
+	return "Accepted"
+}
+
+// handleOperationStatus serves hcpOperationStatuses/{id} in the ARM
+// operation status schema. It is 200 whatever the state of the operation.
+func (p *AROHCPMockProxyEnhanced) handleOperationStatus(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
+	op := p.lookupOperation(w, r, parsed)
+	if op == nil {
+		return
+	}
+
+	op.mu.RLock()
+	response := map[string]interface{}{
+		"id": fmt.Sprintf("/subscriptions/%s/providers/Microsoft.RedHatOpenShift/locations/%s/%s/%s",
+			parsed.SubscriptionID, parsed.Location, operationStatusesType, op.ID),
+		"name":      op.ID,
+		"status":    operationStatusState(op),
+		"startTime": op.StartTime.UTC().Format(time.RFC3339Nano),
+	}
+	if op.EndTime != nil {
+		response["endTime"] = op.EndTime.UTC().Format(time.RFC3339Nano)
+	}
+	if op.Status == "InProgress" {
+		response["percentComplete"] = float64(op.PercentComplete)
+	}
+	if op.Error != nil {
+		response["error"] = op.Error
+	}
+	op.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleOperationResult serves hcpOperationResults/{id}: 202 while the
+// operation runs, then its outcome. A successful delete has no content, a
+// create or update returns the resource and an action the result of that
+// very operation, until it expires after OperationResultTTL, see
+// expireResults.
+func (p *AROHCPMockProxyEnhanced) handleOperationResult(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
+	op := p.lookupOperation(w, r, parsed)
+	if op == nil {
+		return
+	}
+
+	p.asyncOps.expireResult(op)
+	op.mu.RLock()
+	status, operationType, result, oerr := op.Status, op.OperationType, op.Result, op.Error
+	op.mu.RUnlock()
+
+	switch status {
+	case "InProgress":
+		w.Header().Set("Location", p.baseURL(r)+r.URL.RequestURI())
+		w.Header().Set("Retry-After", p.clock.retryAfter(p.config.PollingInterval))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case "Failed", "Canceled":
+		if oerr == nil {
+			oerr = &OperationError{
+				Code:    status,
+				Message: fmt.Sprintf("The operation '%s' was %s.", op.ID, strings.ToLower(status)),
+			}
+		}
+		writeCloudError(w, http.StatusInternalServerError, oerr)
+		return
+	}
+
+	switch operationType {
+	case "Delete":
+		w.WriteHeader(http.StatusNoContent)
+	case "Create", "Update":
+		resource, err := p.getResource(op.ResourceID)
+		if err != nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("ETag", formatETag(resource.ETag))
+		writeJSON(w, http.StatusOK, p.buildResourceResponse(resource))
+	default:
+		if result == nil {
+			writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+				Code:    "NotFound",
+				Message: fmt.Sprintf("The result of the operation '%s' has expired.", op.ID),
+				Target:  op.ID,
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pollPath returns the path and query of a polling URL, to send it
+// through the proxy
+func pollPath(t *testing.T, link string) string {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil || u.Path == "" {
+		t.Fatalf("polling URL %q: %v", link, err)
+	}
+	return u.RequestURI()
+}
+
+func TestPollChildOperation(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		body         string
+		wantLocation string
+	}{
+		{name: "cluster", path: testClusterID, body: `{"location":"EastUS2","properties":{}}`, wantLocation: "eastus2"},
+		{name: "node pool without a location", path: testClusterID + "/nodePools/np1", body: `{"properties":{}}`, wantLocation: "eastus"},
+		{name: "external auth without a location", path: testClusterID + "/externalAuths/ea1", body: `{"properties":{}}`, wantLocation: "eastus"},
+		{name: "node pool with a location", path: testClusterID + "/nodePools/np1", body: `{"location":"westus","properties":{}}`, wantLocation: "westus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, func(c *Config) {
+				c.EnableAsyncOperations = true
+				c.ProvisioningDelay = 50 * time.Millisecond
+			})
+			if tt.path != testClusterID {
+				insertTestResource(t, p.db, testClusterID, "Succeeded")
+			}
+
+			w := serve(p, "PUT", tt.path+testAPIVersion, tt.body)
+			if w.Code != http.StatusCreated && w.Code != http.StatusAccepted {
+				t.Fatalf("PUT: status %d, want an async create: %s", w.Code, w.Body)
+			}
+			statusPath := pollPath(t, w.Header().Get("Azure-AsyncOperation"))
+			if !strings.Contains(statusPath, "/locations/"+tt.wantLocation+"/") {
+				t.Errorf("polling URL %s, want it in location %s", statusPath, tt.wantLocation)
+			}
+
+			status := func() string {
+				t.Helper()
+				w := serve(p, "GET", statusPath, "")
+				if w.Code != http.StatusOK {
+					t.Fatalf("GET %s: status %d, want 200: %s", statusPath, w.Code, w.Body)
+				}
+				var response struct {
+					ID     string `json:"id"`
+					Status string `json:"status"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &response)
+				if !strings.Contains(response.ID, "/locations/"+tt.wantLocation+"/") {
+					t.Errorf("operation status ID %s, want it in location %s", response.ID, tt.wantLocation)
+				}
+				return response.Status
+			}
+			eventually(t, "the operation to succeed", func() bool { return status() == "Succeeded" })
+
+			// The Location of a create is the resource itself
+			result := mustServe(t, p, "GET", pollPath(t, w.Header().Get("Location")), "", http.StatusOK)
+			if result["id"] != tt.path {
+				t.Errorf("result %v, want %s", result["id"], tt.path)
+			}
+
+			// The Location of a delete is the operation result, in the same
+			// location and still valid once the resource is gone
+			w = serve(p, "DELETE", tt.path+testAPIVersion, "")
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("DELETE: status %d, want 202: %s", w.Code, w.Body)
+			}
+			resultPath := pollPath(t, w.Header().Get("Location"))
+			if !strings.Contains(resultPath, "/locations/"+tt.wantLocation+"/"+operationResultsType+"/") {
+				t.Errorf("result URL %s, want it in location %s", resultPath, tt.wantLocation)
+			}
+			eventually(t, "the delete to finish", func() bool {
+				w := serve(p, "GET", resultPath, "")
+				if w.Code != http.StatusAccepted && w.Code != http.StatusNoContent {
+					t.Fatalf("GET %s: status %d: %s", resultPath, w.Code, w.Body)
+				}
+				return w.Code == http.StatusNoContent
+			})
+		})
+	}
+}
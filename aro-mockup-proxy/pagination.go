@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// maxPageSize caps $top
+const maxPageSize = 1000
+
+// skipToken is the decoded form of a $skipToken. Pages are keyed by the
+// last resource ID served rather than an offset, so rows added or removed
+// between requests do not shift later pages.
+type skipToken struct {
+	Version int    `json:"v"`
+	After   string `json:"after"`
+}
+
+func encodeSkipToken(lastID string) string {
+	data, _ := json.Marshal(skipToken{Version: 1, After: lastID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSkipToken(token string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", err
+	}
+	var t skipToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return "", err
+	}
+	if t.Version != 1 || t.After == "" {
+		return "", fmt.Errorf("unsupported skip token")
+	}
+	return t.After, nil
+}
+
+// listPage returns the page size and the resource ID to continue after for
+// a list request, from $top (capped at the configured page size) and
+// $skipToken
+func (p *AROHCPMockProxyEnhanced) listPage(r *http.Request) (int, string, *CloudErrorBody) {
+	pageSize := p.config.ListPageSize
+	if top := r.URL.Query().Get("$top"); top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil || n < 1 || n > maxPageSize {
+			return 0, "", &CloudErrorBody{
+				Code:    "InvalidParameter",
+				Message: fmt.Sprintf("The value '%s' of $top is invalid. It must be an integer between 1 and %d.", top, maxPageSize),
+				Target:  "$top",
+			}
+		}
+		if n < pageSize {
+			pageSize = n
+		}
+	}
+
+	var after string
+	if token := r.URL.Query().Get("$skipToken"); token != "" {
+		var err error
+		if after, err = decodeSkipToken(token); err != nil {
+			return 0, "", &CloudErrorBody{
+				Code:    "InvalidParameter",
+				Message: "The $skipToken is invalid.",
+				Target:  "$skipToken",
+			}
+		}
+	}
+	return pageSize, after, nil
+}
+
+// nextLink returns the URL of the page following lastID, keeping the
+// api-version and $top of the request
+func (p *AROHCPMockProxyEnhanced) nextLink(r *http.Request, lastID string) string {
+	query := url.Values{}
+	query.Set("api-version", r.URL.Query().Get("api-version"))
+	if top := r.URL.Query().Get("$top"); top != "" {
+		query.Set("$top", top)
+	}
+	query.Set("$skipToken", encodeSkipToken(lastID))
+	return p.baseURL(r) + r.URL.Path + "?" + query.Encode()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSkipToken(t *testing.T) {
+	if after, err := decodeSkipToken(encodeSkipToken(testClusterID)); err != nil || after != testClusterID {
+		t.Fatalf("round trip = %q, %v, want %q", after, err, testClusterID)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "not base64", token: "!!"},
+		{name: "not JSON", token: base64.RawURLEncoding.EncodeToString([]byte("after"))},
+		{name: "other version", token: base64.RawURLEncoding.EncodeToString([]byte(`{"v":2,"after":"x"}`))},
+		{name: "empty position", token: base64.RawURLEncoding.EncodeToString([]byte(`{"v":1}`))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if after, err := decodeSkipToken(tt.token); err == nil {
+				t.Errorf("decodeSkipToken(%s) = %q, want an error", tt.token, after)
+			}
+		})
+	}
+}
+
+func TestListPage(t *testing.T) {
+	p := &AROHCPMockProxyEnhanced{config: &Config{ListPageSize: 100}}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantSize   int
+		wantAfter  string
+		wantTarget string
+	}{
+		{name: "default", wantSize: 100},
+		{name: "smaller $top", query: "$top=10", wantSize: 10},
+		{name: "larger $top is capped", query: "$top=500", wantSize: 100},
+		{name: "skip token", query: "$skipToken=" + encodeSkipToken("x"), wantSize: 100, wantAfter: "x"},
+		{name: "zero $top", query: "$top=0", wantTarget: "$top"},
+		{name: "$top over the maximum", query: fmt.Sprintf("$top=%d", maxPageSize+1), wantTarget: "$top"},
+		{name: "$top not a number", query: "$top=ten", wantTarget: "$top"},
+		{name: "bad skip token", query: "$skipToken=garbage", wantTarget: "$skipToken"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, after, perr := p.listPage(httptest.NewRequest("GET", "/x?"+tt.query, nil))
+			if tt.wantTarget != "" {
+				if perr == nil || perr.Target != tt.wantTarget {
+					t.Fatalf("error %+v, want one on %s", perr, tt.wantTarget)
+				}
+				return
+			}
+			if perr != nil {
+				t.Fatalf("unexpected error %+v", perr)
+			}
+			if size != tt.wantSize || after != tt.wantAfter {
+				t.Errorf("page %d after %q, want %d after %q", size, after, tt.wantSize, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	p := &AROHCPMockProxyEnhanced{config: &Config{}}
+	r := httptest.NewRequest("GET", "http://proxy.example/subscriptions/s1/providers/Microsoft.RedHatOpenShift/hcpOpenShiftClusters?api-version=2024-06-10-preview&$top=5&$filter=x", nil)
+
+	link, err := url.Parse(p.nextLink(r, testClusterID))
+	if err != nil {
+		t.Fatalf("parse nextLink: %v", err)
+	}
+	if link.Host != "proxy.example" || link.Path != r.URL.Path {
+		t.Errorf("nextLink %s, want the request URL", link)
+	}
+	query := link.Query()
+	if query.Get("api-version") != "2024-06-10-preview" || query.Get("$top") != "5" || query.Has("$filter") {
+		t.Errorf("nextLink query %v, want api-version and $top only besides the token", query)
+	}
+	if after, err := decodeSkipToken(query.Get("$skipToken")); err != nil || after != testClusterID {
+		t.Errorf("nextLink continues after %q (%v), want %q", after, err, testClusterID)
+	}
+}
+
+func TestHandleListPages(t *testing.T) {
+	db := newTestDB(t)
+	p := &AROHCPMockProxyEnhanced{config: &Config{ListPageSize: 2}, db: db}
+	var want []string
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("/subscriptions/s1/resourceGroups/rg1/providers/Microsoft.RedHatOpenShift/hcpOpenShiftClusters/c%d", i)
+		insertTestResource(t, db, id, "Succeeded")
+		want = append(want, id)
+	}
+	parsed := &ARMPath{SubscriptionID: "s1", ResourceGroup: "rg1", ResourceType: "hcpOpenShiftClusters"}
+
+	var got []string
+	pages := 0
+	link := "http://proxy.example/subscriptions/s1/resourceGroups/rg1/providers/Microsoft.RedHatOpenShift/hcpOpenShiftClusters?api-version=2024-06-10-preview"
+	for link != "" {
+		pages++
+		if pages > 5 {
+			t.Fatalf("more pages than resources")
+		}
+		w := httptest.NewRecorder()
+		p.handleList(w, httptest.NewRequest("GET", link, nil), parsed)
+		var page struct {
+			Value []struct {
+				ID string `json:"id"`
+			} `json:"value"`
+			NextLink string `json:"nextLink"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decode page %d: %v: %s", pages, err, w.Body)
+		}
+		for _, resource := range page.Value {
+			got = append(got, resource.ID)
+		}
+		link = page.NextLink
+
+		// A resource added behind the current page does not shift the next one
+		if pages == 1 {
+			insertTestResource(t, db, want[0]+"a", "Succeeded")
+		}
+	}
+
+	if pages != 3 || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("listed %v in %d pages, want %v in 3", got, pages, want)
+	}
+}
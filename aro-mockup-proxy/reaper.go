@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// finished reports whether the operation has reached a terminal state, e.g.
+// because it timed out while its processing goroutine was still running
+func (m *AsyncOperationManager) finished(op *AsyncOperation) bool {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.Status != "InProgress"
+}
+
+// StartReaper runs the operation reaper in the background. Every polling
+// interval of virtual time it fails the operations that have run longer
+// than AsyncOperationTimeout, drops the action results older than
+// OperationResultTTL and removes the finished operations that ended more
+// than OperationRetention ago, from memory and from the database. A zero
+// timeout or retention disables that part.
+func (m *AsyncOperationManager) StartReaper() {
+	interval := m.config.PollingInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	go func() {
+		for {
+			m.clock.Sleep(interval)
+			m.timeOutOperations()
+			m.expireResults()
+			m.reapOperations()
+		}
+	}()
+}
+
+// timeOutOperations fails the InProgress operations older than
+// AsyncOperationTimeout with OperationTimedOut, the way ARM gives up on a
+// provider, and leaves their resources Failed
+func (m *AsyncOperationManager) timeOutOperations() {
+	timeout := m.config.AsyncOperationTimeout
+	if timeout <= 0 {
+		return
+	}
+
+	var expired []*AsyncOperation
+	m.mu.RLock()
+	for _, op := range m.operations {
+		op.mu.RLock()
+		if op.Status == "InProgress" && m.clock.Since(op.StartTime) > timeout {
+			expired = append(expired, op)
+		}
+		op.mu.RUnlock()
+	}
+	m.mu.RUnlock()
+
+	for _, op := range expired {
+		log.Printf("Operation %s: timed out after %s", op.ID, timeout)
+		if m.db != nil && op.OperationType != "RequestAdminCredential" {
+			m.db.Exec("UPDATE resources SET provisioning_state = 'Failed' WHERE id = ? AND (provisioning_state != 'Deleting' OR ? = 'Delete')",
+				op.ResourceID, op.OperationType)
+		}
+		m.failOperation(op, "OperationTimedOut", fmt.Sprintf("The operation did not complete within %s.", timeout))
+	}
+}
+
+// expireResults drops the results of the actions that finished more than
+// OperationResultTTL ago, so the kubeconfigs and keys of
+// requestAdminCredential do not linger whether or not they were fetched,
+// and however long the operations themselves are kept. Those results are
+// never persisted, so only the operations in memory hold any.
+func (m *AsyncOperationManager) expireResults() {
+	var ops []*AsyncOperation
+	m.mu.RLock()
+	for _, op := range m.operations {
+		ops = append(ops, op)
+	}
+	m.mu.RUnlock()
+	for _, op := range ops {
+		m.expireResult(op)
+	}
+}
+
+// expireResult drops the result of op once it finished more than
+// OperationResultTTL ago
+func (m *AsyncOperationManager) expireResult(op *AsyncOperation) {
+	op.mu.Lock()
+	expired := op.Result != nil && op.EndTime != nil && m.clock.Since(*op.EndTime) > m.config.OperationResultTTL
+	if expired {
+		op.Result = nil
+	}
+	op.mu.Unlock()
+	if expired {
+		log.Printf("Result of operation %s expired", op.ID)
+		m.saveOperation(op)
+	}
+}
+
+// reapOperations removes the operations that finished more than
+// OperationRetention ago. Their status and result URLs return 404 from
+// then on.
+func (m *AsyncOperationManager) reapOperations() {
+	retention := m.config.OperationRetention
+	if retention <= 0 {
+		return
+	}
+	cutoff := m.clock.Now().Add(-retention)
+
+	reaped := map[string]bool{}
+	m.mu.Lock()
+	for id, op := range m.operations {
+		op.mu.RLock()
+		if op.Status != "InProgress" && op.EndTime != nil && op.EndTime.Before(cutoff) {
+			reaped[id] = true
+			delete(m.operations, id)
+		}
+		op.mu.RUnlock()
+	}
+	m.mu.Unlock()
+
+	// Operations finished before a restart are only in the database
+	if m.db != nil {
+		rows, err := m.db.Query(`SELECT id, end_time FROM operations WHERE status != 'InProgress' AND end_time IS NOT NULL`)
+		if err != nil {
+			log.Printf("Failed to list finished operations: %v", err)
+		} else {
+			for rows.Next() {
+				var id string
+				var endTime time.Time
+				if err := rows.Scan(&id, &endTime); err != nil {
+					continue
+				}
+				if endTime.Before(cutoff) {
+					reaped[id] = true
+				}
+			}
+			rows.Close()
+		}
+		for id := range reaped {
+			if _, err := m.db.Exec(`DELETE FROM operations WHERE id = ?`, id); err != nil {
+				log.Printf("Failed to delete operation %s: %v", id, err)
+			}
+		}
+	}
+
+	if len(reaped) > 0 {
+		log.Printf("Reaped %d operation(s) finished before %s", len(reaped), cutoff.UTC().Format(time.RFC3339))
+		m.metrics.OperationsReaped(len(reaped))
+	}
+}
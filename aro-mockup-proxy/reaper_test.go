@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reaperTick runs one pass of the reaper
+func reaperTick(m *AsyncOperationManager) {
+	m.timeOutOperations()
+	m.expireResults()
+	m.reapOperations()
+}
+
+func TestExpireResults(t *testing.T) {
+	for _, retention := range []time.Duration{0, 24 * time.Hour} {
+		t.Run("retention "+retention.String(), func(t *testing.T) {
+			p := newTestProxy(t, func(c *Config) {
+				c.EnableAsyncOperations = true
+				c.ProvisioningDelay = 10 * time.Millisecond
+				c.OperationRetention = retention
+			})
+			insertTestResource(t, p.db, testClusterID, "Succeeded")
+
+			w := serve(p, "POST", testClusterID+"/requestAdminCredential"+testAPIVersion, "")
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("requestAdminCredential: status %d, want 202: %s", w.Code, w.Body)
+			}
+			statusPath := pollPath(t, w.Header().Get("Azure-AsyncOperation"))
+			resultPath := pollPath(t, w.Header().Get("Location"))
+			operationID := path.Base(strings.Split(resultPath, "?")[0])
+
+			eventually(t, "the credential", func() bool { return serve(p, "GET", resultPath, "").Code == http.StatusOK })
+			result := mustServe(t, p, "GET", resultPath, "", http.StatusOK)
+			if kubeconfig, _ := result["kubeconfig"].(string); !strings.Contains(kubeconfig, "client-key-data") {
+				t.Fatalf("result %v, want a kubeconfig with the client key", result)
+			}
+
+			// Within the TTL the reaper keeps the result
+			reaperTick(p.asyncOps)
+			mustServe(t, p, "GET", resultPath, "", http.StatusOK)
+
+			// Past it the reaper drops the result without anyone polling it
+			p.clock.update(p.config.OperationResultTTL+time.Minute, 1)
+			reaperTick(p.asyncOps)
+			op, err := p.asyncOps.GetOperation(operationID)
+			if err != nil {
+				t.Fatalf("operation %s: %v", operationID, err)
+			}
+			op.mu.RLock()
+			held := op.Result
+			op.mu.RUnlock()
+			if held != nil {
+				t.Errorf("operation holds its result past the TTL: %v", held)
+			}
+
+			response := mustServe(t, p, "GET", resultPath, "", http.StatusNotFound)
+			cloudError, _ := response["error"].(map[string]interface{})
+			if message, _ := cloudError["message"].(string); !strings.Contains(message, "has expired") {
+				t.Errorf("result past the TTL: %v, want it expired", response)
+			}
+			if status := mustServe(t, p, "GET", statusPath, "", http.StatusOK); status["status"] != "Succeeded" {
+				t.Errorf("operation status %v after the result expired, want Succeeded", status["status"])
+			}
+		})
+	}
+}
+
+func TestTimeOutOperations(t *testing.T) {
+	poolID := testClusterID + "/nodePools/np1"
+	authID := testClusterID + "/externalAuths/ea1"
+
+	for _, timeout := range []time.Duration{0, 5 * time.Minute} {
+		t.Run("timeout "+timeout.String(), func(t *testing.T) {
+			db := newTestDB(t)
+			// A stopped virtual clock only moves when the test advances it
+			m := NewAsyncOperationManager(&Config{ClockSpeed: 0, AsyncOperationTimeout: timeout}, db)
+			insertTestResource(t, db, testClusterID, "Provisioning")
+			insertTestResource(t, db, poolID, "Deleting")
+			insertTestResource(t, db, authID, "Deleting")
+
+			create := m.newOperation(testClusterID, "Create", nil)
+			deletion := m.newOperation(poolID, "Delete", nil)
+			// An update overtaken by a delete leaves the resource Deleting
+			update := m.newOperation(authID, "Update", nil)
+			credential := m.newOperation(testClusterID, "RequestAdminCredential", map[string]interface{}{})
+
+			m.clock.update(4*time.Minute, 0)
+			reaperTick(m)
+			recent := m.newOperation(testClusterID+"x", "Create", nil)
+			for _, op := range []*AsyncOperation{create, deletion, update, credential} {
+				if op.Status != "InProgress" {
+					t.Errorf("%s %s is %s within the timeout", op.OperationType, op.ResourceID, op.Status)
+				}
+			}
+
+			m.clock.update(2*time.Minute, 0)
+			reaperTick(m)
+			wantStatus := "Failed"
+			if timeout == 0 {
+				wantStatus = "InProgress"
+			}
+			for _, op := range []*AsyncOperation{create, deletion, update, credential} {
+				if op.Status != wantStatus {
+					t.Errorf("%s %s is %s past the timeout, want %s", op.OperationType, op.ResourceID, op.Status, wantStatus)
+					continue
+				}
+				if timeout == 0 {
+					continue
+				}
+				if op.Error == nil || op.Error.Code != "OperationTimedOut" {
+					t.Errorf("%s %s failed with %+v, want OperationTimedOut", op.OperationType, op.ResourceID, op.Error)
+				}
+				if stored, err := m.loadOperation(op.ID); err != nil || stored.Status != "Failed" {
+					t.Errorf("stored %s %s: %+v, %v, want Failed", op.OperationType, op.ResourceID, stored, err)
+				}
+			}
+			if recent.Status != "InProgress" {
+				t.Errorf("operation started 2m ago is %s, want InProgress", recent.Status)
+			}
+
+			wantStates := map[string]string{testClusterID: "Failed", poolID: "Failed", authID: "Deleting"}
+			if timeout == 0 {
+				wantStates = map[string]string{testClusterID: "Provisioning", poolID: "Deleting", authID: "Deleting"}
+			}
+			for id, want := range wantStates {
+				if state := resourceState(t, db, id); state != want {
+					t.Errorf("%s is %s, want %s", id, state, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReapOperations(t *testing.T) {
+	for _, retention := range []time.Duration{0, time.Hour} {
+		t.Run("retention "+retention.String(), func(t *testing.T) {
+			db := newTestDB(t)
+			m := NewAsyncOperationManager(&Config{ClockSpeed: 0, OperationRetention: retention}, db)
+
+			old := m.newOperation(testClusterID, "Create", nil)
+			m.finishOperation(old, "Succeeded", nil)
+			running := m.newOperation(testClusterID+"x", "Create", nil)
+			// Finished before a restart, so only in the database
+			end := m.clock.Now()
+			m.saveOperation(&AsyncOperation{ID: "op-stored", ResourceID: testClusterID, OperationType: "Update",
+				Status: "Succeeded", StartTime: end, EndTime: &end})
+
+			m.clock.update(50*time.Minute, 0)
+			recent := m.newOperation(testClusterID+"y", "Create", nil)
+			m.finishOperation(recent, "Failed", &OperationError{Code: "InternalServerError"})
+
+			m.clock.update(11*time.Minute, 0)
+			reaperTick(m)
+
+			wantReaped := map[string]bool{old.ID: retention > 0, "op-stored": retention > 0, running.ID: false, recent.ID: false}
+			for id, reaped := range wantReaped {
+				_, err := m.GetOperation(id)
+				if (err != nil) != reaped {
+					t.Errorf("operation %s: lookup error %v, want reaped %v", id, err, reaped)
+				}
+				var stored int
+				db.QueryRow(`SELECT COUNT(*) FROM operations WHERE id = ?`, id).Scan(&stored)
+				if (stored == 0) != reaped {
+					t.Errorf("operation %s: %d rows in the database, want reaped %v", id, stored, reaped)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// roleAssignmentPathRE matches role assignments at subscription or resource
+// group scope or below, e.g. on a subnet, NSG, key vault or user-assigned
+// identity
+var roleAssignmentPathRE = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)(?:/resourceGroups/([^/]+)((?:/providers/.+)?))?/providers/Microsoft\.Authorization/roleAssignments/([^/]+)/?$`)
+
+var subscriptionScopeRE = regexp.MustCompile(`(?i)^/subscriptions/[^/]+$`)
+
+var roleAssignmentKind = &armResourceKind{
+	ResourceType: "RoleAssignment",
+	ARMType:      "Microsoft.Authorization/roleAssignments",
+	validate: func(p *AROHCPMockProxyEnhanced, ref armResourceRef, body map[string]interface{}) *CloudErrorBody {
+		scope := roleAssignmentScope(ref.ID)
+		if !p.scopeExists(scope) {
+			return &CloudErrorBody{
+				Code:    "ParentResourceNotFound",
+				Message: fmt.Sprintf("Failed to perform 'write' on resource(s) of type 'roleAssignments', because the parent resource '%s' could not be found.", scope),
+			}
+		}
+
+		props, _ := body["properties"].(map[string]interface{})
+		roleDefinitionID, _ := props["roleDefinitionId"].(string)
+		principalID, _ := props["principalId"].(string)
+		if roleDefinitionID == "" || principalID == "" {
+			return &CloudErrorBody{
+				Code:    "InvalidRoleAssignmentRequest",
+				Message: "The role assignment request must specify both 'properties.roleDefinitionId' and 'properties.principalId'.",
+			}
+		}
+
+		// ARM refuses a second assignment of the same role to the same
+		// principal at the same scope under a different name
+		for _, existing := range p.roleAssignmentsFor(principalID) {
+			if strings.EqualFold(existing.ID, ref.ID) {
+				continue
+			}
+			if strings.EqualFold(existing.scope, scope) && roleDefinitionGUID(existing.roleDefinitionID) == roleDefinitionGUID(roleDefinitionID) {
+				return &CloudErrorBody{
+					Code:    "RoleAssignmentExists",
+					Message: fmt.Sprintf("The role assignment already exists. The ID of the existing role assignment is %s.", existing.ID[strings.LastIndex(existing.ID, "/")+1:]),
+				}
+			}
+		}
+		return nil
+	},
+	decorate: func(p *AROHCPMockProxyEnhanced, res *Resource, response map[string]interface{}) {
+		props := response["properties"].(map[string]interface{})
+		props["scope"] = roleAssignmentScope(res.ID)
+		if _, ok := props["principalType"]; !ok {
+			props["principalType"] = "ServicePrincipal"
+		}
+		// roleAssignments do not report a provisioning state
+		delete(props, "provisioningState")
+	},
+}
+
+// handleRoleAssignment serves Microsoft.Authorization/roleAssignments in
+// offline mode. It returns false if the path is not a role assignment.
+func (p *AROHCPMockProxyEnhanced) handleRoleAssignment(w http.ResponseWriter, r *http.Request) bool {
+	m := roleAssignmentPathRE.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		return false
+	}
+
+	scope := "/subscriptions/" + m[1]
+	if m[2] != "" {
+		scope += "/resourceGroups/" + m[2] + m[3]
+	}
+
+	log.Println("  -> Routing to RoleAssignment Mock (SQLite)")
+	p.handleARMResource(w, r, roleAssignmentKind, armResourceRef{
+		SubscriptionID: m[1],
+		ResourceGroup:  m[2],
+		ID:             scope + "/providers/Microsoft.Authorization/roleAssignments/" + m[4],
+		Name:           m[4],
+	})
+	return true
+}
+
+// roleAssignmentScope returns the resource ID a role assignment applies to
+func roleAssignmentScope(roleAssignmentID string) string {
+	idx := strings.LastIndex(strings.ToLower(roleAssignmentID), "/providers/microsoft.authorization/roleassignments/")
+	if idx < 0 {
+		return ""
+	}
+	return roleAssignmentID[:idx]
+}
+
+// roleDefinitionGUID returns the trailing GUID of a role definition ID, so
+// subscription-scoped and tenant-scoped IDs of the same role compare equal
+func roleDefinitionGUID(roleDefinitionID string) string {
+	return strings.ToLower(roleDefinitionID[strings.LastIndex(roleDefinitionID, "/")+1:])
+}
+
+// scopeExists reports whether a role assignment scope is a subscription, or
+// a stored resource group or resource of any type. Every subscription exists
+// in offline mode.
+func (p *AROHCPMockProxyEnhanced) scopeExists(scope string) bool {
+	if subscriptionScopeRE.MatchString(scope) {
+		return true
+	}
+	var count int
+	err := p.db.QueryRow("SELECT COUNT(*) FROM resources WHERE id = ? COLLATE NOCASE", scope).Scan(&count)
+	return err == nil && count > 0
+}
+
+type roleAssignment struct {
+	ID               string
+	scope            string
+	roleDefinitionID string
+}
+
+// roleAssignmentsFor returns the role assignments granted to a principal
+func (p *AROHCPMockProxyEnhanced) roleAssignmentsFor(principalID string) []roleAssignment {
+	var assignments []roleAssignment
+	rows := p.queryResources(`resource_type = 'RoleAssignment'
+		AND CASE WHEN json_valid(properties) THEN json_extract(properties, '$.principalId') END = ? COLLATE NOCASE`, principalID)
+	for _, res := range rows {
+		var props struct {
+			RoleDefinitionID string `json:"roleDefinitionId"`
+		}
+		json.Unmarshal([]byte(res.Properties), &props)
+		assignments = append(assignments, roleAssignment{
+			ID:               res.ID,
+			scope:            roleAssignmentScope(res.ID),
+			roleDefinitionID: props.RoleDefinitionID,
+		})
+	}
+	return assignments
+}
+
+// hasRole reports whether one of the assignments grants roleDefinitionID at
+// scope or at one of its parents. An empty scope matches any scope.
+func hasRole(assignments []roleAssignment, roleDefinitionID, scope string) bool {
+	for _, a := range assignments {
+		if roleDefinitionGUID(a.roleDefinitionID) != roleDefinitionGUID(roleDefinitionID) {
+			continue
+		}
+		if scope == "" || strings.EqualFold(a.scope, scope) ||
+			strings.HasPrefix(strings.ToLower(scope), strings.ToLower(a.scope)+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// roleDefinition is a built-in Azure role an operator identity needs
+type roleDefinition struct {
+	Name       string `json:"name"`
+	ResourceID string `json:"resourceId"`
+}
+
+// operatorRoles lists the roles one operator identity needs. Required is
+// "Always", or "OnEnablement" for operators that only run when the matching
+// feature (e.g. KMS) is enabled.
+type operatorRoles struct {
+	Name            string           `json:"name"`
+	RoleDefinitions []roleDefinition `json:"roleDefinitions"`
+	Required        string           `json:"required"`
+}
+
+// operatorRoleSet is the content of an hcpOperatorIdentityRoleSets resource
+type operatorRoleSet struct {
+	ControlPlaneOperators  []operatorRoles `json:"controlPlaneOperators"`
+	DataPlaneOperators     []operatorRoles `json:"dataPlaneOperators"`
+	ServiceManagedIdentity operatorRoles   `json:"serviceManagedIdentity"`
+}
+
+func builtinRole(name, guid string) roleDefinition {
+	return roleDefinition{Name: name, ResourceID: "/providers/Microsoft.Authorization/roleDefinitions/" + guid}
+}
+
+var (
+	readerRole                    = builtinRole("Reader", "acdd72a7-3385-48ef-bd42-f606fba81ae7")
+	federatedCredentialsRole      = builtinRole("Azure Red Hat OpenShift Federated Credential", "ef318e2a-8334-4a05-9e4a-295a196c6a6e")
+	hcpServiceManagedIdentityRole = builtinRole("Azure Red Hat OpenShift Hosted Control Planes Service Managed Identity", "c0ff367d-66d8-445e-917c-583feb0ef0d4")
+	hcpClusterAPIProviderRole     = builtinRole("Azure Red Hat OpenShift Hosted Control Planes Cluster API Provider", "88366f10-ed47-4cc0-9fab-c8a06148393e")
+	hcpControlPlaneOperatorRole   = builtinRole("Azure Red Hat OpenShift Hosted Control Planes Control Plane Operator", "fc0c873f-45e9-4d0d-a7d1-585aab30c6ed")
+	cloudControllerManagerRole    = builtinRole("Azure Red Hat OpenShift Cloud Controller Manager", "a1f96423-95ce-4224-ab27-4e3dc72facd4")
+	ingressOperatorRole           = builtinRole("Azure Red Hat OpenShift Cluster Ingress Operator", "0336e1d3-7a87-462b-b6db-342b63f7802c")
+	fileStorageOperatorRole       = builtinRole("Azure Red Hat OpenShift File Storage Operator", "0d7aedc0-15fd-4a67-a412-efad370c947e")
+	networkOperatorRole           = builtinRole("Azure Red Hat OpenShift Network Operator", "be7a6435-15ae-4171-8f30-4a343eff9e8f")
+	keyVaultCryptoUserRole        = builtinRole("Key Vault Crypto User", "12338af0-0e69-4776-bea7-57ae8d297424")
+)
+
+// The operators and roles below match the RoleAssignment resources in
+// scripts/aro-hcp/aro-template-roleassignments.yaml. Operators without
+// role definitions get their permissions on the managed resource group,
+// which the RP assigns itself.
+var roleSet4x = &operatorRoleSet{
+	ControlPlaneOperators: []operatorRoles{
+		{Name: "cloud-controller-manager", Required: "Always", RoleDefinitions: []roleDefinition{cloudControllerManagerRole}},
+		{Name: "cloud-network-config", Required: "Always", RoleDefinitions: []roleDefinition{networkOperatorRole}},
+		{Name: "cluster-api-azure", Required: "Always", RoleDefinitions: []roleDefinition{hcpClusterAPIProviderRole}},
+		{Name: "control-plane", Required: "Always", RoleDefinitions: []roleDefinition{hcpControlPlaneOperatorRole}},
+		{Name: "disk-csi-driver", Required: "Always", RoleDefinitions: []roleDefinition{}},
+		{Name: "file-csi-driver", Required: "Always", RoleDefinitions: []roleDefinition{fileStorageOperatorRole}},
+		{Name: "image-registry", Required: "Always", RoleDefinitions: []roleDefinition{}},
+		{Name: "ingress", Required: "Always", RoleDefinitions: []roleDefinition{ingressOperatorRole}},
+		{Name: "kms", Required: "OnEnablement", RoleDefinitions: []roleDefinition{keyVaultCryptoUserRole}},
+	},
+	DataPlaneOperators: []operatorRoles{
+		{Name: "disk-csi-driver", Required: "Always", RoleDefinitions: []roleDefinition{}},
+		{Name: "file-csi-driver", Required: "Always", RoleDefinitions: []roleDefinition{fileStorageOperatorRole}},
+		{Name: "image-registry", Required: "Always", RoleDefinitions: []roleDefinition{}},
+	},
+	ServiceManagedIdentity: operatorRoles{
+		Name: "service-managed-identity", Required: "Always", RoleDefinitions: []roleDefinition{hcpServiceManagedIdentityRole},
+	},
+}
+
+// operatorRoleSets maps an OpenShift minor version to its role set
+var operatorRoleSets = map[string]*operatorRoleSet{
+	"4.19": roleSet4x,
+	"4.20": roleSet4x,
+}
+
+var minorVersionRE = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// roleSetForVersion returns the role set for a cluster version such as
+// "4.20" or "4.20.17". Versions without their own role set use the newest
+// one, like a cluster on a version newer than the mock knows about.
+func roleSetForVersion(version string) (string, *operatorRoleSet) {
+	if m := minorVersionRE.FindStringSubmatch(version); m != nil {
+		if rs, ok := operatorRoleSets[m[0]]; ok {
+			return m[0], rs
+		}
+	}
+	versions := sortedKeys(operatorRoleSets)
+	sort.Slice(versions, func(i, j int) bool { return compareMinorVersions(versions[i], versions[j]) < 0 })
+	latest := versions[len(versions)-1]
+	log.Printf("No operator identity role set for version %q, using %s", version, latest)
+	return latest, operatorRoleSets[latest]
+}
+
+func compareMinorVersions(a, b string) int {
+	var aMajor, aMinor, bMajor, bMinor int
+	fmt.Sscanf(a, "%d.%d", &aMajor, &aMinor)
+	fmt.Sscanf(b, "%d.%d", &bMajor, &bMinor)
+	if aMajor != bMajor {
+		return aMajor - bMajor
+	}
+	return aMinor - bMinor
+}
+
+func (p *AROHCPMockProxyEnhanced) handleHcpOperatorIdentityRoleSets(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
+	roleSetResponse := func(version string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":         fmt.Sprintf("/subscriptions/%s/providers/Microsoft.RedHatOpenShift/locations/%s/hcpOperatorIdentityRoleSets/%s", parsed.SubscriptionID, parsed.Location, version),
+			"name":       version,
+			"type":       "Microsoft.RedHatOpenShift/hcpOperatorIdentityRoleSets",
+			"properties": operatorRoleSets[version],
+		}
+	}
+
+	if r.Method == "GET" {
+		if parsed.ResourceName != "" {
+			// Get specific role set
+			if _, ok := operatorRoleSets[parsed.ResourceName]; ok {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(roleSetResponse(parsed.ResourceName))
+				return
+			}
+			writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+				Code:    "ResourceNotFound",
+				Message: fmt.Sprintf("Operator identity role set '%s' was not found in location '%s'.", parsed.ResourceName, parsed.Location),
+			})
+		} else {
+			// List role sets
+			roleSets := []map[string]interface{}{}
+			for _, version := range sortedKeys(operatorRoleSets) {
+				roleSets = append(roleSets, roleSetResponse(version))
+			}
+			response := map[string]interface{}{
+				"value": roleSets,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		}
+	} else {
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+	}
+}
+
+// Actions the service managed identity needs over each operator identity
+const (
+	identityReadAction = "Microsoft.ManagedIdentity/userAssignedIdentities/read"
+	ficActions         = "Microsoft.ManagedIdentity/userAssignedIdentities/federatedIdentityCredentials/read, " +
+		"Microsoft.ManagedIdentity/userAssignedIdentities/federatedIdentityCredentials/write"
+)
+
+// checkClusterRoleAssignments mirrors the RP inflight checks that run while
+// a cluster is provisioning: every operator identity must hold the roles its
+// role set requires, and the service managed identity must be able to read
+// the control plane identities and federate the data plane ones. It returns
+// nil when the cluster may finish provisioning.
+func (p *AROHCPMockProxyEnhanced) checkClusterRoleAssignments(clusterID string) *OperationError {
+	res, err := p.getResource(clusterID)
+	if err != nil || res.ResourceType != "hcpOpenShiftClusters" {
+		return nil
+	}
+	body := res.toBody()
+
+	version := ""
+	if values := resolvePath(body, "properties.version.id"); len(values) > 0 && values[0].found {
+		version, _ = values[0].value.(string)
+	}
+	roleSetVersion, roleSet := roleSetForVersion(version)
+
+	const base = "properties.platform.operatorsAuthentication.userAssignedIdentities"
+	operators := operatorIdentities(body)
+
+	for _, group := range []struct {
+		field     string
+		label     string
+		operators []operatorRoles
+	}{
+		{"controlPlaneOperators", "control plane", roleSet.ControlPlaneOperators},
+		{"dataPlaneOperators", "data plane", roleSet.DataPlaneOperators},
+	} {
+		for _, op := range group.operators {
+			identityID, ok := operators[base+"."+group.field+"."+op.Name]
+			if !ok {
+				continue
+			}
+			if missing := p.missingRoles(identityID, op.RoleDefinitions); len(missing) > 0 {
+				return &OperationError{
+					Code: "InvalidRequestContent",
+					Message: fmt.Sprintf("inflight check 'operator-identity-roles-inflight' failed: %s operator identity '%s' (%s) lacks role assignments required by role set %s: %s",
+						group.label, op.Name, identityID, roleSetVersion, strings.Join(missing, ", ")),
+				}
+			}
+		}
+	}
+
+	smiID, ok := operators[base+".serviceManagedIdentity"]
+	if !ok {
+		return nil
+	}
+	if missing := p.missingRoles(smiID, roleSet.ServiceManagedIdentity.RoleDefinitions); len(missing) > 0 {
+		return &OperationError{
+			Code: "InvalidRequestContent",
+			Message: fmt.Sprintf("inflight check 'operator-identity-roles-inflight' failed: service managed identity (%s) lacks role assignments required by role set %s: %s",
+				smiID, roleSetVersion, strings.Join(missing, ", ")),
+		}
+	}
+
+	principalID, _ := identityIDs(smiID)
+	assignments := p.roleAssignmentsFor(principalID)
+	var cpMissing, dpMissing []string
+	for _, field := range sortedKeys(operators) {
+		identityID := operators[field]
+		switch {
+		case strings.HasPrefix(field, base+".controlPlaneOperators."):
+			if !hasRole(assignments, readerRole.ResourceID, identityID) {
+				cpMissing = append(cpMissing, identityID)
+			}
+		case strings.HasPrefix(field, base+".dataPlaneOperators."):
+			if !hasRole(assignments, federatedCredentialsRole.ResourceID, identityID) {
+				dpMissing = append(dpMissing, identityID)
+			}
+		}
+	}
+	if len(cpMissing) > 0 {
+		return &OperationError{
+			Code: "InvalidRequestContent",
+			Message: fmt.Sprintf("inflight check 'managed-service-identity-inflight' failed: service managed identity lacks required actions over one or more control plane identities:\n  - not allowed: %s (%s)",
+				identityReadAction, strings.Join(cpMissing, ", ")),
+		}
+	}
+	if len(dpMissing) > 0 {
+		return &OperationError{
+			Code: "InvalidRequestContent",
+			Message: fmt.Sprintf("inflight check 'managed-service-identity-inflight' failed: service managed identity lacks required actions over one or more data plane identities:\n  - not allowed: %s, %s (%s)",
+				identityReadAction, ficActions, strings.Join(dpMissing, ", ")),
+		}
+	}
+	return nil
+}
+
+// missingRoles returns the names of the roles not assigned to an identity
+// at any scope
+func (p *AROHCPMockProxyEnhanced) missingRoles(identityID string, roles []roleDefinition) []string {
+	principalID, _ := identityIDs(identityID)
+	assignments := p.roleAssignmentsFor(principalID)
+
+	var missing []string
+	for _, role := range roles {
+		if !hasRole(assignments, role.ResourceID, "") {
+			missing = append(missing, fmt.Sprintf("'%s' (%s)", role.Name, roleDefinitionGUID(role.ResourceID)))
+		}
+	}
+	return missing
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// scenarioTag is the resource tag that selects a scenario by name
+const scenarioTag = "aro-mockup-proxy-scenario"
+
+// scenario scripts the provisioning timeline of the resources it applies
+// to, replacing the fixed progress stages. One YAML file per scenario in
+// SCENARIO_DIR, e.g.
+//
+//	# stuck-create.yaml: creating for 30s, then Failed
+//	match:
+//	  resourceType: hcpOpenShiftClusters
+//	  namePattern: "stuck-*"
+//	operations: [Create]
+//	timeline:
+//	- state: Provisioning
+//	  for: 30s
+//	- state: Failed
+//	  code: InternalServerError
+//	  message: Hosted control plane did not become available.
+//
+// The operation stays InProgress through the non-terminal states and ends
+// with the first Succeeded, Failed or Canceled state; a timeline without
+// one leaves it InProgress for good. States after that are applied to the
+// resource afterwards, e.g. Succeeded, then Updating after 2 minutes, then
+// Failed.
+//
+// A resource tagged aro-mockup-proxy-scenario=<name> uses that scenario.
+// Otherwise the first scenario, by file name, whose match applies is used;
+// a scenario without a match is only selected by tag.
+type scenario struct {
+	Name       string         `json:"name,omitempty"` // defaults to the file name
+	Match      *scenarioMatch `json:"match,omitempty"`
+	Operations []string       `json:"operations,omitempty"` // operation types; all when empty
+	Timeline   []scenarioStep `json:"timeline"`
+}
+
+type scenarioMatch struct {
+	ResourceType string            `json:"resourceType,omitempty"` // e.g. nodePools
+	NamePattern  string            `json:"namePattern,omitempty"`  // glob on the resource name
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+type scenarioStep struct {
+	State           string           `json:"state"`
+	For             scenarioDuration `json:"for,omitempty"` // time until the next step
+	PercentComplete *int             `json:"percentComplete,omitempty"`
+	Code            string           `json:"code,omitempty"` // Failed and Canceled only
+	Message         string           `json:"message,omitempty"`
+}
+
+// scenarioDuration is a time.Duration written as a string such as "30s"
+type scenarioDuration struct {
+	time.Duration
+}
+
+func (d *scenarioDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+func terminalState(state string) bool {
+	return state == "Succeeded" || state == "Failed" || state == "Canceled"
+}
+
+// loadScenarios reads the *.yaml files of dir, or returns nil when dir is
+// empty
+func loadScenarios(dir string) ([]*scenario, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var scenarios []*scenario
+	names := map[string]bool{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var s scenario
+		if err := yaml.UnmarshalStrict(data, &s); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if s.Name == "" {
+			s.Name = strings.TrimSuffix(filepath.Base(file), ".yaml")
+		}
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("%s: duplicate scenario %q", file, s.Name)
+		}
+		names[s.Name] = true
+		scenarios = append(scenarios, &s)
+	}
+	log.Printf("Loaded %d provisioning scenarios from %s", len(scenarios), dir)
+	return scenarios, nil
+}
+
+func (s *scenario) validate() error {
+	if len(s.Timeline) == 0 {
+		return fmt.Errorf("scenario %q has an empty timeline", s.Name)
+	}
+	for i, step := range s.Timeline {
+		if step.State == "" {
+			return fmt.Errorf("timeline step %d has no state", i+1)
+		}
+		if step.Code != "" && step.State != "Failed" && step.State != "Canceled" {
+			return fmt.Errorf("timeline step %d: code is only allowed for Failed and Canceled", i+1)
+		}
+		if step.PercentComplete != nil && (*step.PercentComplete < 0 || *step.PercentComplete > 100) {
+			return fmt.Errorf("timeline step %d: percentComplete must be between 0 and 100", i+1)
+		}
+	}
+	if s.Match != nil && s.Match.NamePattern != "" {
+		if _, err := path.Match(s.Match.NamePattern, ""); err != nil {
+			return fmt.Errorf("namePattern: %w", err)
+		}
+	}
+	for _, operation := range s.Operations {
+		if _, ok := operationMethods[operation]; !ok {
+			return fmt.Errorf("unknown operation type %q", operation)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether the scenario covers an operation type
+func (s *scenario) appliesTo(operationType string) bool {
+	if len(s.Operations) == 0 {
+		return true
+	}
+	for _, operation := range s.Operations {
+		if strings.EqualFold(operation, operationType) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the match of the scenario applies to a resource
+func (s *scenario) matches(resourceID string, tags map[string]string) bool {
+	if s.Match == nil {
+		return false
+	}
+	armType, name := resourceTypeAndName(resourceID)
+	if s.Match.ResourceType != "" && !strings.EqualFold(s.Match.ResourceType, armType) &&
+		!strings.EqualFold(s.Match.ResourceType, armType[strings.LastIndex(armType, "/")+1:]) {
+		return false
+	}
+	if s.Match.NamePattern != "" {
+		if ok, _ := path.Match(strings.ToLower(s.Match.NamePattern), strings.ToLower(name)); !ok {
+			return false
+		}
+	}
+	for key, value := range s.Match.Tags {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// scenarioFor returns the scenario that scripts an operation, if any
+func (m *AsyncOperationManager) scenarioFor(op *AsyncOperation) *scenario {
+	if len(m.scenarios) == 0 || op.OperationType == "RequestAdminCredential" || m.db == nil {
+		return nil
+	}
+	var raw string
+	m.db.QueryRow("SELECT tags FROM resources WHERE id = ?", op.ResourceID).Scan(&raw)
+	var tags map[string]string
+	json.Unmarshal([]byte(raw), &tags)
+
+	if name := tags[scenarioTag]; name != "" {
+		for _, s := range m.scenarios {
+			if s.Name == name && s.appliesTo(op.OperationType) {
+				return s
+			}
+		}
+		log.Printf("Operation %s: no scenario %q for %s, using the default timeline", op.ID, name, op.OperationType)
+		return nil
+	}
+	for _, s := range m.scenarios {
+		if s.appliesTo(op.OperationType) && s.matches(op.ResourceID, tags) {
+			return s
+		}
+	}
+	return nil
+}
+
+// setScenarioState writes a timeline state to the resource and reports
+// whether the resource still exists. Only a Delete may override Deleting.
+func (m *AsyncOperationManager) setScenarioState(resourceID, operationType, state string) bool {
+	if m.db == nil {
+		return true
+	}
+	result, err := m.db.Exec(`
+		UPDATE resources SET provisioning_state = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (provisioning_state != 'Deleting' OR ? = 'Delete')
+	`, state, resourceID, operationType)
+	if err != nil {
+		log.Printf("Failed to update resource state: %v", err)
+		return false
+	}
+	n, _ := result.RowsAffected()
+	return n > 0
+}
+
+// runScenario walks the timeline of a scenario up to its first terminal
+// state. It reports true when that is Succeeded, leaving the completion to
+// the caller; Failed and Canceled finish the operation here. Steps are due
+// at fixed offsets from StartTime, so a resumed operation does not start
+// over.
+func (m *AsyncOperationManager) runScenario(op *AsyncOperation, s *scenario) bool {
+	log.Printf("Operation %s: running scenario %s", op.ID, s.Name)
+	op.mu.RLock()
+	due := op.StartTime
+	op.mu.RUnlock()
+
+	for _, step := range s.Timeline {
+		m.clock.SleepUntil(due)
+		due = due.Add(step.For.Duration)
+		if m.finished(op) {
+			return false
+		}
+
+		switch step.State {
+		case "Succeeded":
+			return true
+		case "Failed", "Canceled":
+			code, message := step.Code, step.Message
+			if code == "" {
+				code = step.State
+			}
+			if message == "" {
+				message = fmt.Sprintf("The operation was %s by scenario %s.", strings.ToLower(step.State), s.Name)
+			}
+			m.setScenarioState(op.ResourceID, op.OperationType, step.State)
+			m.finishOperation(op, step.State, &OperationError{Code: code, Message: message})
+			go m.continueScenario(op, s)
+			return false
+		}
+
+		m.setScenarioState(op.ResourceID, op.OperationType, step.State)
+		if step.PercentComplete != nil {
+			op.mu.Lock()
+			op.PercentComplete = *step.PercentComplete
+			op.mu.Unlock()
+			m.saveOperation(op)
+		}
+		log.Printf("Operation %s: %s (scenario %s)", op.ID, step.State, s.Name)
+	}
+
+	log.Printf("Operation %s: scenario %s ended without a terminal state, leaving it InProgress", op.ID, s.Name)
+	return false
+}
+
+// continueScenario applies the states after the terminal one to the
+// resource once its operation has finished. It stops when the resource is
+// gone or another operation has started on it.
+func (m *AsyncOperationManager) continueScenario(op *AsyncOperation, s *scenario) {
+	op.mu.RLock()
+	due := op.StartTime
+	op.mu.RUnlock()
+
+	finished := false
+	for _, step := range s.Timeline {
+		start := due
+		due = due.Add(step.For.Duration)
+		if !finished {
+			finished = terminalState(step.State)
+			continue
+		}
+		m.clock.SleepUntil(start)
+		if m.HasActiveOperation(op.ResourceID) || !m.setScenarioState(op.ResourceID, "", step.State) {
+			return
+		}
+		log.Printf("Resource %s: %s (scenario %s)", op.ResourceID, step.State, s.Name)
+	}
+}
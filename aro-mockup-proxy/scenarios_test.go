@@ -0,0 +1,271 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventually polls cond until it holds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestLoadScenarios(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name: "named by file",
+			files: map[string]string{
+				"b-slow.yaml":  "timeline:\n- state: Provisioning\n  for: 30s\n- state: Succeeded\n",
+				"a-fail.yaml":  "name: failing\noperations: [Create]\ntimeline:\n- state: Failed\n  code: InternalServerError\n",
+				"ignored.json": "{}",
+			},
+			wantNames: []string{"failing", "b-slow"},
+		},
+		{
+			name:    "empty timeline",
+			files:   map[string]string{"s.yaml": "timeline: []\n"},
+			wantErr: "empty timeline",
+		},
+		{
+			name:    "unknown field",
+			files:   map[string]string{"s.yaml": "timelines:\n- state: Succeeded\n"},
+			wantErr: "unknown field",
+		},
+		{
+			name:    "bad duration",
+			files:   map[string]string{"s.yaml": "timeline:\n- state: Provisioning\n  for: 30\n"},
+			wantErr: "duration must be a string",
+		},
+		{
+			name:    "code on a non-failed state",
+			files:   map[string]string{"s.yaml": "timeline:\n- state: Succeeded\n  code: Oops\n"},
+			wantErr: "code is only allowed",
+		},
+		{
+			name:    "percent out of range",
+			files:   map[string]string{"s.yaml": "timeline:\n- state: Provisioning\n  percentComplete: 101\n"},
+			wantErr: "percentComplete",
+		},
+		{
+			name:    "unknown operation",
+			files:   map[string]string{"s.yaml": "operations: [Upgrade]\ntimeline:\n- state: Succeeded\n"},
+			wantErr: "unknown operation type",
+		},
+		{
+			name: "duplicate name",
+			files: map[string]string{
+				"a.yaml": "name: same\ntimeline:\n- state: Succeeded\n",
+				"b.yaml": "name: same\ntimeline:\n- state: Succeeded\n",
+			},
+			wantErr: "duplicate scenario",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			scenarios, err := loadScenarios(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadScenarios: %v", err)
+			}
+			var names []string
+			for _, s := range scenarios {
+				names = append(names, s.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("scenarios %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+
+	if scenarios, err := loadScenarios(""); scenarios != nil || err != nil {
+		t.Errorf("loadScenarios(\"\") = %v, %v, want nothing", scenarios, err)
+	}
+}
+
+func TestScenarioFor(t *testing.T) {
+	timeline := []scenarioStep{{State: "Succeeded"}}
+	scenarios := []*scenario{
+		{Name: "tagged-only", Timeline: timeline},
+		{Name: "deletes", Operations: []string{"Delete"}, Match: &scenarioMatch{}, Timeline: timeline},
+		{Name: "stuck-pools", Match: &scenarioMatch{ResourceType: "nodePools", NamePattern: "stuck-*"}, Timeline: timeline},
+		{Name: "team-a", Match: &scenarioMatch{Tags: map[string]string{"team": "a"}}, Timeline: timeline},
+	}
+	poolID := func(name string) string { return testClusterID + "/nodePools/" + name }
+
+	tests := []struct {
+		name          string
+		resourceID    string
+		tags          string
+		operationType string
+		want          string
+	}{
+		{name: "no match", resourceID: testClusterID, operationType: "Create"},
+		{name: "by tag", resourceID: testClusterID, tags: `{"aro-mockup-proxy-scenario":"tagged-only"}`, operationType: "Create", want: "tagged-only"},
+		{name: "tag of an unknown scenario", resourceID: testClusterID, tags: `{"aro-mockup-proxy-scenario":"nope","team":"a"}`, operationType: "Create"},
+		{name: "tag for another operation", resourceID: testClusterID, tags: `{"aro-mockup-proxy-scenario":"deletes"}`, operationType: "Create"},
+		{name: "by operation", resourceID: testClusterID, operationType: "Delete", want: "deletes"},
+		{name: "by type and name", resourceID: poolID("stuck-1"), operationType: "Create", want: "stuck-pools"},
+		{name: "name mismatch", resourceID: poolID("np1"), operationType: "Create"},
+		{name: "by tags", resourceID: poolID("np1"), tags: `{"team":"a"}`, operationType: "Update", want: "team-a"},
+		{name: "credentials are not scripted", resourceID: testClusterID, tags: `{"aro-mockup-proxy-scenario":"tagged-only"}`, operationType: "RequestAdminCredential"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			insertTestResource(t, db, tt.resourceID, "Creating")
+			if tt.tags != "" {
+				db.Exec(`UPDATE resources SET tags = ? WHERE id = ?`, tt.tags, tt.resourceID)
+			}
+			m := NewAsyncOperationManager(&Config{ClockSpeed: 1}, db)
+			m.scenarios = scenarios
+
+			got := ""
+			if s := m.scenarioFor(&AsyncOperation{ID: "op", ResourceID: tt.resourceID, OperationType: tt.operationType}); s != nil {
+				got = s.Name
+			}
+			if got != tt.want {
+				t.Errorf("scenario %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunScenario(t *testing.T) {
+	percent := func(p int) *int { return &p }
+	step := func(state string, d time.Duration) scenarioStep {
+		return scenarioStep{State: state, For: scenarioDuration{d}}
+	}
+
+	tests := []struct {
+		name        string
+		timeline    []scenarioStep
+		wantStatus  string
+		wantCode    string
+		wantState   string // once the operation has finished
+		wantLater   string // after the steps past the terminal one
+		wantPercent int
+	}{
+		{
+			name:       "succeeds",
+			timeline:   []scenarioStep{{State: "Provisioning", For: scenarioDuration{time.Minute}, PercentComplete: percent(40)}, {State: "Succeeded"}},
+			wantStatus: "Succeeded", wantState: "Succeeded", wantPercent: 40,
+		},
+		{
+			name:       "fails with a code",
+			timeline:   []scenarioStep{step("Provisioning", time.Minute), {State: "Failed", Code: "ZonalAllocationFailed", Message: "no capacity"}},
+			wantStatus: "Failed", wantCode: "ZonalAllocationFailed", wantState: "Failed",
+		},
+		{
+			name:       "canceled",
+			timeline:   []scenarioStep{step("Provisioning", time.Minute), step("Canceled", 0)},
+			wantStatus: "Canceled", wantCode: "Canceled", wantState: "Canceled",
+		},
+		{
+			name:       "states after success",
+			timeline:   []scenarioStep{step("Provisioning", time.Minute), step("Succeeded", 2*time.Minute), step("Updating", 0)},
+			wantStatus: "Succeeded", wantState: "Succeeded", wantLater: "Updating",
+		},
+		{
+			name:       "states after failure",
+			timeline:   []scenarioStep{step("Provisioning", time.Minute), step("Failed", 2*time.Minute), step("Succeeded", 0)},
+			wantStatus: "Failed", wantCode: "Failed", wantState: "Failed", wantLater: "Succeeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			insertTestResource(t, db, testClusterID, "Creating")
+			// A stopped clock only moves when the test advances it
+			m := NewAsyncOperationManager(&Config{ClockSpeed: 0}, db)
+			m.scenarios = []*scenario{{Name: tt.name, Match: &scenarioMatch{}, Timeline: tt.timeline}}
+
+			op := m.StartOperation(testClusterID, "Create")
+			eventually(t, "the first timeline state", func() bool {
+				return resourceState(t, db, testClusterID) == tt.timeline[0].State
+			})
+			if m.finished(op) {
+				t.Fatalf("operation finished during the first step, want InProgress")
+			}
+			if tt.wantPercent != 0 {
+				eventually(t, "the step's percentComplete", func() bool {
+					op.mu.RLock()
+					defer op.mu.RUnlock()
+					return op.PercentComplete == tt.wantPercent
+				})
+			}
+
+			m.clock.update(time.Minute, 0)
+			if status := waitForOperation(t, op); status != tt.wantStatus {
+				t.Fatalf("status %s, want %s", status, tt.wantStatus)
+			}
+			code := ""
+			if op.Error != nil {
+				code = op.Error.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("error code %q, want %q", code, tt.wantCode)
+			}
+			eventually(t, "the terminal resource state", func() bool {
+				return resourceState(t, db, testClusterID) == tt.wantState
+			})
+
+			if tt.wantLater != "" {
+				m.clock.update(2*time.Minute, 0)
+				eventually(t, "the state after the terminal one", func() bool {
+					return resourceState(t, db, testClusterID) == tt.wantLater
+				})
+			}
+		})
+	}
+}
+
+func TestRunScenarioWithoutTerminalState(t *testing.T) {
+	db := newTestDB(t)
+	insertTestResource(t, db, testClusterID, "Creating")
+	m := NewAsyncOperationManager(&Config{ClockSpeed: 0}, db)
+	s := &scenario{Name: "forever", Timeline: []scenarioStep{{State: "Provisioning", For: scenarioDuration{time.Minute}}}}
+
+	op := m.newOperation(testClusterID, "Create", nil)
+	done := make(chan bool)
+	go func() { done <- m.runScenario(op, s) }()
+	m.clock.update(time.Hour, 0)
+	if <-done {
+		t.Errorf("runScenario reported success without a Succeeded state")
+	}
+	if m.finished(op) {
+		t.Errorf("operation finished, want it left InProgress")
+	}
+	if got := resourceState(t, db, testClusterID); got != "Provisioning" {
+		t.Errorf("resource state %q, want Provisioning", got)
+	}
+}
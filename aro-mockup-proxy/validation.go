@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// fieldRule describes the constraints on one field of an ARO-HCP request body.
+// Path is dot-separated from the top of the body (e.g. "properties.api.visibility");
+// a segment ending in "[]" fans out over every element of that array.
+type fieldRule struct {
+	Path string
+
+	// Required fields must always be present; RequiredIfParent fields only
+	// when the enclosing object is present.
+	Required         bool
+	RequiredIfParent bool
+
+	Type      string // string, integer, boolean, array, object
+	Enum      []string
+	Format    string // cidr, armId, url
+	Minimum   *int
+	Maximum   *int
+	Immutable bool
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// API versions the validator knows about
+const (
+	apiVersion20240610Preview = "2024-06-10-preview"
+	apiVersion20251223Preview = "2025-12-23-preview"
+)
+
+var (
+	channelGroups  = []string{"stable", "candidate", "fast", "nightly"}
+	visibilities   = []string{"Public", "Private"}
+	diskAccounts   = []string{"Premium_LRS", "StandardSSD_LRS", "Standard_LRS"}
+	taintEffects   = []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+	prefixPolicies = []string{"Prefix", "NoPrefix", "None"}
+)
+
+// versionRules derives the rules of an api-version from those of the
+// previous one: a change to the path of an existing rule replaces it, other
+// changes are appended and the removed paths are dropped
+func versionRules(base []fieldRule, changes []fieldRule, removed ...string) []fieldRule {
+	dropped := map[string]bool{}
+	for _, path := range removed {
+		dropped[path] = true
+	}
+	rules := make([]fieldRule, 0, len(base)+len(changes))
+	replaced := map[string]bool{}
+	for _, rule := range base {
+		if dropped[rule.Path] {
+			continue
+		}
+		for _, change := range changes {
+			if change.Path == rule.Path {
+				rule = change
+				replaced[change.Path] = true
+			}
+		}
+		rules = append(rules, rule)
+	}
+	for _, change := range changes {
+		if !replaced[change.Path] {
+			rules = append(rules, change)
+		}
+	}
+	return rules
+}
+
+var clusterRules20240610 = []fieldRule{
+	{Path: "location", Required: true, Type: "string", Immutable: true},
+	{Path: "properties", Required: true, Type: "object"},
+	{Path: "properties.version", Required: true, Type: "object"},
+	{Path: "properties.version.id", Required: true, Type: "string"},
+	{Path: "properties.version.channelGroup", Type: "string", Enum: channelGroups},
+	{Path: "properties.dns.baseDomainPrefix", Type: "string", Immutable: true},
+	{Path: "properties.network.networkType", Type: "string", Enum: []string{"OVNKubernetes", "Other"}, Immutable: true},
+	{Path: "properties.network.podCidr", Type: "string", Format: "cidr", Immutable: true},
+	{Path: "properties.network.serviceCidr", Type: "string", Format: "cidr", Immutable: true},
+	{Path: "properties.network.machineCidr", Type: "string", Format: "cidr", Immutable: true},
+	{Path: "properties.network.hostPrefix", Type: "integer", Minimum: intPtr(23), Maximum: intPtr(26), Immutable: true},
+	{Path: "properties.api.visibility", Type: "string", Enum: visibilities, Immutable: true},
+	{Path: "properties.platform", Required: true, Type: "object"},
+	{Path: "properties.platform.subnetId", Required: true, Type: "string", Format: "armId", Immutable: true},
+	{Path: "properties.platform.networkSecurityGroupId", Required: true, Type: "string", Format: "armId", Immutable: true},
+	{Path: "properties.platform.managedResourceGroup", Type: "string", Immutable: true},
+	{Path: "properties.platform.outboundType", Type: "string", Enum: []string{"LoadBalancer"}, Immutable: true},
+	{Path: "properties.platform.operatorsAuthentication.userAssignedIdentities.serviceManagedIdentity", Type: "string", Format: "armId"},
+	{Path: "properties.clusterImageRegistry.state", Type: "string", Enum: []string{"Enabled", "Disabled"}, Immutable: true},
+	{Path: "properties.etcd.dataEncryption.keyManagementMode", Type: "string", Enum: []string{"PlatformManaged", "CustomerManaged"}, Immutable: true},
+	{Path: "properties.etcd.dataEncryption.customerManaged.encryptionType", Type: "string", Enum: []string{"KMS"}},
+	{Path: "properties.etcd.dataEncryption.customerManaged.kms.activeKey.name", RequiredIfParent: true, Type: "string"},
+	{Path: "properties.etcd.dataEncryption.customerManaged.kms.activeKey.vaultName", RequiredIfParent: true, Type: "string"},
+}
+
+// 2025-12-23-preview adds VNet integration, requires the operator
+// identities and moves the KMS vault from the active key to the KMS object
+var clusterRules20251223 = versionRules(clusterRules20240610, []fieldRule{
+	{Path: "properties.platform.vnetIntegrationSubnetId", Required: true, Type: "string", Format: "armId", Immutable: true},
+	{Path: "properties.platform.operatorsAuthentication", Required: true, Type: "object"},
+	{Path: "properties.etcd.dataEncryption.customerManaged.kms.vaultName", RequiredIfParent: true, Type: "string"},
+	{Path: "properties.etcd.dataEncryption.customerManaged.kms.visibility", RequiredIfParent: true, Type: "string", Enum: visibilities},
+	{Path: "properties.imageDigestMirrors", Type: "array"},
+	{Path: "properties.imageDigestMirrors[].source", RequiredIfParent: true, Type: "string"},
+}, "properties.etcd.dataEncryption.customerManaged.kms.activeKey.vaultName")
+
+var nodePoolRules20240610 = []fieldRule{
+	{Path: "location", Type: "string", Immutable: true},
+	{Path: "properties", Required: true, Type: "object"},
+	{Path: "properties.version.id", Required: true, Type: "string"},
+	{Path: "properties.version.channelGroup", Type: "string", Enum: channelGroups},
+	{Path: "properties.platform", Required: true, Type: "object"},
+	{Path: "properties.platform.vmSize", Required: true, Type: "string", Immutable: true},
+	{Path: "properties.platform.subnetId", Type: "string", Format: "armId", Immutable: true},
+	{Path: "properties.platform.availabilityZone", Type: "string", Immutable: true},
+	{Path: "properties.platform.osDisk.sizeGiB", Type: "integer", Minimum: intPtr(1), Immutable: true},
+	{Path: "properties.platform.osDisk.diskStorageAccountType", Type: "string", Enum: diskAccounts, Immutable: true},
+	{Path: "properties.replicas", Type: "integer", Minimum: intPtr(0)},
+	{Path: "properties.autoRepair", Type: "boolean"},
+	{Path: "properties.autoScaling.min", RequiredIfParent: true, Type: "integer", Minimum: intPtr(0)},
+	{Path: "properties.autoScaling.max", RequiredIfParent: true, Type: "integer", Minimum: intPtr(0)},
+	{Path: "properties.labels", Type: "array"},
+	{Path: "properties.labels[].key", RequiredIfParent: true, Type: "string"},
+	{Path: "properties.taints", Type: "array"},
+	{Path: "properties.taints[].key", RequiredIfParent: true, Type: "string"},
+	{Path: "properties.taints[].effect", RequiredIfParent: true, Type: "string", Enum: taintEffects},
+}
+
+// 2025-12-23-preview raises the minimum OS disk size and adds the disk type
+var nodePoolRules20251223 = versionRules(nodePoolRules20240610, []fieldRule{
+	{Path: "properties.platform.osDisk.sizeGiB", Type: "integer", Minimum: intPtr(64), Immutable: true},
+	{Path: "properties.platform.osDisk.diskType", Type: "string", Enum: []string{"Ephemeral", "Managed"}, Immutable: true},
+})
+
+// The externalAuths schema did not change between the two API versions
+var externalAuthRules = []fieldRule{
+	{Path: "properties", Required: true, Type: "object"},
+	{Path: "properties.issuer", Required: true, Type: "object"},
+	{Path: "properties.issuer.url", Required: true, Type: "string", Format: "url", Immutable: true},
+	{Path: "properties.issuer.audiences", Required: true, Type: "array"},
+	{Path: "properties.claim.mappings.username.claim", Required: true, Type: "string"},
+	{Path: "properties.claim.mappings.username.prefixPolicy", Type: "string", Enum: prefixPolicies},
+	{Path: "properties.claim.mappings.groups.claim", RequiredIfParent: true, Type: "string"},
+	{Path: "properties.clients", Type: "array"},
+	{Path: "properties.clients[].clientId", RequiredIfParent: true, Type: "string"},
+	{Path: "properties.clients[].type", RequiredIfParent: true, Type: "string", Enum: []string{"Confidential", "Public"}},
+	{Path: "properties.clients[].component.name", RequiredIfParent: true, Type: "string"},
+	{Path: "properties.clients[].component.authClientNamespace", RequiredIfParent: true, Type: "string"},
+}
+
+// validationSchemas maps api-version -> resource type -> field rules
+var validationSchemas = map[string]map[string][]fieldRule{
+	apiVersion20240610Preview: {
+		"hcpOpenShiftClusters": clusterRules20240610,
+		"nodePools":            nodePoolRules20240610,
+		"externalAuths":        externalAuthRules,
+	},
+	apiVersion20251223Preview: {
+		"hcpOpenShiftClusters": clusterRules20251223,
+		"nodePools":            nodePoolRules20251223,
+		"externalAuths":        externalAuthRules,
+	},
+}
+
+// isValidatedResourceType reports whether request bodies of this resource
+// type are checked when validation is enabled
+func isValidatedResourceType(resourceType string) bool {
+	return resourceType == "hcpOpenShiftClusters" || resourceType == "nodePools" || resourceType == "externalAuths"
+}
+
+// validateResourceBody checks a PUT or PATCH body against the schema for the
+// given api-version. existing is the stored resource for updates (nil on
+// create) and is used to enforce immutable fields. For PATCH only the fields
+// present in the body are checked.
+func validateResourceBody(resourceType, apiVersion string, body map[string]interface{}, existing *Resource, patch bool) *CloudErrorBody {
+	if apiVersion == "" {
+		return &CloudErrorBody{
+			Code:    "MissingApiVersionParameter",
+			Message: "The api-version query parameter (?api-version=) is required for all requests.",
+		}
+	}
+
+	rules, ok := validationSchemas[apiVersion][resourceType]
+	if !ok {
+		return &CloudErrorBody{
+			Code: "NoRegisteredProviderFound",
+			Message: fmt.Sprintf("No registered resource provider found for API version '%s' and type '%s'. The supported api-versions are '%s'.",
+				apiVersion, resourceType, strings.Join(supportedAPIVersions(), ", ")),
+		}
+	}
+
+	var oldBody map[string]interface{}
+	if existing != nil {
+		oldBody = existing.toBody()
+	}
+
+	var errs []CloudErrorBody
+	var missing []string
+	reportMissing := func(path string) {
+		// Only report the outermost missing field
+		for _, m := range missing {
+			if strings.HasPrefix(path, m+".") {
+				return
+			}
+		}
+		missing = append(missing, path)
+		errs = append(errs, invalidField(path, "Missing required field '%s'", path))
+	}
+
+	for _, rule := range rules {
+		values := resolvePath(body, rule.Path)
+		for _, fv := range values {
+			if !fv.found {
+				if !patch && (rule.Required || rule.RequiredIfParent) {
+					reportMissing(fv.path)
+				}
+				continue
+			}
+			if err := checkFieldValue(rule, fv); err != nil {
+				errs = append(errs, *err)
+			}
+		}
+
+		if !patch && rule.Required && len(values) == 0 && !strings.Contains(rule.Path, "[]") {
+			reportMissing(rule.Path)
+		}
+
+		if rule.Immutable && oldBody != nil && !strings.Contains(rule.Path, "[]") {
+			if err := checkImmutable(rule.Path, oldBody, body, patch); err != nil {
+				errs = append(errs, *err)
+			}
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return &errs[0]
+	default:
+		return &CloudErrorBody{
+			Code:    "MultipleErrorsOccurred",
+			Message: "Content validation failed on multiple fields",
+			Details: errs,
+		}
+	}
+}
+
+func supportedAPIVersions() []string {
+	versions := make([]string, 0, len(validationSchemas))
+	for v := range validationSchemas {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+func invalidField(target, format string, args ...interface{}) CloudErrorBody {
+	return CloudErrorBody{
+		Code:    "InvalidRequestContent",
+		Message: fmt.Sprintf(format, args...),
+		Target:  target,
+	}
+}
+
+// fieldValue is one concrete field reached while walking a rule path
+type fieldValue struct {
+	path  string
+	value interface{}
+	found bool
+}
+
+// resolvePath returns the values addressed by path. Fields whose enclosing
+// object is missing are not returned at all; fields whose enclosing object
+// exists but which are absent are returned with found=false.
+func resolvePath(body map[string]interface{}, path string) []fieldValue {
+	var out []fieldValue
+	resolveSegments(body, strings.Split(path, "."), "", &out)
+	return out
+}
+
+func resolveSegments(cur interface{}, segments []string, prefix string, out *[]fieldValue) {
+	obj, ok := cur.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	key := strings.TrimSuffix(segments[0], "[]")
+	isArray := key != segments[0]
+	path := key
+	if prefix != "" {
+		path = prefix + "." + key
+	}
+
+	value, exists := obj[key]
+	if len(segments) == 1 {
+		*out = append(*out, fieldValue{path: path, value: value, found: exists && value != nil})
+		return
+	}
+	if !exists || value == nil {
+		return
+	}
+
+	if isArray {
+		items, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			resolveSegments(item, segments[1:], fmt.Sprintf("%s[%d]", path, i), out)
+		}
+		return
+	}
+	resolveSegments(value, segments[1:], path, out)
+}
+
+func checkFieldValue(rule fieldRule, fv fieldValue) *CloudErrorBody {
+	switch rule.Type {
+	case "string":
+		if _, ok := fv.value.(string); !ok {
+			err := invalidField(fv.path, "Field '%s' must be a string", fv.path)
+			return &err
+		}
+	case "integer":
+		n, ok := fv.value.(float64)
+		if !ok || n != float64(int64(n)) {
+			err := invalidField(fv.path, "Field '%s' must be an integer", fv.path)
+			return &err
+		}
+		if rule.Minimum != nil && n < float64(*rule.Minimum) {
+			err := invalidField(fv.path, "Invalid value '%v' for field '%s' (must be at least %d)", n, fv.path, *rule.Minimum)
+			return &err
+		}
+		if rule.Maximum != nil && n > float64(*rule.Maximum) {
+			err := invalidField(fv.path, "Invalid value '%v' for field '%s' (must be at most %d)", n, fv.path, *rule.Maximum)
+			return &err
+		}
+	case "boolean":
+		if _, ok := fv.value.(bool); !ok {
+			err := invalidField(fv.path, "Field '%s' must be a boolean", fv.path)
+			return &err
+		}
+	case "array":
+		if _, ok := fv.value.([]interface{}); !ok {
+			err := invalidField(fv.path, "Field '%s' must be an array", fv.path)
+			return &err
+		}
+	case "object":
+		if _, ok := fv.value.(map[string]interface{}); !ok {
+			err := invalidField(fv.path, "Field '%s' must be an object", fv.path)
+			return &err
+		}
+	}
+
+	s, _ := fv.value.(string)
+
+	if len(rule.Enum) > 0 {
+		valid := false
+		for _, allowed := range rule.Enum {
+			if strings.EqualFold(s, allowed) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			err := invalidField(fv.path, "Invalid value '%s' for field '%s' (must be one of: %s)", s, fv.path, strings.Join(rule.Enum, ", "))
+			return &err
+		}
+	}
+
+	switch rule.Format {
+	case "cidr":
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			e := invalidField(fv.path, "Invalid CIDR '%s' for field '%s'", s, fv.path)
+			return &e
+		}
+	case "armId":
+		lower := strings.ToLower(s)
+		if !strings.HasPrefix(lower, "/subscriptions/") || !strings.Contains(lower, "/providers/") {
+			e := invalidField(fv.path, "Invalid resource ID '%s' for field '%s'", s, fv.path)
+			return &e
+		}
+	case "url":
+		if u, err := url.Parse(s); err != nil || u.Scheme != "https" || u.Host == "" {
+			e := invalidField(fv.path, "Invalid URL '%s' for field '%s' (must be an absolute https URL)", s, fv.path)
+			return &e
+		}
+	}
+
+	return nil
+}
+
+// checkImmutable rejects a change to an immutable field. A PUT that omits
+// a previously set field counts as a change; a PATCH that omits it does not.
+func checkImmutable(path string, oldBody, newBody map[string]interface{}, patch bool) *CloudErrorBody {
+	oldValues := resolvePath(oldBody, path)
+	if len(oldValues) == 0 || !oldValues[0].found {
+		return nil
+	}
+
+	var newValue interface{}
+	newValues := resolvePath(newBody, path)
+	if len(newValues) > 0 && newValues[0].found {
+		newValue = newValues[0].value
+	} else if patch {
+		return nil
+	}
+
+	if !reflect.DeepEqual(oldValues[0].value, newValue) {
+		err := invalidField(path, "Field '%s' cannot be changed after creation", path)
+		return &err
+	}
+	return nil
+}
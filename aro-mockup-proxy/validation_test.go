@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const (
+	testSubnetID = "/subscriptions/s1/resourceGroups/rg1/providers/Microsoft.Network/virtualNetworks/v1/subnets/s1"
+	testNSGID    = "/subscriptions/s1/resourceGroups/rg1/providers/Microsoft.Network/networkSecurityGroups/n1"
+)
+
+func mustBody(t *testing.T, doc string) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &body); err != nil {
+		t.Fatalf("invalid test body %s: %v", doc, err)
+	}
+	return body
+}
+
+func TestValidateResourceBody(t *testing.T) {
+	cluster20240610 := `{"location":"eastus","properties":{"version":{"id":"4.19","channelGroup":"stable"},
+		"platform":{"subnetId":"` + testSubnetID + `","networkSecurityGroupId":"` + testNSGID + `"}}}`
+	cluster20251223 := `{"location":"eastus","properties":{"version":{"id":"4.19"},
+		"platform":{"subnetId":"` + testSubnetID + `","vnetIntegrationSubnetId":"` + testSubnetID + `",
+		"networkSecurityGroupId":"` + testNSGID + `","operatorsAuthentication":{}}}}`
+	nodePool := func(sizeGiB string) string {
+		return `{"properties":{"version":{"id":"4.19"},"platform":{"vmSize":"Standard_D8s_v3","osDisk":{"sizeGiB":` + sizeGiB + `}}}}`
+	}
+
+	tests := []struct {
+		name         string
+		resourceType string
+		apiVersion   string
+		body         string
+		existing     *Resource
+		patch        bool
+		wantCode     string
+		wantTarget   string
+	}{
+		{
+			name: "valid cluster", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20240610Preview,
+			body: cluster20240610,
+		},
+		{
+			name: "missing api-version", resourceType: "hcpOpenShiftClusters", apiVersion: "",
+			body: cluster20240610, wantCode: "MissingApiVersionParameter",
+		},
+		{
+			name: "unknown api-version", resourceType: "hcpOpenShiftClusters", apiVersion: "2020-01-01",
+			body: cluster20240610, wantCode: "NoRegisteredProviderFound",
+		},
+		{
+			name: "missing parent reported once", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20240610Preview,
+			body:     `{"location":"eastus","properties":{"platform":{"subnetId":"` + testSubnetID + `","networkSecurityGroupId":"` + testNSGID + `"}}}`,
+			wantCode: "InvalidRequestContent", wantTarget: "properties.version",
+		},
+		{
+			name: "enum", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20240610Preview,
+			body: `{"location":"eastus","properties":{"version":{"id":"4.19","channelGroup":"beta"},
+				"platform":{"subnetId":"` + testSubnetID + `","networkSecurityGroupId":"` + testNSGID + `"}}}`,
+			wantCode: "InvalidRequestContent", wantTarget: "properties.version.channelGroup",
+		},
+		{
+			name: "cidr", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20240610Preview,
+			body: `{"location":"eastus","properties":{"version":{"id":"4.19"},"network":{"podCidr":"10.128.0.0"},
+				"platform":{"subnetId":"` + testSubnetID + `","networkSecurityGroupId":"` + testNSGID + `"}}}`,
+			wantCode: "InvalidRequestContent", wantTarget: "properties.network.podCidr",
+		},
+		{
+			name: "armId", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20240610Preview,
+			body: `{"location":"eastus","properties":{"version":{"id":"4.19"},
+				"platform":{"subnetId":"subnet-1","networkSecurityGroupId":"` + testNSGID + `"}}}`,
+			wantCode: "InvalidRequestContent", wantTarget: "properties.platform.subnetId",
+		},
+		{
+			name: "several errors", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20240610Preview,
+			body:     `{"properties":{"version":{"id":4},"platform":{"subnetId":"` + testSubnetID + `","networkSecurityGroupId":"` + testNSGID + `"}}}`,
+			wantCode: "MultipleErrorsOccurred",
+		},
+		{
+			name: "immutable on PUT", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20240610Preview,
+			body:     `{"location":"westus","properties":{"version":{"id":"4.19"},"platform":{"subnetId":"` + testSubnetID + `","networkSecurityGroupId":"` + testNSGID + `"}}}`,
+			existing: &Resource{Location: "eastus", Properties: `{"version":{"id":"4.19"},"platform":{"subnetId":"` + testSubnetID + `","networkSecurityGroupId":"` + testNSGID + `"}}`},
+			wantCode: "InvalidRequestContent", wantTarget: "location",
+		},
+		{
+			name: "PATCH only checks present fields", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20240610Preview,
+			body:     `{"properties":{"version":{"channelGroup":"fast"}}}`,
+			existing: &Resource{Location: "eastus", Properties: `{"version":{"id":"4.19"}}`}, patch: true,
+		},
+		{
+			name: "2025-12-23 requires vnetIntegrationSubnetId", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20251223Preview,
+			body: `{"location":"eastus","properties":{"version":{"id":"4.19"},
+				"platform":{"subnetId":"` + testSubnetID + `","networkSecurityGroupId":"` + testNSGID + `","operatorsAuthentication":{}}}}`,
+			wantCode: "InvalidRequestContent", wantTarget: "properties.platform.vnetIntegrationSubnetId",
+		},
+		{
+			name: "valid 2025-12-23 cluster", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20251223Preview,
+			body: cluster20251223,
+		},
+		{
+			name: "2025-12-23 KMS vault on the KMS object", resourceType: "hcpOpenShiftClusters", apiVersion: apiVersion20251223Preview,
+			body: `{"location":"eastus","properties":{"version":{"id":"4.19"},
+				"platform":{"subnetId":"` + testSubnetID + `","vnetIntegrationSubnetId":"` + testSubnetID + `",
+				"networkSecurityGroupId":"` + testNSGID + `","operatorsAuthentication":{}},
+				"etcd":{"dataEncryption":{"customerManaged":{"kms":{"visibility":"Public","activeKey":{"name":"k"}}}}}}}`,
+			wantCode: "InvalidRequestContent", wantTarget: "properties.etcd.dataEncryption.customerManaged.kms.vaultName",
+		},
+		{
+			name: "2024-06-10 node pool small disk", resourceType: "nodePools", apiVersion: apiVersion20240610Preview,
+			body: nodePool("32"),
+		},
+		{
+			name: "2025-12-23 node pool small disk", resourceType: "nodePools", apiVersion: apiVersion20251223Preview,
+			body: nodePool("32"), wantCode: "InvalidRequestContent", wantTarget: "properties.platform.osDisk.sizeGiB",
+		},
+		{
+			name: "external auth issuer URL", resourceType: "externalAuths", apiVersion: apiVersion20251223Preview,
+			body:     `{"properties":{"issuer":{"url":"http://issuer","audiences":["a"]},"claim":{"mappings":{"username":{"claim":"sub"}}}}}`,
+			wantCode: "InvalidRequestContent", wantTarget: "properties.issuer.url",
+		},
+		{
+			name: "array elements", resourceType: "nodePools", apiVersion: apiVersion20240610Preview,
+			body: `{"properties":{"version":{"id":"4.19"},"platform":{"vmSize":"Standard_D8s_v3"},
+				"taints":[{"key":"a","effect":"NoSchedule"},{"key":"b","effect":"Sometimes"}]}}`,
+			wantCode: "InvalidRequestContent", wantTarget: "properties.taints[1].effect",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateResourceBody(tt.resourceType, tt.apiVersion, mustBody(t, tt.body), tt.existing, tt.patch)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %+v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %s", tt.wantCode)
+			}
+			if err.Code != tt.wantCode || err.Target != tt.wantTarget {
+				t.Errorf("got %s on %q (%s), want %s on %q", err.Code, err.Target, err.Message, tt.wantCode, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestVersionRules(t *testing.T) {
+	base := []fieldRule{
+		{Path: "a", Type: "string"},
+		{Path: "b", Type: "integer", Minimum: intPtr(1)},
+		{Path: "c", Type: "string"},
+	}
+	rules := versionRules(base, []fieldRule{
+		{Path: "b", Type: "integer", Minimum: intPtr(64)},
+		{Path: "d", Type: "boolean"},
+	}, "c")
+
+	var paths []string
+	for _, rule := range rules {
+		paths = append(paths, rule.Path)
+	}
+	if want := []string{"a", "b", "d"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("paths %v, want %v", paths, want)
+	}
+	if *rules[1].Minimum != 64 {
+		t.Errorf("b minimum %d, want the replaced 64", *rules[1].Minimum)
+	}
+	if *base[1].Minimum != 1 || len(base) != 3 {
+		t.Errorf("base rules modified: %+v", base)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed versions.yaml
+var defaultVersionCatalog []byte
+
+// versionCatalog lists the OpenShift versions of each channel group and the
+// upgrades between them. See versions.yaml for the file format.
+type versionCatalog struct {
+	ChannelGroups map[string][]catalogVersion `json:"channelGroups"`
+}
+
+type catalogVersion struct {
+	Version            string   `json:"version"`
+	Enabled            bool     `json:"enabled"`
+	EndOfLifeTimestamp string   `json:"endOfLifeTimestamp,omitempty"`
+	Upgrades           []string `json:"upgrades,omitempty"`
+}
+
+// loadVersionCatalog reads the catalog from path, or the built-in
+// versions.yaml when path is empty
+func loadVersionCatalog(path string) (*versionCatalog, error) {
+	data := defaultVersionCatalog
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var catalog versionCatalog
+	if err := yaml.UnmarshalStrict(data, &catalog); err != nil {
+		return nil, fmt.Errorf("parsing version catalog: %w", err)
+	}
+	for group, versions := range catalog.ChannelGroups {
+		known := map[string]bool{}
+		for _, v := range versions {
+			known[v.Version] = true
+		}
+		for _, v := range versions {
+			for _, to := range v.Upgrades {
+				if !known[to] {
+					return nil, fmt.Errorf("channel group %s: upgrade from %s to unknown version %s", group, v.Version, to)
+				}
+			}
+		}
+	}
+	return &catalog, nil
+}
+
+// normalizeVersion strips the "openshift-v" prefix some templates use
+func normalizeVersion(id string) string {
+	return strings.TrimPrefix(id, "openshift-v")
+}
+
+// matching returns the versions of a channel group that a version ID
+// refers to: the exact version, or every version of an X.Y minor
+func (c *versionCatalog) matching(channelGroup, id string) []catalogVersion {
+	id = normalizeVersion(id)
+	var result []catalogVersion
+	for _, v := range c.ChannelGroups[channelGroup] {
+		if v.Version == id || strings.HasPrefix(v.Version, id+".") {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// resourceVersion returns properties.version.id and channelGroup of a
+// resource body; the channel group defaults to stable like in the RP
+func resourceVersion(body map[string]interface{}) (id, channelGroup string) {
+	properties, _ := body["properties"].(map[string]interface{})
+	version, _ := properties["version"].(map[string]interface{})
+	id, _ = version["id"].(string)
+	channelGroup, _ = version["channelGroup"].(string)
+	if channelGroup == "" {
+		channelGroup = "stable"
+	}
+	return id, channelGroup
+}
+
+// checkVersion rejects versions the catalog does not offer and upgrades
+// without an edge in the upgrade graph. existing is nil on create; a
+// request that keeps the stored version is not checked. It returns whether
+// the request upgrades the resource.
+func (c *versionCatalog) checkVersion(body map[string]interface{}, existing *Resource) (bool, *CloudErrorBody) {
+	id, channelGroup := resourceVersion(body)
+	if id == "" {
+		return false, nil
+	}
+
+	// A resource keeps its version when it is disabled in the catalog later
+	fromID := ""
+	if existing != nil {
+		fromID, _ = resourceVersion(existing.toBody())
+		if normalizeVersion(fromID) == normalizeVersion(id) {
+			return false, nil
+		}
+	}
+
+	var available []catalogVersion
+	for _, v := range c.matching(channelGroup, id) {
+		if v.Enabled {
+			available = append(available, v)
+		}
+	}
+	if len(available) == 0 {
+		return false, &CloudErrorBody{
+			Code:    "InvalidRequestContent",
+			Message: fmt.Sprintf("Version '%s' is not available in channel group '%s'.", id, channelGroup),
+			Target:  "properties.version.id",
+		}
+	}
+
+	if fromID == "" {
+		return false, nil
+	}
+
+	for _, from := range c.matching(channelGroup, fromID) {
+		for _, edge := range from.Upgrades {
+			for _, to := range available {
+				if edge == to.Version {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, &CloudErrorBody{
+		Code:    "InvalidRequestContent",
+		Message: fmt.Sprintf("Upgrading from version '%s' to '%s' is not allowed in channel group '%s'.", fromID, id, channelGroup),
+		Target:  "properties.version.id",
+	}
+}
+
+// checkResourceVersion runs checkVersion for the resource types that carry
+// an OpenShift version
+func (p *AROHCPMockProxyEnhanced) checkResourceVersion(resourceType string, body map[string]interface{}, existing *Resource) (bool, *CloudErrorBody) {
+	if resourceType != "hcpOpenShiftClusters" && resourceType != "nodePools" {
+		return false, nil
+	}
+	return p.versions.checkVersion(body, existing)
+}
+
+// upgradePhase is the phase an upgrade reports once its operation has
+// reached MinPercent
+type upgradePhase struct {
+	MinPercent int
+	Name       string
+}
+
+var upgradePhases = map[string][]upgradePhase{
+	"hcpOpenShiftClusters": {
+		{0, "ValidatingUpgrade"},
+		{25, "UpgradingControlPlane"},
+		{75, "UpgradingClusterOperators"},
+	},
+	"nodePools": {
+		{0, "ValidatingUpgrade"},
+		{25, "UpgradingNodes"},
+	},
+}
+
+// newUpgradeStatus returns the read-only properties.upgradeStatus recorded
+// when a resource starts upgrading
+func newUpgradeStatus(existing *Resource, toVersion string, now time.Time) map[string]interface{} {
+	fromVersion, _ := resourceVersion(existing.toBody())
+	return map[string]interface{}{
+		"fromVersion": fromVersion,
+		"toVersion":   toVersion,
+		"startTime":   now.UTC().Format(time.RFC3339),
+	}
+}
+
+// decorateUpgradeStatus fills in the current phase of properties.upgradeStatus
+// from the progress of the resource's Update operation
+func (p *AROHCPMockProxyEnhanced) decorateUpgradeStatus(r *Resource, props map[string]interface{}) {
+	status, ok := props["upgradeStatus"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	switch r.ProvisioningState {
+	case "Succeeded":
+		status["phase"] = "Completed"
+	case "Failed", "Canceled":
+		status["phase"] = "Failed"
+	default:
+		percent := 0
+		if op := p.asyncOps.ActiveOperation(r.ID); op != nil {
+			op.mu.RLock()
+			percent = op.PercentComplete
+			op.mu.RUnlock()
+		}
+		for _, phase := range upgradePhases[r.ResourceType] {
+			if percent >= phase.MinPercent {
+				status["phase"] = phase.Name
+			}
+		}
+	}
+}
+
+func (p *AROHCPMockProxyEnhanced) handleHcpOpenShiftVersions(w http.ResponseWriter, r *http.Request, parsed *ARMPath) {
+	if r.Method != "GET" {
+		writeCloudError(w, http.StatusMethodNotAllowed, methodNotAllowed(r.Method))
+		return
+	}
+
+	versionResponse := func(channelGroup string, v catalogVersion) map[string]interface{} {
+		properties := map[string]interface{}{
+			"channelGroup": channelGroup,
+			"enabled":      v.Enabled,
+		}
+		if v.EndOfLifeTimestamp != "" {
+			properties["endOfLifeTimestamp"] = v.EndOfLifeTimestamp
+		}
+		return map[string]interface{}{
+			"id":         fmt.Sprintf("/subscriptions/%s/providers/Microsoft.RedHatOpenShift/locations/%s/hcpOpenShiftVersions/%s", parsed.SubscriptionID, parsed.Location, v.Version),
+			"name":       v.Version,
+			"type":       "Microsoft.RedHatOpenShift/hcpOpenShiftVersions",
+			"properties": properties,
+		}
+	}
+
+	versions := []map[string]interface{}{}
+	for _, channelGroup := range sortedKeys(p.versions.ChannelGroups) {
+		for _, v := range p.versions.ChannelGroups[channelGroup] {
+			versions = append(versions, versionResponse(channelGroup, v))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if parsed.ResourceName == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"value": versions,
+		})
+		return
+	}
+
+	// Get specific version
+	for _, v := range versions {
+		if v["name"] == parsed.ResourceName {
+			json.NewEncoder(w).Encode(v)
+			return
+		}
+	}
+	log.Printf("Version %s not found in catalog", parsed.ResourceName)
+	writeCloudError(w, http.StatusNotFound, &CloudErrorBody{
+		Code:    "ResourceNotFound",
+		Message: fmt.Sprintf("OpenShift version '%s' was not found in location '%s'.", parsed.ResourceName, parsed.Location),
+	})
+}
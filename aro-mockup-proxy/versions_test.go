@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadVersionCatalog(t *testing.T) {
+	catalog, err := loadVersionCatalog("")
+	if err != nil {
+		t.Fatalf("built-in catalog: %v", err)
+	}
+	// The default node pool version of doc/ARO-capz.md
+	if len(catalog.matching("stable", "4.20.0")) != 1 {
+		t.Errorf("built-in catalog does not offer 4.20.0")
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "valid", content: "channelGroups:\n  stable:\n  - version: \"4.19.1\"\n    enabled: true\n    upgrades: [\"4.20.0\"]\n  - version: \"4.20.0\"\n    enabled: true\n"},
+		{name: "unknown upgrade", content: "channelGroups:\n  stable:\n  - version: \"4.19.1\"\n    upgrades: [\"4.20.0\"]\n", wantErr: "unknown version 4.20.0"},
+		{name: "unknown field", content: "channelGroups:\n  stable:\n  - version: \"4.19.1\"\n    enable: true\n", wantErr: "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "versions.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadVersionCatalog(path)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("loadVersionCatalog: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVersionCatalogMatching(t *testing.T) {
+	catalog := &versionCatalog{ChannelGroups: map[string][]catalogVersion{
+		"stable": {{Version: "4.2.1"}, {Version: "4.20.1"}, {Version: "4.20.10"}},
+	}}
+
+	tests := []struct {
+		channelGroup string
+		id           string
+		want         string
+	}{
+		{channelGroup: "stable", id: "4.20.1", want: "4.20.1"},
+		{channelGroup: "stable", id: "openshift-v4.20.1", want: "4.20.1"},
+		{channelGroup: "stable", id: "4.20", want: "4.20.1,4.20.10"},
+		{channelGroup: "stable", id: "4.2", want: "4.2.1"},
+		{channelGroup: "stable", id: "4.20.2"},
+		{channelGroup: "candidate", id: "4.20.1"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, v := range catalog.matching(tt.channelGroup, tt.id) {
+			got = append(got, v.Version)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("matching(%s, %s) = %v, want %s", tt.channelGroup, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestResourceVersion(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		wantID           string
+		wantChannelGroup string
+	}{
+		{name: "version and channel group", body: `{"properties":{"version":{"id":"4.20","channelGroup":"candidate"}}}`, wantID: "4.20", wantChannelGroup: "candidate"},
+		{name: "default channel group", body: `{"properties":{"version":{"id":"4.20"}}}`, wantID: "4.20", wantChannelGroup: "stable"},
+		{name: "no version", body: `{"properties":{}}`, wantChannelGroup: "stable"},
+		{name: "no properties", body: `{}`, wantChannelGroup: "stable"},
+		{name: "properties not an object", body: `{"properties":"4.20"}`, wantChannelGroup: "stable"},
+		{name: "version not an object", body: `{"properties":{"version":"4.20"}}`, wantChannelGroup: "stable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, channelGroup := resourceVersion(mustBody(t, tt.body))
+			if id != tt.wantID || channelGroup != tt.wantChannelGroup {
+				t.Errorf("resourceVersion = %q, %q, want %q, %q", id, channelGroup, tt.wantID, tt.wantChannelGroup)
+			}
+		})
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	catalog := &versionCatalog{ChannelGroups: map[string][]catalogVersion{
+		"stable": {
+			{Version: "4.18.1", Enabled: false, Upgrades: []string{"4.19.7"}},
+			{Version: "4.19.7", Enabled: true, Upgrades: []string{"4.20.5"}},
+			{Version: "4.20.5", Enabled: true},
+		},
+		"candidate": {
+			{Version: "4.21.0", Enabled: true},
+		},
+	}}
+	properties := func(id, channelGroup string) string {
+		return `{"version":{"id":"` + id + `","channelGroup":"` + channelGroup + `"}}`
+	}
+	version := func(id, channelGroup string) string {
+		return `{"properties":` + properties(id, channelGroup) + `}`
+	}
+
+	tests := []struct {
+		name          string
+		body          string
+		existing      string // stored properties on an update
+		wantUpgrading bool
+		wantErr       string
+	}{
+		{name: "enabled", body: version("4.19.7", "stable")},
+		{name: "minor", body: version("4.20", "")},
+		{name: "prefixed", body: version("openshift-v4.20.5", "stable")},
+		{name: "no version", body: `{"properties":{}}`},
+		{name: "no properties", body: `{}`},
+		{name: "disabled", body: version("4.18.1", "stable"), wantErr: "not available"},
+		{name: "unknown", body: version("4.17.0", "stable"), wantErr: "not available"},
+		{name: "other channel group", body: version("4.21.0", "stable"), wantErr: "not available in channel group 'stable'"},
+		{name: "its channel group", body: version("4.21.0", "candidate")},
+		{name: "keeps a disabled version", body: version("4.18.1", "stable"), existing: properties("openshift-v4.18.1", "stable")},
+		{name: "upgrade", body: version("4.19.7", "stable"), existing: properties("4.18.1", "stable"), wantUpgrading: true},
+		{name: "upgrade to a minor", body: version("4.20", "stable"), existing: properties("4.19.7", "stable"), wantUpgrading: true},
+		{name: "upgrade without an edge", body: version("4.20.5", "stable"), existing: properties("4.18.1", "stable"), wantErr: "not allowed"},
+		{name: "downgrade", body: version("4.19.7", "stable"), existing: properties("4.20.5", "stable"), wantErr: "not allowed"},
+		{name: "upgrade to a disabled version", body: version("4.18.1", "stable"), existing: properties("4.19.7", "stable"), wantErr: "not available"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var existing *Resource
+			if tt.existing != "" {
+				existing = &Resource{Properties: tt.existing}
+			}
+			upgrading, verr := catalog.checkVersion(mustBody(t, tt.body), existing)
+			if tt.wantErr != "" {
+				if verr == nil || !strings.Contains(verr.Message, tt.wantErr) || verr.Target != "properties.version.id" {
+					t.Fatalf("error %+v, want one on properties.version.id containing %q", verr, tt.wantErr)
+				}
+				return
+			}
+			if verr != nil {
+				t.Fatalf("unexpected error %+v", verr)
+			}
+			if upgrading != tt.wantUpgrading {
+				t.Errorf("upgrading %v, want %v", upgrading, tt.wantUpgrading)
+			}
+		})
+	}
+}
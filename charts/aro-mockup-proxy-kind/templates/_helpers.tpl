@@ -58,3 +58,19 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Whether the admin API listens beyond the loopback address, so that it is
+exposed on the service
+*/}}
+{{- define "aro-mockup-proxy.adminExposed" -}}
+{{- $host := .Values.config.adminPort | splitList ":" | initial | join ":" }}
+{{- if and .Values.config.adminPort (not (or (hasPrefix "127." $host) (eq $host "localhost") (eq $host "[::1]"))) }}true{{ end }}
+{{- end }}
+
+{{/*
+Container port of the admin API, the port of config.adminPort
+*/}}
+{{- define "aro-mockup-proxy.adminContainerPort" -}}
+{{- .Values.config.adminPort | splitList ":" | last }}
+{{- end }}
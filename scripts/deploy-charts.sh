@@ -113,6 +113,11 @@ if [ "$DO_DEPLOY" = true ] ; then
             DEV_ENDPOINT_ARG="--set config.devEndpoint=$DEV_ENDPOINT --set kubeconfig.secretName="
             echo "  DEV_ENDPOINT: $DEV_ENDPOINT (hcpOpenShiftCluster requests will be forwarded)"
         fi
+        # Serve ResourceGroup/KeyVault from the mock instead of real Azure
+        if [ "$PROJECT" = "aro-mockup-proxy" -a "${ARO_MOCK_OFFLINE:-}" = "true" ] ; then
+            DEV_ENDPOINT_ARG="$DEV_ENDPOINT_ARG --set config.offlineMode=true"
+            echo "  ARO_MOCK_OFFLINE: true (non ARO-HCP requests will not reach Azure)"
+        fi
         echo "      HELM ARGS: --set Release.Namespace=$NAMESPACE" ${helm_add_args_a[$T]} $DEV_ENDPOINT_ARG
         HELM_NAME_ARG=""
         [ -n "$HELM_RELEASE_NAME" ] && HELM_NAME_ARG="--name-template=$HELM_RELEASE_NAME"